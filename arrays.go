@@ -0,0 +1,147 @@
+package main
+
+import (
+	"go/constant"
+	"go/token"
+)
+
+// elemOf returns the type of the elements of values of type t, reporting an
+// error if they can't be indexed. strings can be indexed to read their bytes.
+func (c *checker) elemOf(t tawaType, at Span) tawaType {
+	switch kind := underlying(t).(type) {
+	case *arrayType:
+		return kind.elem
+	case *sliceType:
+		return kind.elem
+	}
+	if isKind(underlying(t), basicString) {
+		return typeByte
+	}
+
+	if t != typeInvalid {
+		c.errorf(ErrNotIndexable, at, "cannot index a value of type '%s'", t)
+	}
+	return nil
+}
+
+// indexExpr checks an index of a value of type of, or a bound of a slice of
+// one, which can be of any integer type. constant ones are checked against the
+// length of arrays, which a bound can be equal to.
+func (c *checker) indexExpr(e Expression, of tawaType, bound bool) Typed {
+	idx := c.exprExpecting(e, typeInt64)
+	if idx.Kind != typeInvalid && !isKind(underlying(idx.Kind), basicInt) {
+		c.errorf(ErrMismatchedTypes, posOf(e), "index has type '%s', not an integer type", idx.Kind)
+		return idx
+	}
+
+	val, ok := constantValue(e)
+	if !ok {
+		return idx
+	}
+	n, _ := constant.Int64Val(constant.ToInt(val))
+	if n < 0 {
+		c.errorf(ErrOutOfRange, posOf(e), "invalid index %d: indices can't be negative", n)
+	} else if array, ok := underlying(of).(*arrayType); ok && (n > array.length || n == array.length && !bound) {
+		c.errorf(ErrOutOfRange, posOf(e), "index %d is out of range for '%s'", n, of)
+	}
+	return idx
+}
+
+func (c *checker) index(expr Index) Typed {
+	of := c.expr(expr.Of)
+	elem := c.elemOf(of.Kind, posOf(expr.Of))
+	idx := c.indexExpr(expr.Index, of.Kind, false)
+
+	if elem == nil {
+		elem = typeInvalid
+	}
+	return Typed{Index{of, idx, expr.Pos}, elem}
+}
+
+func (c *checker) indexAssignment(expr IndexAssignment) Typed {
+	of := c.expr(expr.Of)
+	elem := c.elemOf(of.Kind, posOf(expr.Of))
+	idx := c.indexExpr(expr.Index, of.Kind, false)
+	value := c.exprExpecting(expr.Value, elem)
+	typed := Typed{IndexAssignment{of, idx, value, expr.Pos}, value.Kind}
+
+	if elem == nil {
+		return typed
+	}
+	if !c.addressable(Typed{Index{of, idx, expr.Pos}, elem}) {
+		if isKind(underlying(of.Kind), basicString) {
+			c.errorf(ErrImmutable, expr.Pos, "cannot assign to a byte of a string")
+		} else {
+			c.errorf(ErrImmutable, expr.Pos, "cannot assign to an element of an immutable array")
+		}
+	}
+	if !identical(elem, value.Kind) {
+		c.errorf(ErrMismatchedTypes, posOf(expr.Value), "elements of '%s' have type '%s', not type '%s'", of.Kind, elem, value.Kind)
+	}
+
+	return typed
+}
+
+// subslice checks a slice of an array or slice, which shares the values it
+// was sliced from.
+func (c *checker) subslice(expr Subslice) Typed {
+	of := c.expr(expr.Of)
+	var low, high Expression
+	if expr.Low != nil {
+		low = c.indexExpr(expr.Low, of.Kind, true)
+	}
+	if expr.High != nil {
+		high = c.indexExpr(expr.High, of.Kind, true)
+	}
+	typed := Typed{Subslice{of, low, high, expr.Pos}, typeInvalid}
+
+	lowVal, lowConst := constantValue(expr.Low)
+	highVal, highConst := constantValue(expr.High)
+	if lowConst && highConst && constant.Compare(lowVal, token.GTR, highVal) {
+		c.errorf(ErrOutOfRange, expr.Pos, "invalid slice: %s is greater than %s", lowVal, highVal)
+	}
+
+	switch kind := underlying(of.Kind).(type) {
+	case *arrayType:
+		// the slice points into the variable holding the array, which it can
+		// outlive
+		c.escape(of)
+		typed.Kind = &sliceType{kind.elem}
+	case *sliceType:
+		typed.Kind = of.Kind
+	default:
+		if of.Kind != typeInvalid {
+			c.errorf(ErrNotIndexable, posOf(expr.Of), "cannot slice a value of type '%s'", of.Kind)
+		}
+	}
+	return typed
+}
+
+// arrayLiteral checks a literal of an array or slice type. an array can be
+// given fewer elements than it holds, in which case the rest are zero.
+func (c *checker) arrayLiteral(lit ArrayLiteral, pos Span) Typed {
+	kind := c.resolveType(lit.Kind)
+
+	var elem tawaType = typeInvalid
+	switch k := kind.(type) {
+	case *arrayType:
+		elem = k.elem
+		if int64(len(lit.Elements)) > k.length {
+			c.errorf(ErrOutOfRange, pos, "array literal has %d elements, but '%s' only holds %d", len(lit.Elements), kind, k.length)
+		}
+	case *sliceType:
+		elem = k.elem
+	}
+
+	var elements []Expression
+	for _, e := range lit.Elements {
+		typed := c.exprExpecting(e, elem)
+		if !identical(elem, typed.Kind) {
+			c.errorf(ErrMismatchedTypes, posOf(e), "elements of '%s' have type '%s', not type '%s'", kind, elem, typed.Kind)
+		}
+		elements = append(elements, typed)
+	}
+
+	lit.Kind = resolvedType(lit.Kind, kind, c.typeParams)
+	return Typed{Lit{Literal: ArrayLiteral{lit.Kind, elements}, Pos: pos}, kind}
+}
@@ -22,10 +22,74 @@ func (v FunctionPointer) is_Type() {}
 type Struct []struct {
 	Ident string
 	Kind  Type
+	Pos   Span
 }
 
 func (v Struct) is_Type() {}
 
+type Generic struct {
+	Ident     Identifier
+	Arguments []Type
+}
+
+func (v Generic) is_Type() {}
+
+type Sum []Variant
+
+func (v Sum) is_Type() {}
+
+type Interface []InterfaceMethod
+
+func (v Interface) is_Type() {}
+
+type Array struct {
+	Length int64
+	Elem   Type
+}
+
+func (v Array) is_Type() {}
+
+type Slice struct {
+	Elem Type
+}
+
+func (v Slice) is_Type() {}
+
+type Pointer struct {
+	Elem Type
+}
+
+func (v Pointer) is_Type() {}
+
+type Variant struct {
+	Ident   Identifier
+	Payload *Type
+	Pos     Span
+}
+type InterfaceMethod struct {
+	Ident     Identifier
+	Arguments []struct {
+		Ident Identifier
+		Kind  Type
+	}
+	Returns *Type
+}
+type Receiver struct {
+	Ident Identifier
+	Kind  Identifier
+}
+type Capture struct {
+	Ident Identifier
+	// ByReference is set for variables declared with var, which a closure
+	// shares with the function it's created in
+	ByReference bool
+}
+type MatchArm struct {
+	// Variant is _ for an arm matching every variant not matched before
+	Variant Identifier
+	Binding *Identifier
+	Body    Expression
+}
 type Literal interface {
 	is_Literal()
 }
@@ -33,13 +97,29 @@ type Integer int64
 
 func (v Integer) is_Literal() {}
 
+type Float float64
+
+func (v Float) is_Literal() {}
+
+type Char rune
+
+func (v Char) is_Literal() {}
+
 type StructLiteral struct {
-	Ident  Identifier
-	Fields map[string]Expression
+	Ident         Identifier
+	TypeArguments []Type
+	Fields        map[string]Expression
 }
 
 func (v StructLiteral) is_Literal() {}
 
+type ArrayLiteral struct {
+	Kind     Type
+	Elements []Expression
+}
+
+func (v ArrayLiteral) is_Literal() {}
+
 type StringLiteral string
 
 func (v StringLiteral) is_Literal() {}
@@ -49,6 +129,9 @@ type Expression interface {
 }
 type Lit struct {
 	Literal
+	Pos Span
+	// Text is how the literal was written, which the formatter keeps
+	Text string
 }
 
 func (v Lit) is_Expression() {}
@@ -74,6 +157,11 @@ func (v Field) is_Expression() {}
 type MutDeclaration struct {
 	To    Identifier
 	Value Expression
+
+	// Captured is set by the checker for variables that closures
+	// capture or whose address is taken, which live on the heap so that
+	// they can outlive the function declaring them
+	Captured bool
 }
 
 func (v MutDeclaration) is_Expression() {}
@@ -95,13 +183,90 @@ type FieldAssignment struct {
 
 func (v FieldAssignment) is_Expression() {}
 
+type Index struct {
+	Of    Expression
+	Index Expression
+	Pos   Span
+}
+
+func (v Index) is_Expression() {}
+
+type IndexAssignment struct {
+	Of    Expression
+	Index Expression
+	Value Expression
+	Pos   Span
+}
+
+func (v IndexAssignment) is_Expression() {}
+
+type DerefAssignment struct {
+	Of    Expression
+	Value Expression
+	Pos   Span
+}
+
+func (v DerefAssignment) is_Expression() {}
+
+type New struct {
+	Kind  Type
+	Value Expression
+	Pos   Span
+}
+
+func (v New) is_Expression() {}
+
+type Subslice struct {
+	Of   Expression
+	Low  Expression
+	High Expression
+	Pos  Span
+}
+
+func (v Subslice) is_Expression() {}
+
 type Call struct {
-	Function  Identifier
-	Arguments []Expression
+	Function      Identifier
+	TypeArguments []Type
+	Arguments     []Expression
+	Pos           Span
 }
 
 func (v Call) is_Expression() {}
 
+type MethodCall struct {
+	Of        Expression
+	Method    Identifier
+	Arguments []Expression
+	Pos       Span
+}
+
+func (v MethodCall) is_Expression() {}
+
+type CallValue struct {
+	Callee    Expression
+	Arguments []Expression
+	Pos       Span
+}
+
+func (v CallValue) is_Expression() {}
+
+type Lambda struct {
+	Arguments []struct {
+		Ident Identifier
+		Kind  Type
+	}
+	Returns *Type
+	Expr    Expression
+	Pos     Span
+
+	// Captures are the variables of the functions around the lambda
+	// that its body uses, which the checker fills in
+	Captures []Capture
+}
+
+func (v Lambda) is_Expression() {}
+
 type Block []Expression
 
 func (v Block) is_Expression() {}
@@ -110,33 +275,125 @@ type If struct {
 	Condition Expression
 	Then      Expression
 	Else      Expression
+	Pos       Span
 }
 
 func (v If) is_Expression() {}
 
+type Binary struct {
+	Op    TokenKind
+	Left  Expression
+	Right Expression
+	Pos   Span
+}
+
+func (v Binary) is_Expression() {}
+
+type Unary struct {
+	Op  TokenKind
+	Of  Expression
+	Pos Span
+}
+
+func (v Unary) is_Expression() {}
+
+type While struct {
+	Condition Expression
+	Body      Expression
+	Pos       Span
+}
+
+func (v While) is_Expression() {}
+
+type For struct {
+	Ident Identifier
+	From  Expression
+	To    Expression
+	Body  Expression
+	Pos   Span
+}
+
+func (v For) is_Expression() {}
+
+type Match struct {
+	Of   Expression
+	Arms []MatchArm
+	Pos  Span
+}
+
+func (v Match) is_Expression() {}
+
+type Break struct {
+	Pos Span
+}
+
+func (v Break) is_Expression() {}
+
+type Continue struct {
+	Pos Span
+}
+
+func (v Continue) is_Expression() {}
+
+type Box struct {
+	Of Expression
+}
+
+func (v Box) is_Expression() {}
+
+type Typed struct {
+	Expr Expression
+	Kind tawaType
+}
+
+func (v Typed) is_Expression() {}
+
 type TopLevel interface {
 	is_TopLevel()
 }
 type Func struct {
-	Ident     Identifier
-	Arguments []struct {
+	Ident Identifier
+	// Receiver is the value methods are called on, nil for functions
+	Receiver   *Receiver
+	TypeParams []Identifier
+	Arguments  []struct {
 		Ident Identifier
 		Kind  Type
 	}
 
 	Returns *Type
 	Expr    Expression
+	Doc     string
+	Pos     Span
+
+	// Instances are the copies of a generic function the checker made
+	// for each list of type arguments it's called with
+	Instances []Func
 }
 
 func (v Func) is_TopLevel() {}
 
-type Import string
+type Import struct {
+	Path  string
+	Alias *Identifier
+	Pos   Span
+
+	// Library is the file the imported package was found in, which
+	// the checker fills in
+	Library string
+}
 
 func (v Import) is_TopLevel() {}
 
 type TypeDeclaration struct {
-	Ident Identifier
-	Kind  Type
+	Ident      Identifier
+	TypeParams []Identifier
+	Kind       Type
+	Doc        string
+
+	// Instances are the copies of a generic type the checker made for
+	// each list of type arguments it's used with
+	Instances []TypeDeclaration
 }
 
 func (v TypeDeclaration) is_TopLevel() {}
@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 func typeToString(t *Type) string {
 	if t == nil {
@@ -10,15 +13,105 @@ func typeToString(t *Type) string {
 	switch v := (*t).(type) {
 	case Ident:
 		return v.Name
+	case Generic:
+		var args []string
+		for _, arg := range v.Arguments {
+			args = append(args, typeToString(&arg))
+		}
+		return v.Ident.Name + "[" + strings.Join(args, ", ") + "]"
+	case Array:
+		return fmt.Sprintf("[%d]%s", v.Length, typeToString(&v.Elem))
+	case Slice:
+		return "[]" + typeToString(&v.Elem)
+	case Pointer:
+		return "*" + typeToString(&v.Elem)
+	case FunctionPointer:
+		var args []string
+		for _, arg := range v.Arguments {
+			args = append(args, typeToString(&arg))
+		}
+		if v.Returns == nil {
+			return fmt.Sprintf("func(%s)", strings.Join(args, ", "))
+		}
+		return fmt.Sprintf("func(%s) %s", strings.Join(args, ", "), typeToString(v.Returns))
+	case Struct:
+		var fields []string
+		for _, field := range v {
+			fields = append(fields, field.Ident+": "+typeToString(&field.Kind))
+		}
+		return fmt.Sprintf("struct { %s }", strings.Join(fields, "; "))
+	case Sum:
+		var variants []string
+		for _, variant := range v {
+			variants = append(variants, variantToString(variant))
+		}
+		return "= | " + strings.Join(variants, " | ")
+	case Interface:
+		if len(v) == 0 {
+			return "interface {}"
+		}
+		var methods []string
+		for _, method := range v {
+			methods = append(methods, method.Ident.Name+parametersToString(method.Arguments, method.Returns))
+		}
+		return "interface { " + strings.Join(methods, "; ") + " }"
 	}
 
 	panic("unhandled")
 }
 
+// parametersToString returns how the parameters and return type of a method
+// are written, like (s: string) int64.
+func parametersToString(params []struct {
+	Ident Identifier
+	Kind  Type
+}, returns *Type) string {
+	var args []string
+	for _, param := range params {
+		args = append(args, param.Ident.Name+": "+typeToString(&param.Kind))
+	}
+	if returns == nil {
+		return "(" + strings.Join(args, ", ") + ")"
+	}
+	return "(" + strings.Join(args, ", ") + ") " + typeToString(returns)
+}
+
+// typeParamsToString returns how the type parameters of a generic function or
+// type are written, like [K, V], or "" if it isn't generic.
+func typeParamsToString(params []Identifier) string {
+	if len(params) == 0 {
+		return ""
+	}
+	var names []string
+	for _, param := range params {
+		names = append(names, param.Name)
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+func variantToString(v Variant) string {
+	if v.Payload == nil {
+		return v.Ident.Name
+	}
+	return v.Ident.Name + " of " + typeToString(v.Payload)
+}
+
+// funcName returns the name of a function, which for methods includes the
+// type they're declared on like Melako.Area.
+func funcName(f Func) string {
+	if f.Receiver != nil {
+		return f.Receiver.Kind.Name + "." + f.Ident.Name
+	}
+	return f.Ident.Name
+}
+
 func (f Func) String() string {
 	var args []string
 	for _, arg := range f.Arguments {
 		args = append(args, typeToString(&arg.Kind))
 	}
-	return fmt.Sprintf("func() %s;", typeToString(f.Returns))
+	if f.Returns == nil {
+		return fmt.Sprintf("func(%s)", strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("func(%s) %s", strings.Join(args, ", "), typeToString(f.Returns))
 }
@@ -22,13 +22,14 @@ func addBuiltins(m *ir.Module) (ret map[string]value.Value) {
 		k, v := fn(m)
 		ret[k] = v
 	}
+	ret["tawa.outOfRange"] = addOutOfRange(m, ret["print"].(*ir.Func))
 
 	return
 }
 
 func addPrint(m *ir.Module) (string, value.Value) {
 	fn := m.NewFunc("print", types.Void, ir.NewParam("input", StringPointer.Type))
-	fn.Visibility = enum.VisibilityHidden
+	fn.Linkage = enum.LinkageInternal
 	entry := fn.NewBlock("entry")
 
 	len := getStructElm(entry, String.Type, fn.Params[0], 0)
@@ -48,3 +49,62 @@ func addPrint(m *ir.Module) (string, value.Value) {
 
 	return "print", fn
 }
+
+// addOutOfRange adds what indexing an array or slice out of its bounds calls,
+// which prints a message and exits.
+func addOutOfRange(m *ir.Module, print *ir.Func) *ir.Func {
+	msg := "index out of range\n"
+	chars := m.NewGlobalDef("_str_outofrange", constant.NewCharArrayFromString(msg))
+	chars.Immutable = true
+	chars.Linkage = enum.LinkageInternal
+
+	zero := constant.NewInt(types.I32, 0)
+	str := m.NewGlobalDef("_outofrange", constant.NewStruct(String.Type.(*types.StructType),
+		constant.NewInt(Int64.Type.(*types.IntType), int64(len(msg))),
+		constant.NewGetElementPtr(chars.ContentType, chars, zero, zero),
+	))
+	str.Immutable = true
+	str.Linkage = enum.LinkageInternal
+
+	fn := m.NewFunc("tawa.outOfRange", types.Void)
+	fn.Linkage = enum.LinkageInternal
+	entry := fn.NewBlock("entry")
+	entry.NewCall(print, str)
+
+	exit := ir.NewInlineAsm(
+		types.NewPointer(types.NewFunc(types.Void)),
+		`movq $$0x3C, %rax; movq $$0x2, %rdi; syscall`,
+		``,
+	)
+	exit.SideEffect = true
+
+	entry.NewCall(exit)
+	entry.NewUnreachable()
+
+	return fn
+}
+
+// codegenBuiltin calls a builtin function like len, which is generated inline
+// since it works on values of many types.
+func codegenBuiltin(c *ctx, name string, args []Expression) value.Value {
+	switch name {
+	case "free":
+		// memory is only released by the collector when there is one
+		ptr := codegenExpression(c, args[0])
+		if c.sets.memory != memoryGC {
+			c.free(ptr)
+		}
+		return nil
+	case "len":
+		switch kind := underlying(typeOf(args[0])).(type) {
+		case *arrayType:
+			return constant.NewInt(types.I64, kind.length)
+		case *sliceType:
+			return c.block.NewExtractValue(codegenExpression(c, args[0]), 0)
+		}
+		str := codegenExpression(c, args[0])
+		return c.block.NewLoad(Int64.Type, getStructElm(c.block, String.Type, str, 0))
+	}
+
+	panic("unhandled builtin " + name)
+}
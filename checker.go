@@ -0,0 +1,1687 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type symbolKind int
+
+const (
+	symbolValue symbolKind = iota
+	symbolMutable
+	symbolFunc
+	symbolType
+	// symbolVariant is a variant of a sum type, used to construct values of
+	// it
+	symbolVariant
+	// symbolPackage is an imported package, whose names are used like
+	// pkg.Name
+	symbolPackage
+)
+
+type symbol struct {
+	Name string
+	Kind symbolKind
+	Type tawaType
+	Pos  Span
+
+	// Generic is set for generic functions and types, which only have a
+	// type once they're instantiated
+	Generic *generic
+
+	// Package is the name of the package a symbolPackage refers to, which
+	// the names it exports are qualified by, and Members those names
+	Package string
+	Members map[string]*symbol
+
+	// captured is set for variables that closures capture by reference or
+	// whose address is taken
+	captured bool
+}
+
+type scope struct {
+	parent  *scope
+	symbols map[string]*symbol
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		parent:  parent,
+		symbols: map[string]*symbol{},
+	}
+}
+
+func (s *scope) lookup(name string) *symbol {
+	for ; s != nil; s = s.parent {
+		if sym, ok := s.symbols[name]; ok {
+			return sym
+		}
+	}
+
+	return nil
+}
+
+// universe creates the scope holding everything that's available without
+// being declared.
+func universe() *scope {
+	s := newScope(nil)
+
+	for _, t := range []*basicType{
+		typeInt8,
+		typeInt16,
+		typeInt32,
+		typeInt64,
+		typeInt128,
+		typeFloat16,
+		typeFloat32,
+		typeFloat64,
+		typeFloat128,
+		typeBool,
+		typeNiets,
+		typeByte,
+		typeString,
+	} {
+		s.symbols[t.name] = &symbol{Name: t.name, Kind: symbolType, Type: t}
+	}
+
+	s.symbols["rune"] = &symbol{Name: "rune", Kind: symbolType, Type: typeRune}
+
+	s.symbols["true"] = &symbol{Name: "true", Kind: symbolValue, Type: typeBool}
+	s.symbols["false"] = &symbol{Name: "false", Kind: symbolValue, Type: typeBool}
+	s.symbols["print"] = &symbol{Name: "print", Kind: symbolFunc, Type: &funcType{
+		params:  []tawaType{typeString},
+		returns: typeNiets,
+	}}
+	s.symbols["nil"] = &symbol{Name: "nil", Kind: symbolValue, Type: typeNil}
+	s.symbols["len"] = &symbol{Name: "len", Kind: symbolFunc, Type: &builtinType{"len"}}
+	s.symbols["free"] = &symbol{Name: "free", Kind: symbolFunc, Type: &builtinType{"free"}}
+
+	return s
+}
+
+type checker struct {
+	scope *scope
+	diags *Diagnostics
+	loops int
+
+	// pendingTypes holds type aliases that haven't been resolved yet, and
+	// resolving the ones currently being resolved so that cycles are caught.
+	pendingTypes map[string]TypeDeclaration
+	resolving    map[string]bool
+	resolved     []TopLevel
+
+	// methods holds the methods declared on each type, by name.
+	methods map[*namedType]map[string]*symbol
+
+	// pkg is the package scope, which the scopes of files are nested in.
+	// pending holds the instances of generic functions that haven't been
+	// checked yet, and depth how deeply the one being checked is nested in
+	// other instances.
+	pkg      *scope
+	generics []*generic
+	pending  []pendingInstance
+	depth    int
+
+	// files holds the scope of each file that imports packages, between the
+	// package scope and the functions declared in the file, and libraries
+	// the package of each library that's been imported, by file. imported
+	// names are declared by their qualified names, next to the package
+	// they're referred to through.
+	files     map[string]*scope
+	libraries map[string]*symbol
+	// packages holds the library each package is imported from, by the
+	// name of the package, and name is the one of the package being checked
+	packages map[string]string
+	name     string
+
+	// closures holds the lambdas around the expression being checked,
+	// innermost last.
+	closures []*closure
+
+	// typeParams are the type parameters of the generic function whose
+	// instance is being checked, which types written in its body are
+	// rewritten to the types they stand for.
+	typeParams []Identifier
+}
+
+func (c *checker) errorf(code ErrorCode, at Span, format string, args ...interface{}) {
+	c.diags.Errorf(code, at, format, args...)
+}
+
+func (c *checker) pushScope() {
+	c.scope = newScope(c.scope)
+}
+
+func (c *checker) popScope() {
+	c.scope = c.scope.parent
+}
+
+// declareTop declares a symbol in the package scope, where names can't be
+// declared more than once.
+func (c *checker) declareTop(sym *symbol) {
+	if prev, ok := c.scope.symbols[sym.Name]; ok {
+		c.diags.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrRedeclared,
+			Location: sym.Pos,
+			Message:  fmt.Sprintf("%s redeclared in this package", sym.Name),
+			Notes:    []Note{{Message: "previously declared here", Location: prev.Pos}},
+		})
+		return
+	}
+
+	c.scope.symbols[sym.Name] = sym
+}
+
+func typeOf(e Expression) tawaType {
+	if t, ok := e.(Typed); ok {
+		return t.Kind
+	}
+	return typeInvalid
+}
+
+// check resolves the names used in a package and works out the type of
+// every expression, reporting any problems to diags. the returned top levels
+// have every expression wrapped in a Typed, with type declarations ordered so
+// that each one comes after the types it depends on.
+func check(tls []TopLevel, sets settings, diags *Diagnostics) []TopLevel {
+	c := &checker{
+		scope:        newScope(universe()),
+		diags:        diags,
+		pendingTypes: map[string]TypeDeclaration{},
+		resolving:    map[string]bool{},
+		methods:      map[*namedType]map[string]*symbol{},
+		files:        map[string]*scope{},
+		libraries:    map[string]*symbol{},
+		packages:     map[string]string{},
+		name:         sets.packageName,
+	}
+
+	c.pkg = c.scope
+	c.importLibraries(sets.forceimportlibs)
+
+	var out []TopLevel
+	var structs []TopLevel
+	var funcs []Func
+
+	for _, tl := range tls {
+		switch decl := tl.(type) {
+		case Import:
+			out = append(out, c.importPackage(decl, sets))
+		case TypeDeclaration:
+			if decl.TypeParams != nil {
+				if g := c.declareGeneric(decl); g != nil {
+					c.generics = append(c.generics, g)
+				}
+				continue
+			}
+			sym := &symbol{Name: decl.Ident.Name, Kind: symbolType, Pos: decl.Ident.Pos}
+			if isNominal(decl.Kind) {
+				sym.Type = &namedType{name: decl.Ident.Name}
+				structs = append(structs, decl)
+			} else {
+				c.pendingTypes[decl.Ident.Name] = decl
+			}
+			c.declareTop(sym)
+		case Func:
+			if decl.TypeParams != nil {
+				if g := c.declareGeneric(decl); g != nil {
+					c.generics = append(c.generics, g)
+				}
+				continue
+			}
+			funcs = append(funcs, decl)
+		}
+	}
+
+	for _, decl := range tls {
+		if decl, ok := decl.(TypeDeclaration); ok {
+			if _, ok := c.pendingTypes[decl.Ident.Name]; ok {
+				c.resolveAlias(decl.Ident.Name)
+			}
+		}
+	}
+	for _, decl := range structs {
+		decl := decl.(TypeDeclaration)
+		named := c.pkg.symbols[decl.Ident.Name].Type.(*namedType)
+		c.scope = c.fileScope(decl.Ident.Pos)
+		named.underlying = c.resolveType(decl.Kind)
+		c.scope = c.pkg
+
+		if sum, ok := decl.Kind.(Sum); ok {
+			for _, variant := range sum {
+				c.declareTop(&symbol{Name: variant.Ident.Name, Kind: symbolVariant, Type: named, Pos: variant.Ident.Pos})
+			}
+		}
+	}
+	for idx, decl := range structs {
+		decl := decl.(TypeDeclaration)
+		named := c.pkg.symbols[decl.Ident.Name].Type.(*namedType)
+		if containsByValue(named.underlying, named, map[*namedType]bool{}) {
+			c.errorf(ErrRecursiveType, decl.Ident.Pos, "invalid recursive type %s", named)
+			named.underlying = typeInvalid
+		}
+		decl.Kind = resolvedType(decl.Kind, named.underlying, nil)
+		structs[idx] = decl
+	}
+	out = append(out, c.resolved...)
+	out = append(out, structs...)
+
+	signatures := make([]*funcType, len(funcs))
+	receivers := make([]tawaType, len(funcs))
+	for idx, fn := range funcs {
+		c.scope = c.fileScope(fn.Ident.Pos)
+		sig := c.signature(fn.Arguments, fn.Returns)
+		signatures[idx] = sig
+		c.scope = c.pkg
+
+		if fn.Receiver != nil {
+			receivers[idx] = c.declareMethod(fn, sig)
+			continue
+		}
+		c.declareTop(&symbol{Name: fn.Ident.Name, Kind: symbolFunc, Type: sig, Pos: fn.Ident.Pos})
+	}
+	c.checkImportNames()
+	for idx, fn := range funcs {
+		c.scope = c.fileScope(fn.Ident.Pos)
+		out = append(out, c.checkFunc(fn, signatures[idx], receivers[idx]))
+	}
+	c.scope = c.pkg
+
+	c.checkInstances()
+	for _, g := range c.generics {
+		out = append(out, g.output())
+	}
+
+	return out
+}
+
+// declareMethod declares fn as a method of the type of its receiver, which
+// has to be a struct or sum type. it returns the type of the receiver.
+func (c *checker) declareMethod(fn Func, sig *funcType) tawaType {
+	recv := fn.Receiver.Kind
+	sym := c.scope.lookup(recv.Name)
+	if sym == nil {
+		c.errorf(ErrUndefined, recv.Pos, "undefined type %s", recv.Name)
+		return typeInvalid
+	}
+	if sym.Generic != nil {
+		c.errorf(ErrTypeParameters, recv.Pos, "methods can't be declared on generic type %s", recv.Name)
+		return typeInvalid
+	}
+	named, ok := sym.Type.(*namedType)
+	if sym.Kind != symbolType || !ok {
+		c.errorf(ErrInvalidReceiver, recv.Pos, "invalid receiver type %s: methods can only be declared on struct and sum types", recv.Name)
+		return typeInvalid
+	}
+
+	name := fn.Ident.Name
+	if st, ok := underlying(named).(*structType); ok {
+		if idx, _ := st.field(name); idx != -1 {
+			c.errorf(ErrRedeclared, fn.Ident.Pos, "type %s has both a field and a method named %s", named, name)
+			return named
+		}
+	}
+	if c.methods[named] == nil {
+		c.methods[named] = map[string]*symbol{}
+	}
+	if prev, ok := c.methods[named][name]; ok {
+		c.diags.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrRedeclared,
+			Location: fn.Ident.Pos,
+			Message:  fmt.Sprintf("method %s redeclared", prev.Name),
+			Notes:    []Note{{Message: "previously declared here", Location: prev.Pos}},
+		})
+		return named
+	}
+
+	c.methods[named][name] = &symbol{Name: funcName(fn), Kind: symbolFunc, Type: sig, Pos: fn.Ident.Pos}
+	return named
+}
+
+// importLibraries declares the packages of libraries passed with
+// --force-import, which every file can use.
+func (c *checker) importLibraries(libs []string) {
+	for _, lib := range libs {
+		ti, err := getTypeInfoFromFile(lib)
+		if err != nil {
+			c.errorf(ErrImport, Span{}, "error with type info for %s: %s", lib, err)
+			continue
+		}
+		if !c.claim(ti.Package, lib, Span{}) {
+			continue
+		}
+
+		pkg := c.loadLibrary(lib, ti)
+		c.pkg.symbols[pkg.Name] = pkg
+		for name, sym := range pkg.Members {
+			c.pkg.symbols[name] = sym
+		}
+	}
+}
+
+// importPackage makes the package imp refers to available in the file
+// importing it, under the name of the package or the one it's imported as.
+func (c *checker) importPackage(imp Import, sets settings) Import {
+	lib, err := findLibrary(imp.Path, sets)
+	if err != nil {
+		c.errorf(ErrImport, imp.Pos, "cannot import %q: %s", imp.Path, err)
+		return imp
+	}
+
+	loaded, ok := c.libraries[lib]
+	if !ok {
+		ti, err := getTypeInfoFromFile(lib)
+		if err != nil {
+			c.errorf(ErrImport, imp.Pos, "cannot import %q: error with type info for %s: %s", imp.Path, lib, err)
+			return imp
+		}
+		if !c.claim(ti.Package, lib, imp.Pos) {
+			return imp
+		}
+		loaded = c.loadLibrary(lib, ti)
+		c.libraries[lib] = loaded
+	}
+
+	file := imp.Pos.From.Filename
+	if c.files[file] == nil {
+		c.files[file] = newScope(c.pkg)
+	}
+	pkg := *loaded
+	pkg.Pos = imp.Pos
+	if imp.Alias != nil {
+		pkg.Name, pkg.Pos = imp.Alias.Name, imp.Alias.Pos
+	}
+
+	if prev, ok := c.files[file].symbols[pkg.Name]; ok {
+		c.diags.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrRedeclared,
+			Location: pkg.Pos,
+			Message:  fmt.Sprintf("%s is imported more than once", pkg.Name),
+			Notes:    []Note{{Message: "previously imported here", Location: prev.Pos}},
+		})
+		return imp
+	}
+	c.files[file].symbols[pkg.Name] = &pkg
+	for name, sym := range pkg.Members {
+		c.files[file].symbols[name] = sym
+	}
+
+	imp.Library = lib
+	return imp
+}
+
+// claim records that the package pkg is imported from lib. what packages
+// export is mangled by the name of the package, so packages with the same
+// name can't be imported together, even under different names, and neither
+// can a package with the name of the one being checked.
+func (c *checker) claim(pkg, lib string, at Span) bool {
+	if pkg == c.name {
+		c.errorf(ErrImport, at, "cannot import %s: it's built from a package named %s too", lib, pkg)
+		return false
+	}
+	key := lib
+	if abs, err := filepath.Abs(lib); err == nil {
+		key = abs
+	}
+	if prev, ok := c.packages[pkg]; ok && prev != key {
+		c.errorf(ErrImport, at, "cannot import %s: a different package named %s is already imported from %s", lib, pkg, prev)
+		return false
+	}
+	c.packages[pkg] = key
+	return true
+}
+
+// checkImportNames reports packages imported by the name of something the
+// package declares, which would hide it in the file importing them.
+func (c *checker) checkImportNames() {
+	var files []string
+	for file := range c.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		for name, sym := range c.files[file].symbols {
+			prev, ok := c.pkg.symbols[name]
+			if sym.Kind != symbolPackage || !ok {
+				continue
+			}
+			c.diags.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     ErrRedeclared,
+				Location: sym.Pos,
+				Message:  fmt.Sprintf("%s is imported with the name of something declared in the package", name),
+				Notes:    []Note{{Message: "declared here", Location: prev.Pos}},
+			})
+		}
+	}
+}
+
+// loadLibrary returns the package of a library, with the names it exports.
+func (c *checker) loadLibrary(lib string, ti typeInfo) *symbol {
+	pkg := &symbol{Name: ti.Package, Kind: symbolPackage, Package: ti.Package, Members: map[string]*symbol{}}
+
+	// the library's types and signatures refer to its types by the names
+	// they have in it, but they're declared by their qualified names. struct,
+	// sum and interface types are declared before aliases, which can refer
+	// to them, and get their fields, variants and methods once the aliases
+	// exist.
+	scope := c.scope
+	c.scope = newScope(c.pkg.parent)
+	kinds := map[string]Type{}
+	for _, decl := range ti.Types {
+		kinds[decl.Name] = parseKind(decl.Kind, lib)
+		if isNominal(kinds[decl.Name]) {
+			qualified := qualifiedName(ti.Package, decl.Name)
+			c.scope.symbols[decl.Name] = &symbol{Name: qualified, Kind: symbolType, Type: &namedType{name: qualified}}
+		}
+	}
+	for _, decl := range ti.Types {
+		if !isNominal(kinds[decl.Name]) {
+			c.scope.symbols[decl.Name] = &symbol{Name: qualifiedName(ti.Package, decl.Name), Kind: symbolType, Type: c.resolveType(kinds[decl.Name])}
+		}
+	}
+	for _, decl := range ti.Types {
+		sym := c.scope.symbols[decl.Name]
+		if named, ok := sym.Type.(*namedType); ok && isNominal(kinds[decl.Name]) {
+			named.underlying = c.resolveType(kinds[decl.Name])
+		}
+		if !exported(decl.Name) {
+			continue
+		}
+		pkg.Members[sym.Name] = sym
+		if sum, ok := kinds[decl.Name].(Sum); ok {
+			for _, variant := range sum {
+				qualified := qualifiedName(ti.Package, variant.Ident.Name)
+				pkg.Members[qualified] = &symbol{Name: qualified, Kind: symbolVariant, Type: sym.Type}
+			}
+		}
+	}
+
+	for name, kind := range ti.Functions {
+		qualified := qualifiedName(ti.Package, name)
+		pkg.Members[qualified] = &symbol{
+			Name: qualified,
+			Kind: symbolFunc,
+			Type: c.resolveType(parseKind(kind, lib)),
+		}
+	}
+	for name, kind := range ti.Methods {
+		dot := strings.LastIndex(name, ".")
+		named := c.scope.symbols[name[:dot]].Type.(*namedType)
+		if c.methods[named] == nil {
+			c.methods[named] = map[string]*symbol{}
+		}
+		c.methods[named][name[dot+1:]] = &symbol{
+			Name: qualifiedName(ti.Package, name),
+			Kind: symbolFunc,
+			Type: c.resolveType(parseKind(kind, lib)),
+		}
+	}
+	c.scope = scope
+
+	return pkg
+}
+
+// selector resolves a name exported by a package, written like pkg.Name, to
+// the name it's declared by. ok is false if of isn't a package, in which case
+// the name is a field or method.
+func (c *checker) selector(of Expression, name Identifier) (qualified Identifier, ok bool) {
+	v, isVar := of.(Var)
+	if !isVar {
+		return Identifier{}, false
+	}
+	sym := c.scope.lookup(v.Name)
+	if sym == nil || sym.Kind != symbolPackage {
+		return Identifier{}, false
+	}
+	return Identifier{qualifiedName(sym.Package, name.Name), Span{v.Pos.From, name.Pos.To}}, true
+}
+
+// member is like selector, but reports names the package doesn't export as
+// they're written, returning an empty name for them.
+func (c *checker) member(of Expression, name Identifier) (qualified Identifier, ok bool) {
+	qualified, ok = c.selector(of, name)
+	if ok && c.scope.lookup(qualified.Name) == nil {
+		c.errorf(ErrUndefined, qualified.Pos, "undefined: %s.%s", of.(Var).Name, name.Name)
+		return Identifier{Pos: qualified.Pos}, true
+	}
+	return qualified, ok
+}
+
+// fileScope returns the scope of the file a declaration at pos is in.
+func (c *checker) fileScope(pos Span) *scope {
+	if s, ok := c.files[pos.From.Filename]; ok {
+		return s
+	}
+	return c.pkg
+}
+
+func (c *checker) resolveAlias(name string) {
+	sym := c.pkg.symbols[name]
+	if c.resolving[name] {
+		c.errorf(ErrRecursiveType, sym.Pos, "invalid recursive type alias %s", name)
+		sym.Type = typeInvalid
+		return
+	}
+
+	decl := c.pendingTypes[name]
+	scope := c.scope
+	c.scope = c.fileScope(decl.Ident.Pos)
+	c.resolving[name] = true
+	kind := c.resolveType(decl.Kind)
+	delete(c.resolving, name)
+	c.scope = scope
+
+	if sym.Type == nil {
+		sym.Type = kind
+		decl.Kind = resolvedType(decl.Kind, kind, nil)
+		c.resolved = append(c.resolved, decl)
+	}
+	delete(c.pendingTypes, name)
+}
+
+func (c *checker) resolveType(t Type) tawaType {
+	switch kind := t.(type) {
+	case Ident:
+		name := kind.Name
+		if dot := strings.Index(name, "."); dot != -1 {
+			if pkg := c.scope.lookup(name[:dot]); pkg != nil && pkg.Kind == symbolPackage {
+				name = qualifiedName(pkg.Package, name[dot+1:])
+			}
+		}
+		sym := c.scope.lookup(name)
+		if sym == nil {
+			c.errorf(ErrUndefined, kind.Pos, "undefined type %s", kind.Name)
+			return typeInvalid
+		}
+		if sym.Kind != symbolType {
+			c.errorf(ErrNotAType, kind.Pos, "%s is not a type", kind.Name)
+			return typeInvalid
+		}
+		if sym.Generic != nil {
+			c.errorf(ErrTypeParameters, kind.Pos, "generic type %s needs type arguments, like %s[...]", kind.Name, kind.Name)
+			return typeInvalid
+		}
+		if sym.Type == nil {
+			c.resolveAlias(kind.Name)
+		}
+		return sym.Type
+	case Generic:
+		// the instances of generic types libraries export are declared by
+		// their names, like Box[int64]
+		if sym := c.scope.lookup(typeToString(&t)); sym != nil && sym.Kind == symbolType {
+			return sym.Type
+		}
+		sym := c.scope.lookup(kind.Ident.Name)
+		if sym == nil {
+			c.errorf(ErrUndefined, kind.Ident.Pos, "undefined type %s", kind.Ident.Name)
+			return typeInvalid
+		}
+		if sym.Kind != symbolType || sym.Generic == nil {
+			c.errorf(ErrTypeParameters, kind.Ident.Pos, "%s is not a generic type", kind.Ident.Name)
+			return typeInvalid
+		}
+		args := c.typeArguments(sym, kind.Ident, kind.Arguments)
+		if args == nil {
+			return typeInvalid
+		}
+		return c.instantiateType(sym, args, kind.Ident.Pos)
+	case FunctionPointer:
+		f := &funcType{returns: typeNiets}
+		for _, arg := range kind.Arguments {
+			f.params = append(f.params, c.resolveType(arg))
+		}
+		if kind.Returns != nil {
+			f.returns = c.resolveType(*kind.Returns)
+		}
+		return f
+	case Struct:
+		s := &structType{}
+		for _, field := range kind {
+			if idx, _ := s.field(field.Ident); idx != -1 {
+				c.errorf(ErrDuplicateField, posOfType(field.Kind), "field %s specified more than once", field.Ident)
+				continue
+			}
+			s.fields = append(s.fields, structField{
+				Name: field.Ident,
+				Kind: c.resolveType(field.Kind),
+			})
+		}
+		return s
+	case Interface:
+		i := &interfaceType{}
+		for _, method := range kind {
+			if idx, _ := i.method(method.Ident.Name); idx != -1 {
+				c.errorf(ErrDuplicateField, method.Ident.Pos, "method %s specified more than once", method.Ident.Name)
+				continue
+			}
+			i.methods = append(i.methods, interfaceMethod{
+				Name: method.Ident.Name,
+				Type: c.signature(method.Arguments, method.Returns),
+			})
+		}
+		return i
+	case Sum:
+		s := &sumType{}
+		for _, variant := range kind {
+			if idx, _ := s.variant(variant.Ident.Name); idx != -1 {
+				c.errorf(ErrDuplicateField, variant.Ident.Pos, "variant %s specified more than once", variant.Ident.Name)
+				continue
+			}
+			v := sumVariant{Name: variant.Ident.Name}
+			if variant.Payload != nil {
+				v.Payload = c.resolveType(*variant.Payload)
+			}
+			s.variants = append(s.variants, v)
+		}
+		return s
+	case Array:
+		return &arrayType{kind.Length, c.resolveType(kind.Elem)}
+	case Slice:
+		return &sliceType{c.resolveType(kind.Elem)}
+	case Pointer:
+		return &pointerType{c.resolveType(kind.Elem)}
+	}
+
+	panic("unhandled")
+}
+
+// signature resolves the type of a function or method from its parameters
+// and return type.
+func (c *checker) signature(params []struct {
+	Ident Identifier
+	Kind  Type
+}, returns *Type) *funcType {
+	sig := &funcType{returns: typeNiets}
+	for _, param := range params {
+		sig.params = append(sig.params, c.resolveType(param.Kind))
+	}
+	if returns != nil {
+		sig.returns = c.resolveType(*returns)
+	}
+	return sig
+}
+
+// isNominal reports whether declaring a type of this kind creates a new type,
+// rather than another name for an existing one.
+func isNominal(t Type) bool {
+	switch t.(type) {
+	case Struct, Sum, Interface:
+		return true
+	}
+	return false
+}
+
+func posOfType(t Type) Span {
+	if ident, ok := t.(Ident); ok {
+		return ident.Pos
+	}
+	return Span{}
+}
+
+// containsByValue reports whether values of type t contain a value of the
+// named type, which would make the named type infinitely large.
+func containsByValue(t tawaType, named *namedType, seen map[*namedType]bool) bool {
+	switch kind := t.(type) {
+	case *namedType:
+		if kind == named {
+			return true
+		}
+		if seen[kind] {
+			return false
+		}
+		seen[kind] = true
+		return containsByValue(kind.underlying, named, seen)
+	case *structType:
+		for _, field := range kind.fields {
+			if containsByValue(field.Kind, named, seen) {
+				return true
+			}
+		}
+	case *sumType:
+		for _, variant := range kind.variants {
+			if variant.Payload != nil && containsByValue(variant.Payload, named, seen) {
+				return true
+			}
+		}
+	case *arrayType:
+		return containsByValue(kind.elem, named, seen)
+	}
+
+	return false
+}
+
+func (c *checker) checkFunc(fn Func, sig *funcType, receiver tawaType) Func {
+	c.pushScope()
+	if fn.Receiver != nil {
+		c.scope.symbols[fn.Receiver.Ident.Name] = &symbol{
+			Name: fn.Receiver.Ident.Name,
+			Kind: symbolValue,
+			Type: receiver,
+			Pos:  fn.Receiver.Ident.Pos,
+		}
+	}
+	for idx, arg := range fn.Arguments {
+		if _, ok := c.scope.symbols[arg.Ident.Name]; ok {
+			c.errorf(ErrDuplicateField, arg.Ident.Pos, "argument %s specified more than once", arg.Ident.Name)
+		}
+		c.scope.symbols[arg.Ident.Name] = &symbol{
+			Name: arg.Ident.Name,
+			Kind: symbolValue,
+			Type: sig.params[idx],
+			Pos:  arg.Ident.Pos,
+		}
+	}
+	body := c.exprExpecting(fn.Expr, sig.returns)
+	c.popScope()
+	fn.Arguments, fn.Returns = resolvedSignature(fn.Arguments, fn.Returns, sig, c.typeParams)
+
+	if sig.returns != typeNiets && !identical(sig.returns, body.Kind) {
+		diag := Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrMismatchedTypes,
+			Location: posOf(fn.Expr),
+			Message:  fmt.Sprintf("function %s returns '%s', but its body has type '%s'", funcName(fn), sig.returns, body.Kind),
+			Label:    fmt.Sprintf("has type '%s'", body.Kind),
+		}
+		if fn.Returns != nil {
+			diag.Notes = append(diag.Notes, Note{fmt.Sprintf("expected '%s' because of this return type", sig.returns), posOfType(*fn.Returns)})
+		}
+		c.diags.Add(diag)
+	}
+
+	fn.Expr = body
+	return fn
+}
+
+// arguments checks the arguments of a call, expecting the types of params if
+// there are as many of them.
+func (c *checker) arguments(exprs []Expression, params []tawaType) []Expression {
+	var args []Expression
+	for idx, arg := range exprs {
+		if len(params) == len(exprs) {
+			args = append(args, c.exprExpecting(arg, params[idx]))
+		} else {
+			args = append(args, c.expr(arg))
+		}
+	}
+	return args
+}
+
+// checkArguments reports arguments that don't match the parameters of fn, a
+// function or method called name that was declared at declared.
+func (c *checker) checkArguments(what string, name Identifier, args []Expression, fn *funcType, declared Span) {
+	if len(args) != len(fn.params) {
+		c.errorf(ErrArgumentCount, name.Pos, "%s '%s' takes %d arguments, not %d", what, name.Name, len(fn.params), len(args))
+		return
+	}
+	for idx, arg := range args {
+		if kind := typeOf(arg); !identical(fn.params[idx], kind) {
+			diag := Diagnostic{
+				Severity: SeverityError,
+				Code:     ErrMismatchedTypes,
+				Location: posOf(arg),
+				Message:  fmt.Sprintf("argument %d of %s '%s' is of type '%s', not type '%s'", idx+1, what, name.Name, kind, fn.params[idx]),
+				Label:    fmt.Sprintf("expected '%s', found '%s'", fn.params[idx], kind),
+			}
+			if declared.From.Line != 0 {
+				diag.Notes = []Note{{what + " declared here", declared}}
+			}
+			c.diags.Add(diag)
+		}
+	}
+}
+
+func isInterface(t tawaType) bool {
+	_, ok := underlying(t).(*interfaceType)
+	return ok
+}
+
+// missingMethod describes why t doesn't implement iface, or returns "" if it
+// does: t has to have every method of iface with the same signature.
+func (c *checker) missingMethod(t tawaType, iface *interfaceType) string {
+	named, _ := t.(*namedType)
+	for _, method := range iface.methods {
+		var have *symbol
+		if named != nil {
+			have = c.methods[named][method.Name]
+		}
+		if have == nil {
+			return fmt.Sprintf("missing method %s", method.Name)
+		}
+		if !identical(have.Type, method.Type) {
+			return fmt.Sprintf("method %s has type '%s', not '%s'", method.Name, have.Type, method.Type)
+		}
+	}
+	return ""
+}
+
+// toInterface converts e to the interface type want if its type implements
+// it, boxing it. other values are left for the caller to compare against
+// want.
+func (c *checker) toInterface(e Typed, want tawaType) Typed {
+	iface, ok := underlying(want).(*interfaceType)
+	if !ok || identical(want, e.Kind) || isInterface(e.Kind) {
+		return e
+	}
+
+	if missing := c.missingMethod(e.Kind, iface); missing != "" {
+		c.errorf(ErrMismatchedTypes, posOf(e), "type '%s' doesn't implement '%s': %s", e.Kind, want, missing)
+		return Typed{e.Expr, typeInvalid}
+	}
+	return Typed{Box{e}, want}
+}
+
+// methodCall checks a call of a method of the type of the value it's called
+// on.
+func (c *checker) methodCall(expr MethodCall) Typed {
+	of := c.autoDeref(c.expr(expr.Of))
+
+	var method *symbol
+	var params []tawaType
+	if iface, ok := underlying(of.Kind).(*interfaceType); ok {
+		if _, sig := iface.method(expr.Method.Name); sig != nil {
+			method = &symbol{Name: of.Kind.String() + "." + expr.Method.Name, Kind: symbolFunc, Type: sig}
+		}
+	} else if named, ok := of.Kind.(*namedType); ok {
+		method = c.methods[named][expr.Method.Name]
+	}
+	if method != nil {
+		params = method.Type.(*funcType).params
+	} else if st, ok := underlying(of.Kind).(*structType); ok {
+		// a field holding a function is called the same way as a method
+		if _, kind := st.field(expr.Method.Name); kind != nil {
+			if _, ok := underlying(kind).(*funcType); ok {
+				return c.callValue(CallValue{Field{of, expr.Method}, expr.Arguments, expr.Pos})
+			}
+		}
+	}
+
+	args := c.arguments(expr.Arguments, params)
+	call := MethodCall{of, expr.Method, args, expr.Pos}
+
+	if method == nil {
+		if of.Kind != typeInvalid {
+			c.errorf(ErrUnknownMethod, expr.Method.Pos, "type '%s' has no method '%s'", of.Kind, expr.Method.Name)
+		}
+		return Typed{call, typeInvalid}
+	}
+
+	fn := method.Type.(*funcType)
+	c.checkArguments("method", Identifier{method.Name, expr.Method.Pos}, args, fn, method.Pos)
+	return Typed{call, fn.returns}
+}
+
+// addressable reports whether e refers to storage that can be assigned to.
+func (c *checker) addressable(e Typed) bool {
+	switch expr := e.Expr.(type) {
+	case Var:
+		sym := c.scope.lookup(expr.Name)
+		return sym != nil && sym.Kind == symbolMutable
+	case Field:
+		return c.addressable(expr.Of.(Typed))
+	case Index:
+		// the elements of slices can always be assigned to, as they're
+		// stored somewhere else
+		switch underlying(typeOf(expr.Of)).(type) {
+		case *arrayType:
+			return c.addressable(expr.Of.(Typed))
+		case *sliceType:
+			return true
+		}
+	case Unary:
+		return expr.Op == STAR
+	}
+
+	return false
+}
+
+// structOf returns the struct type underlying t, reporting an error if there
+// isn't one.
+func (c *checker) structOf(t tawaType, at Span, format string) *structType {
+	if underlying(t) == typeInvalid {
+		return nil
+	}
+
+	st, ok := underlying(t).(*structType)
+	if !ok {
+		c.errorf(ErrNotAStruct, at, format, t)
+		return nil
+	}
+
+	return st
+}
+
+func (c *checker) expr(e Expression) Typed {
+	switch expr := e.(type) {
+	case Lit:
+		switch lit := expr.Literal.(type) {
+		case Integer:
+			val, _ := constantValue(expr)
+			return c.constant(expr, val, typeInt64)
+		case Float:
+			return Typed{expr, typeFloat64}
+		case Char:
+			return Typed{expr, typeRune}
+		case StringLiteral:
+			return Typed{expr, typeString}
+		case StructLiteral:
+			// a variant carrying a struct can be constructed like one
+			var kind tawaType
+			var st *structType
+			structName := lit.Ident.Name
+			if dot := strings.Index(lit.Ident.Name, "."); dot != -1 {
+				if name, ok := c.member(Var{lit.Ident.Name[:dot], lit.Ident.Pos}, Identifier{lit.Ident.Name[dot+1:], lit.Ident.Pos}); ok {
+					if name.Name == "" {
+						return Typed{expr, typeInvalid}
+					}
+					lit.Ident.Name = name.Name
+				}
+			}
+			if sym := c.scope.lookup(lit.Ident.Name); sym != nil && sym.Kind == symbolVariant {
+				kind = sym.Type
+				_, payload := underlying(sym.Type).(*sumType).variant(unqualified(lit.Ident.Name))
+				if st, _ = underlying(payload).(*structType); st == nil && payload != typeInvalid {
+					c.errorf(ErrNotAStruct, lit.Ident.Pos, "variant %s doesn't carry a struct", lit.Ident.Name)
+				}
+			} else if lit.TypeArguments != nil {
+				kind = c.resolveType(Generic{lit.Ident, lit.TypeArguments})
+				st = c.structOf(kind, lit.Ident.Pos, "%s is not a struct type")
+				lit.Ident.Name = kind.String()
+			} else {
+				kind = c.resolveType(Ident(lit.Ident))
+				st = c.structOf(kind, lit.Ident.Pos, "%s is not a struct type")
+				if strings.Contains(lit.Ident.Name, ".") && kind != typeInvalid {
+					lit.Ident.Name = kind.String()
+				}
+			}
+
+			var names []string
+			for name := range lit.Fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			fields := map[string]Expression{}
+			for _, name := range names {
+				var fieldType tawaType
+				if st != nil {
+					_, fieldType = st.field(name)
+				}
+				field := c.exprExpecting(lit.Fields[name], fieldType)
+				fields[name] = field
+
+				if st == nil {
+					continue
+				}
+				if fieldType == nil {
+					c.errorf(ErrUnknownField, posOf(field), "struct type '%s' does not have field '%s'", structName, name)
+				} else if !identical(fieldType, field.Kind) {
+					c.errorf(ErrMismatchedTypes, posOf(field), "field '%s' has type '%s', not type '%s'", name, fieldType, field.Kind)
+				}
+			}
+
+			if st == nil {
+				kind = typeInvalid
+			}
+			return Typed{Lit{Literal: StructLiteral{lit.Ident, lit.TypeArguments, fields}, Pos: expr.Pos}, kind}
+		case ArrayLiteral:
+			return c.arrayLiteral(lit, expr.Pos)
+		}
+	case Var:
+		sym := c.use(expr.Name)
+		if sym == nil {
+			c.errorf(ErrUndefined, expr.Pos, "undefined: %s", expr.Name)
+			return Typed{expr, typeInvalid}
+		}
+		if sym.Kind == symbolType {
+			c.errorf(ErrNotAValue, expr.Pos, "%s is a type, not a value", expr.Name)
+			return Typed{expr, typeInvalid}
+		}
+		if sym.Kind == symbolPackage {
+			c.errorf(ErrNotAValue, expr.Pos, "use of package %s without a name from it, like %s.Name", expr.Name, expr.Name)
+			return Typed{expr, typeInvalid}
+		}
+		if sym.Generic != nil {
+			c.errorf(ErrTypeParameters, expr.Pos, "generic function %s can only be called", expr.Name)
+			return Typed{expr, typeInvalid}
+		}
+		if _, ok := sym.Type.(*builtinType); ok {
+			c.errorf(ErrNotAValue, expr.Pos, "builtin %s can only be called", expr.Name)
+			return Typed{expr, typeInvalid}
+		}
+		if sym.Kind == symbolVariant {
+			if _, payload := underlying(sym.Type).(*sumType).variant(unqualified(expr.Name)); payload != nil {
+				c.diags.Add(Diagnostic{
+					Severity: SeverityError,
+					Code:     ErrNotAValue,
+					Location: expr.Pos,
+					Message:  fmt.Sprintf("variant %s of %s carries a value of type '%s'", expr.Name, sym.Type, payload),
+					Hints:    []string{variantHint(expr.Name, payload)},
+				})
+			}
+		}
+		return Typed{expr, sym.Type}
+	case Call:
+		// arguments are checked expecting the types of the parameters
+		sym := c.use(expr.Function.Name)
+		if sym != nil && sym.Kind == symbolFunc && sym.Generic != nil {
+			return c.genericCall(expr, sym)
+		}
+		if expr.TypeArguments != nil {
+			expr.Function.Name = c.exportedInstance(expr.Function, expr.TypeArguments)
+			sym = c.use(expr.Function.Name)
+		}
+		if sym != nil && sym.Kind == symbolVariant {
+			return c.variantCall(expr, sym)
+		}
+		if sym != nil && sym.Kind == symbolFunc {
+			if b, ok := sym.Type.(*builtinType); ok {
+				return c.builtinCall(expr, b)
+			}
+		}
+		var params []tawaType
+		if sym != nil && sym.Kind != symbolType {
+			if fn, ok := underlying(sym.Type).(*funcType); ok {
+				params = fn.params
+			}
+		}
+
+		args := c.arguments(expr.Arguments, params)
+		call := Call{expr.Function, expr.TypeArguments, args, expr.Pos}
+
+		if sym == nil {
+			c.errorf(ErrUndefined, expr.Function.Pos, "undefined: %s", expr.Function.Name)
+			return Typed{call, typeInvalid}
+		}
+		if sym.Kind == symbolType && (isNumeric(sym.Type) || isInterface(sym.Type)) {
+			// the type might be a type parameter, which codegen doesn't know
+			call.Function.Name = sym.Type.String()
+			return c.conversion(call, sym.Type)
+		}
+		fn, ok := underlying(sym.Type).(*funcType)
+		if !ok || sym.Kind == symbolType {
+			c.errorf(ErrNotAFunction, expr.Function.Pos, "%s is not a function", expr.Function.Name)
+			return Typed{call, typeInvalid}
+		}
+		c.checkArguments("function", expr.Function, args, fn, sym.Pos)
+		return Typed{call, fn.returns}
+	case MethodCall:
+		if fn, ok := c.member(expr.Of, expr.Method); ok {
+			if fn.Name == "" {
+				return Typed{expr, typeInvalid}
+			}
+			return c.expr(Call{Function: fn, Arguments: expr.Arguments, Pos: expr.Pos})
+		}
+		return c.methodCall(expr)
+	case CallValue:
+		return c.callValue(expr)
+	case Lambda:
+		return c.lambda(expr)
+	case Block:
+		return c.block(expr, nil)
+	case Declaration:
+		value := c.expr(expr.Value)
+		if value.Kind == typeNiets || value.Kind == typeNil {
+			c.errorf(ErrMismatchedTypes, expr.To.Pos, "cannot declare %s with a value of type '%s'", expr.To.Name, value.Kind)
+		}
+		c.scope.symbols[expr.To.Name] = &symbol{Name: expr.To.Name, Kind: symbolValue, Type: value.Kind, Pos: expr.To.Pos}
+
+		return Typed{Declaration{expr.To, value}, value.Kind}
+	case MutDeclaration:
+		value := c.expr(expr.Value)
+		if value.Kind == typeNiets || value.Kind == typeNil {
+			c.errorf(ErrMismatchedTypes, expr.To.Pos, "cannot declare %s with a value of type '%s'", expr.To.Name, value.Kind)
+		}
+		c.scope.symbols[expr.To.Name] = &symbol{Name: expr.To.Name, Kind: symbolMutable, Type: value.Kind, Pos: expr.To.Pos}
+
+		return Typed{MutDeclaration{expr.To, value, false}, value.Kind}
+	case Assignment:
+		sym := c.use(expr.To.Name)
+		var want tawaType
+		if sym != nil {
+			want = sym.Type
+		}
+		value := c.exprExpecting(expr.Value, want)
+		typed := Typed{Assignment{expr.To, value, expr.Pos}, value.Kind}
+
+		if sym == nil {
+			c.errorf(ErrUndefined, expr.To.Pos, "undefined: %s", expr.To.Name)
+			return typed
+		}
+		if sym.Kind != symbolMutable {
+			c.diags.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     ErrImmutable,
+				Location: expr.Pos,
+				Message:  fmt.Sprintf("%s is not mutable", expr.To.Name),
+				Label:    "cannot assign twice to an immutable value",
+				Notes:    []Note{{"declared here", sym.Pos}},
+				Hints:    []string{fmt.Sprintf("declare %s with 'var' to make it mutable", expr.To.Name)},
+			})
+			return typed
+		}
+		if !identical(sym.Type, value.Kind) {
+			c.diags.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     ErrMismatchedTypes,
+				Location: posOf(expr.Value),
+				Message:  fmt.Sprintf("tried to assign something of type '%s' to type '%s'", value.Kind, sym.Type),
+				Label:    fmt.Sprintf("expected '%s', found '%s'", sym.Type, value.Kind),
+			})
+		}
+
+		return typed
+	case Index:
+		return c.index(expr)
+	case IndexAssignment:
+		return c.indexAssignment(expr)
+	case Subslice:
+		return c.subslice(expr)
+	case DerefAssignment:
+		return c.derefAssignment(expr)
+	case New:
+		return c.newExpr(expr)
+	case FieldAssignment:
+		of := c.autoDeref(c.expr(expr.Struct))
+		st := c.structOf(of.Kind, expr.Pos, "tried to assign to a field of a non-struct of type '%s'")
+
+		var idx int
+		var kind tawaType
+		if st != nil {
+			idx, kind = st.field(expr.Field.Name)
+		}
+		value := c.exprExpecting(expr.Value, kind)
+		typed := Typed{FieldAssignment{of, expr.Field, value, expr.Pos}, value.Kind}
+
+		if st == nil {
+			return typed
+		}
+		if idx == -1 {
+			c.errorf(ErrUnknownField, expr.Field.Pos, "struct type '%s' does not have field '%s'", of.Kind, expr.Field.Name)
+			return typed
+		}
+		if !c.addressable(of) {
+			c.errorf(ErrImmutable, expr.Pos, "cannot assign to field '%s' of an immutable value", expr.Field.Name)
+		}
+		if !identical(kind, value.Kind) {
+			c.errorf(ErrMismatchedTypes, expr.Pos, "field '%s' has type '%s', not type '%s'", expr.Field.Name, kind, value.Kind)
+		}
+
+		return typed
+	case Field:
+		if name, ok := c.member(expr.Of, expr.Ident); ok {
+			if name.Name == "" {
+				return Typed{expr, typeInvalid}
+			}
+			return c.expr(Var(name))
+		}
+		of := c.autoDeref(c.expr(expr.Of))
+		typed := Typed{Field{of, expr.Ident}, typeInvalid}
+
+		st := c.structOf(of.Kind, expr.Ident.Pos, "tried to get a field of a non-struct of type '%s'")
+		if st == nil {
+			return typed
+		}
+		idx, kind := st.field(expr.Ident.Name)
+		if idx == -1 {
+			c.errorf(ErrUnknownField, expr.Ident.Pos, "struct type '%s' does not have field '%s'", of.Kind, expr.Ident.Name)
+			return typed
+		}
+
+		typed.Kind = kind
+		return typed
+	case If:
+		return c.ifExpr(expr, nil)
+	case Binary:
+		if val, ok := constantValue(expr); ok {
+			return c.constant(expr, val, constantDefault(val))
+		}
+		return c.binary(expr)
+	case Unary:
+		if val, ok := constantValue(expr); ok {
+			return c.constant(expr, val, constantDefault(val))
+		}
+		switch expr.Op {
+		case STAR:
+			return c.deref(expr)
+		case AMPERSAND:
+			return c.addressOf(expr)
+		}
+		of := c.expr(expr.Of)
+		typed := Typed{Unary{expr.Op, of, expr.Pos}, of.Kind}
+
+		if !unaryOperatorDefined(expr.Op, of.Kind) {
+			c.errorf(ErrUndefinedOperator, expr.Pos, "operator '%s' is not defined for type '%s'", tokenText[expr.Op], of.Kind)
+			typed.Kind = typeInvalid
+		}
+
+		return typed
+	case While:
+		cond := c.expr(expr.Condition)
+		if !identical(cond.Kind, typeBool) {
+			c.errorf(ErrMismatchedTypes, posOf(expr.Condition), "loop condition has type '%s', not type 'bool'", cond.Kind)
+		}
+
+		c.loops++
+		body := c.expr(expr.Body)
+		c.loops--
+
+		return Typed{While{cond, body, expr.Pos}, typeNiets}
+	case For:
+		from, to := c.pair(expr.From, expr.To, nil)
+		if !identical(from.Kind, to.Kind) || !isKind(underlying(from.Kind), basicInt) && from.Kind != typeInvalid {
+			c.errorf(ErrMismatchedTypes, expr.Ident.Pos, "cannot range from type '%s' to type '%s'", from.Kind, to.Kind)
+		}
+
+		c.pushScope()
+		c.scope.symbols[expr.Ident.Name] = &symbol{Name: expr.Ident.Name, Kind: symbolValue, Type: from.Kind, Pos: expr.Ident.Pos}
+		c.loops++
+		body := c.expr(expr.Body)
+		c.loops--
+		c.popScope()
+
+		return Typed{For{expr.Ident, from, to, body, expr.Pos}, typeNiets}
+	case Match:
+		return c.match(expr, nil)
+	case Break:
+		if c.loops == 0 {
+			c.errorf(ErrOutsideLoop, expr.Pos, "break outside of a loop")
+		}
+		return Typed{expr, typeNiets}
+	case Continue:
+		if c.loops == 0 {
+			c.errorf(ErrOutsideLoop, expr.Pos, "continue outside of a loop")
+		}
+		return Typed{expr, typeNiets}
+	case Typed:
+		return expr
+	}
+
+	panic("unhandled")
+}
+
+func (c *checker) block(expr Block, want tawaType) Typed {
+	var statements Block
+	var kind tawaType = typeNiets
+
+	// whether closures capture a variable is only known once the rest of
+	// the block has been checked
+	vars := map[int]*symbol{}
+
+	c.pushScope()
+	for idx, statement := range expr {
+		var typed Typed
+		if idx == len(expr)-1 {
+			typed = c.exprExpecting(statement, want)
+		} else {
+			typed = c.expr(statement)
+		}
+		if decl, ok := typed.Expr.(MutDeclaration); ok {
+			vars[idx] = c.scope.symbols[decl.To.Name]
+		}
+		statements = append(statements, typed)
+		kind = typed.Kind
+	}
+	c.popScope()
+
+	for idx, sym := range vars {
+		if sym.captured {
+			typed := statements[idx].(Typed)
+			decl := typed.Expr.(MutDeclaration)
+			decl.Captured = true
+			statements[idx] = Typed{decl, typed.Kind}
+		}
+	}
+
+	return Typed{statements, kind}
+}
+
+func (c *checker) ifExpr(expr If, want tawaType) Typed {
+	cond := c.expr(expr.Condition)
+	if !identical(cond.Kind, typeBool) {
+		c.errorf(ErrMismatchedTypes, posOf(expr.Condition), "if condition has type '%s', not type 'bool'", cond.Kind)
+	}
+	then, elseExpr := c.pair(expr.Then, expr.Else, want)
+
+	// an if only has a value when both of its branches agree on one
+	var kind tawaType = typeNiets
+	if then.Kind == typeInvalid {
+		kind = elseExpr.Kind
+	} else if identical(then.Kind, elseExpr.Kind) {
+		kind = then.Kind
+	}
+
+	return Typed{If{cond, then, elseExpr, expr.Pos}, kind}
+}
+
+// pair checks two expressions that should have the same type, like the
+// operands of a binary operator. an untyped constant takes the type of the
+// other expression, unless a type is wanted for both.
+func (c *checker) pair(a, b Expression, want tawaType) (Typed, Typed) {
+	if isNumeric(want) {
+		return c.exprExpecting(a, want), c.exprExpecting(b, want)
+	}
+
+	aVal, aConst := constantValue(a)
+	bVal, bConst := constantValue(b)
+	switch {
+	case aConst && bConst:
+		// both are constants, so they're the default type of the more
+		// general one
+		kind := constantDefault(aVal)
+		if kind == typeInt64 {
+			kind = constantDefault(bVal)
+		}
+		return c.constant(a, aVal, kind), c.constant(b, bVal, kind)
+	case aConst:
+		typedB := c.exprExpecting(b, want)
+		return c.exprExpecting(a, typedB.Kind), typedB
+	case bConst:
+		typedA := c.exprExpecting(a, want)
+		return typedA, c.exprExpecting(b, typedA.Kind)
+	}
+
+	typedA, typedB := c.exprExpecting(a, want), c.exprExpecting(b, want)
+	return untypedNil(typedA, typedB.Kind), untypedNil(typedB, typedA.Kind)
+}
+
+func (c *checker) binary(expr Binary) Typed {
+	lhs, rhs := c.pair(expr.Left, expr.Right, nil)
+	typed := Typed{Binary{expr.Op, lhs, rhs, expr.Pos}, lhs.Kind}
+
+	if lhs.Kind == typeInvalid || rhs.Kind == typeInvalid {
+		typed.Kind = typeInvalid
+		return typed
+	}
+	if !identical(lhs.Kind, rhs.Kind) {
+		c.diags.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrMismatchedTypes,
+			Location: expr.Pos,
+			Message:  fmt.Sprintf("mismatched types '%s' and '%s' for operator '%s'", lhs.Kind, rhs.Kind, tokenText[expr.Op]),
+			Notes: []Note{
+				{fmt.Sprintf("has type '%s'", lhs.Kind), posOf(lhs)},
+				{fmt.Sprintf("has type '%s'", rhs.Kind), posOf(rhs)},
+			},
+		})
+		typed.Kind = typeInvalid
+		return typed
+	}
+	if !binaryOperatorDefined(expr.Op, lhs.Kind) {
+		c.errorf(ErrUndefinedOperator, expr.Pos, "operator '%s' is not defined for type '%s'", tokenText[expr.Op], lhs.Kind)
+		typed.Kind = typeInvalid
+		return typed
+	}
+
+	switch expr.Op {
+	case DOUBLEEQUALS, NOTEQUALS, LESS, LESSEQUALS, GREATER, GREATEREQUALS:
+		typed.Kind = typeBool
+	}
+
+	return typed
+}
+
+// conversion checks a call of a numeric type, which converts a number of any
+// width to that type.
+func (c *checker) conversion(call Call, to tawaType) Typed {
+	if len(call.Arguments) != 1 {
+		c.errorf(ErrArgumentCount, call.Function.Pos, "conversion to '%s' takes 1 argument, not %d", to, len(call.Arguments))
+		return Typed{call, to}
+	}
+	if isInterface(to) {
+		boxed := c.toInterface(call.Arguments[0].(Typed), to)
+		if !identical(boxed.Kind, to) {
+			c.errorf(ErrMismatchedTypes, posOf(call.Arguments[0]), "cannot convert value of type '%s' to '%s'", boxed.Kind, to)
+			boxed.Kind = typeInvalid
+		}
+		return boxed
+	}
+	if from := typeOf(call.Arguments[0]); from != typeInvalid && !isNumeric(from) {
+		c.errorf(ErrMismatchedTypes, posOf(call.Arguments[0]), "cannot convert value of type '%s' to '%s'", from, to)
+	}
+
+	// constants are converted while checking, so they have to fit
+	if arg, ok := call.Arguments[0].(Typed); ok {
+		if b, ok := underlying(to).(*basicType); ok && isNumeric(to) {
+			if val, ok := constantValue(arg.Expr); ok {
+				if b.kind == basicInt {
+					val = truncate(val)
+				}
+				call.Arguments[0] = c.constant(arg.Expr, val, to)
+			}
+		}
+	}
+	return Typed{call, to}
+}
+
+// builtinCall checks a call of a builtin function.
+func (c *checker) builtinCall(expr Call, b *builtinType) Typed {
+	args := c.arguments(expr.Arguments, nil)
+	call := Call{expr.Function, expr.TypeArguments, args, expr.Pos}
+	if len(args) != 1 {
+		c.errorf(ErrArgumentCount, expr.Function.Pos, "builtin '%s' takes 1 argument, not %d", b.name, len(args))
+		return Typed{call, typeInvalid}
+	}
+
+	switch b.name {
+	case "free":
+		if kind := typeOf(args[0]); kind != typeInvalid {
+			if _, ok := underlying(kind).(*pointerType); !ok {
+				c.errorf(ErrMismatchedTypes, posOf(args[0]), "cannot free a value of type '%s'", kind)
+			}
+		}
+		return Typed{call, typeNiets}
+	case "len":
+		switch kind := typeOf(args[0]); underlying(kind).(type) {
+		case *arrayType, *sliceType:
+		default:
+			if kind != typeInvalid && !isKind(underlying(kind), basicString) {
+				c.errorf(ErrMismatchedTypes, posOf(args[0]), "cannot take the length of a value of type '%s'", kind)
+			}
+		}
+		return Typed{call, typeInt64}
+	}
+
+	panic("unhandled")
+}
+
+// variantHint suggests how to construct a variant carrying a payload.
+func variantHint(name string, payload tawaType) string {
+	if _, ok := underlying(payload).(*structType); ok {
+		return fmt.Sprintf("construct it with %s { ... }", name)
+	}
+	return fmt.Sprintf("construct it with %s(...)", name)
+}
+
+// variantCall checks constructing a variant of a sum type from its payload,
+// like Circle(1.5).
+func (c *checker) variantCall(expr Call, sym *symbol) Typed {
+	name := expr.Function.Name
+	_, payload := underlying(sym.Type).(*sumType).variant(unqualified(name))
+
+	var args []Expression
+	for _, arg := range expr.Arguments {
+		args = append(args, c.exprExpecting(arg, payload))
+	}
+	typed := Typed{Call{expr.Function, expr.TypeArguments, args, expr.Pos}, sym.Type}
+
+	switch {
+	case payload == nil:
+		c.errorf(ErrArgumentCount, expr.Function.Pos, "variant %s of %s doesn't carry a value", name, sym.Type)
+	case len(args) != 1:
+		c.errorf(ErrArgumentCount, expr.Function.Pos, "variant %s takes 1 value, not %d", name, len(args))
+	case !identical(payload, typeOf(args[0])):
+		c.diags.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrMismatchedTypes,
+			Location: posOf(args[0]),
+			Message:  fmt.Sprintf("variant %s carries a value of type '%s', not type '%s'", name, payload, typeOf(args[0])),
+			Label:    fmt.Sprintf("expected '%s', found '%s'", payload, typeOf(args[0])),
+			Notes:    []Note{{"variant declared here", sym.Pos}},
+		})
+	}
+
+	return typed
+}
+
+// match checks a match on a value of a sum type, which has to handle every
+// variant with an arm of its own or with a wildcard arm.
+func (c *checker) match(expr Match, want tawaType) Typed {
+	of := c.expr(expr.Of)
+	sum, _ := underlying(of.Kind).(*sumType)
+	if sum == nil && of.Kind != typeInvalid {
+		c.errorf(ErrNotASum, posOf(expr.Of), "cannot match on a value of type '%s', which isn't a sum type", of.Kind)
+	}
+
+	// patterns are checked in order, so that arms that can never be reached
+	// are found
+	payloads := make([]tawaType, len(expr.Arms))
+	handled := map[string]bool{}
+	wildcard := false
+	for i, arm := range expr.Arms {
+		payloads[i] = typeInvalid
+		name := arm.Variant.Name
+
+		if name == "_" {
+			if arm.Binding != nil {
+				c.errorf(ErrArgumentCount, arm.Binding.Pos, "a wildcard arm can't bind a value")
+			}
+			if wildcard || sum != nil && len(handled) == len(sum.variants) {
+				c.errorf(ErrUnreachableArm, arm.Variant.Pos, "every variant is already matched")
+			}
+			wildcard = true
+			continue
+		}
+		if sum == nil {
+			continue
+		}
+
+		idx, payload := sum.variant(name)
+		switch {
+		case idx == -1:
+			c.errorf(ErrUnknownVariant, arm.Variant.Pos, "%s is not a variant of %s", name, of.Kind)
+			continue
+		case handled[name] || wildcard:
+			c.errorf(ErrUnreachableArm, arm.Variant.Pos, "variant %s is already matched", name)
+		}
+		handled[name] = true
+
+		if arm.Binding != nil && payload == nil {
+			c.errorf(ErrArgumentCount, arm.Binding.Pos, "variant %s doesn't carry a value to bind", name)
+		} else if payload != nil {
+			payloads[i] = payload
+		}
+	}
+
+	if sum != nil && !wildcard {
+		var missing []string
+		for _, variant := range sum.variants {
+			if !handled[variant.Name] {
+				missing = append(missing, variant.Name)
+			}
+		}
+		if len(missing) != 0 {
+			c.diags.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     ErrNonExhaustive,
+				Location: Span{expr.Pos.From, posOf(expr.Of).To},
+				Message:  fmt.Sprintf("match on %s doesn't handle %s", of.Kind, strings.Join(missing, ", ")),
+				Hints:    []string{"add arms for them, or a wildcard arm like _ => ..."},
+			})
+		}
+	}
+
+	// arms that are untyped constants are checked last, so that they can
+	// take the type of the other arms
+	arms := make([]MatchArm, len(expr.Arms))
+	checkArm := func(i int, want tawaType) {
+		arm := expr.Arms[i]
+		c.pushScope()
+		if arm.Binding != nil {
+			c.scope.symbols[arm.Binding.Name] = &symbol{Name: arm.Binding.Name, Kind: symbolValue, Type: payloads[i], Pos: arm.Binding.Pos}
+		}
+		arm.Body = c.exprExpecting(arm.Body, want)
+		c.popScope()
+		arms[i] = arm
+	}
+
+	armWant := want
+	for i, arm := range expr.Arms {
+		if _, ok := constantValue(arm.Body); !ok {
+			checkArm(i, want)
+			if armWant == nil && typeOf(arms[i].Body) != typeInvalid {
+				armWant = typeOf(arms[i].Body)
+			}
+		}
+	}
+	for i, arm := range expr.Arms {
+		if _, ok := constantValue(arm.Body); ok {
+			checkArm(i, armWant)
+		}
+	}
+
+	// like an if, a match only has a value when all of its arms agree on one
+	var kind tawaType
+	for _, arm := range arms {
+		switch armKind := typeOf(arm.Body); {
+		case armKind == typeInvalid:
+		case kind == nil:
+			kind = armKind
+		case !identical(kind, armKind):
+			kind = typeNiets
+		}
+	}
+	if kind == nil {
+		kind = typeNiets
+	}
+
+	return Typed{Match{of, arms, expr.Pos}, kind}
+}
+
+func binaryOperatorDefined(op TokenKind, t tawaType) bool {
+	if _, ok := underlying(t).(*pointerType); ok {
+		return op == DOUBLEEQUALS || op == NOTEQUALS
+	}
+	b, ok := underlying(t).(*basicType)
+	if !ok {
+		return false
+	}
+
+	switch op {
+	case PLUS, MINUS, STAR, SLASH, PERCENT, LESS, LESSEQUALS, GREATER, GREATEREQUALS:
+		return b.kind == basicInt || b.kind == basicFloat
+	case DOUBLEEQUALS, NOTEQUALS:
+		return b.kind == basicInt || b.kind == basicFloat || b.kind == basicBool
+	case ANDAND, OROR:
+		return b.kind == basicBool
+	case AMPERSAND, PIPE, CARET:
+		return b.kind == basicInt || b.kind == basicBool
+	case SHIFTLEFT, SHIFTRIGHT:
+		return b.kind == basicInt
+	}
+
+	return false
+}
+
+func unaryOperatorDefined(op TokenKind, t tawaType) bool {
+	if t == typeInvalid {
+		return true
+	}
+
+	switch op {
+	case MINUS:
+		return isNumeric(t)
+	case BANG:
+		return isKind(underlying(t), basicBool)
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkSource(t *testing.T, src string) []error {
+	t.Helper()
+
+	p := NewParser(NewLexer(strings.NewReader(src), "test"))
+	if err := p.Parse(); err != nil {
+		t.Fatalf("failed to parse %q: %s", src, err)
+	}
+
+	diags := &Diagnostics{}
+	check(p.ast.Toplevels, settings{}, diags)
+
+	var errs []error
+	for _, diag := range diags.List() {
+		errs = append(errs, diag)
+	}
+	return errs
+}
+
+func TestCheckerAccepts(t *testing.T) {
+	sources := []string{
+		"func main() {\n    print(`hi`)\n}\n",
+		"type P struct {\n    x: int64\n}\nfunc main() {\n    var p = P { x: 1 }\n    p.x = p.x + 1\n}\n",
+		"func f(a: int64, b: int64) bool => a < b && !(a == b)\n",
+		"func main() {\n    for i in 0..10 {\n        if i == 2 then break else continue\n    }\n}\n",
+		"func f(a: int32) float32 => float32(float64(a) * 1.5e3 / 0x1p4)\n",
+		"func f() int64 => -9223372036854775808 + (0x8000_0000_0000_0000 - 1) + int64(2.9) + int64(int8(-128))\n",
+		"func f(c: rune) bool => c == 'a' || c == '\\n'\n",
+		"type B struct {\n    b: byte\n    i: int8\n}\nfunc f(x: int16) int16 => if x > 0x7f then 2 * x else -1\nfunc main() => B { b: 0b1111_1111, i: -128 }\n",
+		"type S =\n    | Circle of float64\n    | Rect of struct {\n        w: float64\n    }\n    | Empty\nfunc f(s: S) float64 => match s {\n    Circle(r) => r * 2\n    Rect(r) => r.w\n    Empty => 0\n}\nfunc main() => f(Rect { w: 1 })\n",
+		"type M struct {\n    w: float64\n}\nfunc (m M) Area(by: float64) float64 => m.w * by\nfunc main() => M { w: 1 }.Area(2) + 1\n",
+		"type W interface {\n    Write(s: string) int64\n}\ntype C struct {\n    n: int64\n}\nfunc (c C) Write(s: string) int64 => c.n\nfunc log(w: W) int64 => w.Write(`a`)\nfunc main() => log(C { n: 1 }) + W(C { n: 2 }).Write(`b`)\n",
+		"type Box[T] struct {\n    v: T\n}\nfunc Max[T](a: T, b: T) T => if a > b then a else b\nfunc wrap[T](x: T) Box[T] => Box[T] { v: x }\nfunc main() => Max(wrap(1).v, 2) + Max[int64](3, 4) + Box[int64] { v: 5 }.v\n",
+		"type B struct {\n    cb: func(int64) int64\n}\nfunc adder(n: int64) func(int64) int64 => func(x: int64) int64 => x + n\nfunc main() {\n    var total = 0\n    let add = func(by: int64) {\n        total = total + by\n    }\n    add(B { cb: adder(1) }.cb(2))\n}\n",
+		"func sum(xs: []int64) int64 {\n    var total = 0\n    for i in 0..len(xs) {\n        total = total + xs[i]\n    }\n    total\n}\nfunc main() {\n    var a = [3]int64 { 1, 2 }\n    a[2] = 3\n    sum(a[1:]) + len(`hi`) + int64(`hi`[0])\n}\n",
+		"type N struct {\n    v: int64\n    next: *N\n}\nfunc bump(p: *int64) {\n    *p = *p + 1\n}\nfunc main() {\n    var x = 1\n    bump(&x)\n    let n = new N { v: x, next: nil }\n    n.v = 2\n    if n.next == nil then free(n) else free(n.next)\n}\n",
+	}
+
+	for _, src := range sources {
+		if errs := checkSource(t, src); len(errs) != 0 {
+			t.Errorf("unexpected errors for %q: %v", src, errs)
+		}
+	}
+}
+
+func TestCheckerErrors(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"func main() {\n    nope(1)\n}\n", "undefined: nope"},
+		{"func f() string => 1\n", "returns 'string', but its body has type 'int64'"},
+		{"func main() {\n    let x = 1\n    x = 2\n}\n", "x is not mutable"},
+		{"func main() {\n    print(1)\n}\n", "of type 'int64', not type 'string'"},
+		{"func main() => 1 + `a`\n", "mismatched types 'int64' and 'string'"},
+		{"type A struct {\n    a: A\n}\n", "invalid recursive type A"},
+		{"func main() {\n    break\n}\n", "break outside of a loop"},
+		{"func main() {\n    let a = 1.5\n    let b = 1\n    a + b\n}\n", "mismatched types 'float64' and 'int64'"},
+		{"func main() => int64(`a`)\n", "cannot convert value of type 'string' to 'int64'"},
+		{"type B struct {\n    b: byte\n}\nfunc main() => B { b: 256 }\n", "constant 256 overflows byte"},
+		{"func f(x: int8) int8 => x + 200\n", "constant 200 overflows int8"},
+		{"func main() => int8(300)\n", "constant 300 overflows int8"},
+		{"func main() => byte(-1)\n", "constant -1 overflows byte"},
+		{"func main() => int64(1e20)\n", "constant 100000000000000000000 overflows int64"},
+		{"func main() => int32(-2.5e9)\n", "constant -2500000000 overflows int32"},
+		{"func main() => 9223372036854775808\n", "constant 9223372036854775808 overflows int64"},
+		{"func main() => 1 / (2 - 2)\n", "division by zero"},
+		{"type B struct {\n    b: byte\n}\nfunc main() => B { b: '€' }\n", "constant 8364 overflows byte"},
+		{"type S = | A | B of int64\nfunc f(s: S) => match s {\n    A => 1\n}\n", "match on S doesn't handle B"},
+		{"type S = | A | B of int64\nfunc f(s: S) => match s {\n    C => 1\n    _ => 2\n}\n", "C is not a variant of S"},
+		{"type S = | A | B of int64\nfunc f(s: S) => match s {\n    A => 1\n    A => 2\n    _ => 3\n}\n", "variant A is already matched"},
+		{"type M struct {\n    w: int64\n}\nfunc main() => M { w: 1 }.Area()\n", "type 'M' has no method 'Area'"},
+		{"func (i int64) Double() int64 => i * 2\n", "invalid receiver type int64"},
+		{"type W interface {\n    Write(s: string) int64\n}\ntype C struct {\n    n: int64\n}\nfunc log(w: W) => 1\nfunc main() => log(C { n: 1 })\n", "type 'C' doesn't implement 'W': missing method Write"},
+		{"type W interface {\n    Write(s: string) int64\n}\ntype C struct {\n    n: int64\n}\nfunc (c C) Write(s: string) => 1\nfunc log(w: W) => 1\nfunc main() => log(C { n: 1 })\n", "method Write has type 'func(string)', not 'func(string) int64'"},
+		{"type W interface {\n    Write(s: string) int64\n}\nfunc log(w: W) => w.Close()\n", "type 'W' has no method 'Close'"},
+		{"type M struct {\n    w: int64\n}\nfunc (m M) w() int64 => 1\n", "type M has both a field and a method named w"},
+		{"type M struct {\n    w: int64\n}\nfunc (m M) A() => 1\nfunc (m M) A() => 2\n", "method M.A redeclared"},
+		{"type M struct {\n    w: int64\n}\nfunc (m M) A(x: int64) => x\nfunc main() => M { w: 1 }.A(`a`)\n", "argument 1 of method 'M.A' is of type 'string'"},
+		{"func zero[T]() int64 => 0\nfunc main() => zero()\n", "cannot infer type parameter T of zero"},
+		{"type Box[T] struct {\n    v: T\n}\nfunc main() => Box[int64, bool] { v: 1 }\n", "Box takes 1 type arguments, not 2"},
+		{"func Max[T](a: T, b: T) T => if a > b then a else b\nfunc main() => Max(1, `a`)\n", "argument 1 of function 'Max' is of type 'int64', not type 'string'"},
+		{"func main() => (1)(2)\n", "cannot call a value of type 'int64'"},
+		{"func main() => [3]int64 { 1, 2, 3 }[3]\n", "index 3 is out of range for '[3]int64'"},
+		{"func main() => 1[0]\n", "cannot index a value of type 'int64'"},
+		{"func main() {\n    let a = [2]int64 {}\n    a[0] = 1\n}\n", "cannot assign to an element of an immutable array"},
+		{"func main() => *1\n", "cannot dereference a value of type 'int64'"},
+		{"func f(x: int64) *int64 => &x\n", "cannot take the address of a value that isn't stored in a variable"},
+		{"func main() {\n    let p = nil\n}\n", "cannot declare p with a value of type 'nil'"},
+		{"import `nowhere`\nfunc main() => 1\n", "cannot import \"nowhere\": no package named nowhere was found"},
+	}
+
+	for _, tc := range cases {
+		errs := checkSource(t, tc.src)
+		if len(errs) == 0 {
+			t.Errorf("expected an error containing %q for %q", tc.want, tc.src)
+			continue
+		}
+		if !strings.Contains(errs[0].Error(), tc.want) {
+			t.Errorf("expected an error containing %q for %q, got %q", tc.want, tc.src, errs[0])
+		}
+	}
+}
+
+func TestCheckerQualifiedNames(t *testing.T) {
+	sets := settings{importPaths: []string{ooflib(t)}}
+
+	accepted := []string{
+		"import o `ooflib`\nfunc main() => o.Oof(1) + o.Max[int64](2, 3)\n",
+		"import o `ooflib`\nfunc x(s: o.Shape) int64 => s.X()\nfunc main() => x(o.Dot(o.Pt { x: 1, y: 2 })) + o.Pt { x: 3, y: 4 }.y\n",
+		"import o `ooflib`\nfunc f(s: o.Shape) int64 => match s {\n    Dot(p) => p.x\n    Empty => 0\n}\n",
+	}
+	for _, src := range accepted {
+		if _, diags := checkFiles(t, sets, "test", src); len(diags) != 0 {
+			t.Errorf("unexpected errors for %q: %v", src, diags)
+		}
+	}
+
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"import o `ooflib`\nfunc main() => ooflib.Oof(1)\n", "undefined: ooflib"},
+		{"import o `ooflib`\nfunc main() => o.Nope(1)\n", "undefined: o.Nope"},
+		{"import o `ooflib`\nfunc main() => o.Nope { x: 1 }\n", "undefined: o.Nope"},
+		{"import o `ooflib`\nfunc main() => o.Oof(`a`)\n", "of type 'string', not type 'int64'"},
+		{"import o `ooflib`\nfunc main() => o.Pt { x: 1, z: 2 }\n", "z"},
+		{"import o `ooflib`\nimport o `ooflib`\nfunc main() => o.Oof(1)\n", "o is imported more than once"},
+	}
+	for _, c := range cases {
+		_, diags := checkFiles(t, sets, "test", c.src)
+		if len(diags) == 0 || !strings.Contains(diags[0].Message, c.want) {
+			t.Errorf("expected an error containing %q for %q, got %v", c.want, c.src, diags)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+// closure is a lambda being checked, which captures the variables of the
+// functions around it that its body uses.
+type closure struct {
+	// scope is the scope of the lambda's parameters, so leaving it while
+	// looking up a name means the name is declared outside of the lambda.
+	scope    *scope
+	captures []Capture
+	captured map[*symbol]bool
+}
+
+// use looks up a name used as a value. locals of the functions around the
+// lambdas it's used in are captured by them.
+func (c *checker) use(name string) *symbol {
+	inside := len(c.closures)
+	for s := c.scope; s != nil; s = s.parent {
+		sym, ok := s.symbols[name]
+		if !ok {
+			if inside > 0 && c.closures[inside-1].scope == s {
+				inside--
+			}
+			continue
+		}
+
+		if sym.Kind != symbolValue && sym.Kind != symbolMutable || s.parent == nil {
+			return sym
+		}
+		// every lambda between the use and the declaration needs the
+		// variable, so that the inner ones can capture it in turn
+		for _, cl := range c.closures[inside:] {
+			if cl.captured[sym] {
+				continue
+			}
+			cl.captured[sym] = true
+			cl.captures = append(cl.captures, Capture{Identifier{name, sym.Pos}, sym.Kind == symbolMutable})
+			if sym.Kind == symbolMutable {
+				sym.captured = true
+			}
+		}
+		return sym
+	}
+
+	return nil
+}
+
+// lambda checks an anonymous function. like other functions, it only returns
+// a value if it's declared with a return type.
+func (c *checker) lambda(expr Lambda) Typed {
+	sig := c.signature(expr.Arguments, expr.Returns)
+
+	cl := &closure{scope: newScope(c.scope), captured: map[*symbol]bool{}}
+	c.closures = append(c.closures, cl)
+	scope, loops := c.scope, c.loops
+	c.scope, c.loops = cl.scope, 0
+
+	fn := c.checkFunc(Func{
+		Ident:     Identifier{"lambda", expr.Pos},
+		Arguments: expr.Arguments,
+		Returns:   expr.Returns,
+		Expr:      expr.Expr,
+	}, sig, nil)
+
+	c.scope, c.loops = scope, loops
+	c.closures = c.closures[:len(c.closures)-1]
+
+	expr.Arguments, expr.Returns, expr.Expr = fn.Arguments, fn.Returns, fn.Expr
+	expr.Captures = cl.captures
+	return Typed{expr, sig}
+}
+
+// callValue checks a call of a function value, like s.callback(x).
+func (c *checker) callValue(expr CallValue) Typed {
+	// the instances of generic functions packages export are called like
+	// pkg.Max[int64](a, b), which parses like indexing a function value
+	if idx, ok := expr.Callee.(Index); ok {
+		if field, ok := idx.Of.(Field); ok {
+			arg, isVar := idx.Index.(Var)
+			if fn, ok := c.selector(field.Of, field.Ident); ok && isVar {
+				return c.expr(Call{fn, []Type{Ident(arg)}, expr.Arguments, expr.Pos})
+			}
+		}
+	}
+
+	callee := c.expr(expr.Callee)
+	fn, ok := underlying(callee.Kind).(*funcType)
+
+	var params []tawaType
+	if ok {
+		params = fn.params
+	}
+	args := c.arguments(expr.Arguments, params)
+	call := CallValue{callee, args, expr.Pos}
+
+	if !ok {
+		if callee.Kind != typeInvalid {
+			c.errorf(ErrNotAFunction, posOf(expr.Callee), "cannot call a value of type '%s'", callee.Kind)
+		}
+		return Typed{call, typeInvalid}
+	}
+
+	name := Identifier{"value", posOf(expr.Callee)}
+	if field, ok := expr.Callee.(Field); ok {
+		name.Name = field.Ident.Name
+	}
+	c.checkArguments("function", name, args, fn, Span{})
+	return Typed{call, fn.returns}
+}
@@ -1,17 +1,12 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"hash/fnv"
-	"log"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
-	"unicode"
 
-	"github.com/alecthomas/repr"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/enum"
@@ -35,7 +30,12 @@ type LLVMValue struct {
 type LLVMType struct {
 	NamedThingImpl
 	types.Type
-	fields map[string]int
+}
+
+// LLVMBuiltin is a builtin function generated inline wherever it's called.
+type LLVMBuiltin struct {
+	NamedThingImpl
+	Name string
 }
 
 type uerror interface {
@@ -62,8 +62,64 @@ type ctx struct {
 	forwardDeclarationPass bool
 	stringConstants        map[string]value.Value
 	sets                   settings
-	publicSymbolPrefix     string
 	ti                     typeInfo
+
+	// block is the basic block instructions are currently being emitted into.
+	// expressions that introduce control flow leave it pointing at the block
+	// where evaluation continues.
+	block      *ir.Block
+	blockCount int
+
+	// loops holds where break and continue jump to for the loops
+	// enclosing the expression being generated, innermost last.
+	loops []loopTargets
+
+	// sums holds the layouts of the sum types being generated, by name.
+	sums map[string]*sumLayout
+
+	// vtables and thunks hold what values of interface types call methods
+	// through, which are generated the first time they're needed.
+	module  *ir.Module
+	vtables map[string]*ir.Global
+	thunks  map[string]*ir.Func
+
+	// mallocFn and freeFn are declared the first time they're called, and
+	// lambdaCount numbers the functions generated for lambdas.
+	mallocFn    *ir.Func
+	freeFn      *ir.Func
+	lambdaCount int
+
+	// gc is the runtime of the garbage collector of programs built with
+	// one, which everything on the heap is allocated through.
+	gc *collector
+}
+
+type loopTargets struct {
+	continueTo *ir.Block
+	breakTo    *ir.Block
+}
+
+// newBlock creates a new basic block in the current function with a unique name.
+func (c *ctx) newBlock(name string) *ir.Block {
+	c.blockCount++
+	return c.block.Parent.NewBlock(name + "." + strconv.Itoa(c.blockCount))
+}
+
+// alloca reserves stack space in the entry block of the current function, so
+// that allocations made inside of loops don't grow the stack every iteration.
+func (c *ctx) alloca(t types.Type) *ir.InstAlloca {
+	entry := c.block.Parent.Blocks[0]
+	inst := ir.NewAlloca(t)
+	entry.Insts = append([]ir.Instruction{inst}, entry.Insts...)
+
+	return inst
+}
+
+// jump terminates the current block with a branch. anything generated
+// afterwards lands in a fresh block that can't be reached.
+func (c *ctx) jump(to *ir.Block) {
+	c.block.NewBr(to)
+	c.block = c.newBlock("dead")
 }
 
 func (c *ctx) pushScope() {
@@ -85,20 +141,6 @@ func (c *ctx) lookup(id Identifier) namedThing {
 	panic("could not lookup " + id.Name)
 }
 
-func (c *ctx) lookupField(t types.Type, f string) (int, error) {
-	for i := len(c.names) - 1; i >= 0; i-- {
-		for _, kind := range c.names[i] {
-			if val, ok := kind.(LLVMType); ok {
-				if val.Equal(t) {
-					return val.fields[f], nil
-				}
-			}
-		}
-	}
-
-	return -1, errors.New("could not find the given type in the current context when accessing a field")
-}
-
 func (c *ctx) assign(id Identifier, v namedThing) {
 	for i := len(c.names) - 1; i >= 0; i-- {
 		_, ok := c.names[i][id.Name]
@@ -116,6 +158,29 @@ func (c *ctx) top() map[string]namedThing {
 }
 
 func posOf(e Expression) Span {
+	switch expr := e.(type) {
+	case Typed:
+		return posOf(expr.Expr)
+	case Box:
+		return posOf(expr.Of)
+	case If:
+		return expr.Pos
+	case While:
+		return posOf(expr.Condition)
+	case Field:
+		return expr.Ident.Pos
+	case Declaration:
+		return expr.To.Pos
+	case MutDeclaration:
+		return expr.To.Pos
+	case For:
+		return expr.Ident.Pos
+	case Block:
+		if len(expr) > 0 {
+			return posOf(expr[len(expr)-1])
+		}
+	}
+
 	defer func() {
 		recover()
 	}()
@@ -136,190 +201,337 @@ func hash(s string) string {
 	return strconv.FormatUint(uint64(h.Sum32()), 10)
 }
 
-func codegenExpression(c *ctx, e Expression, b *ir.Block) value.Value {
-	switch expr := e.(type) {
-	case Lit:
-		switch lit := expr.Literal.(type) {
-		case Integer:
-			return constant.NewInt(Int64.Type.(*types.IntType), int64(lit))
-		case StructLiteral:
-			t := c.lookup(lit.Ident).(LLVMType)
-			st := t.Type.(*types.StructType)
-
-			val := b.NewAlloca(t.Type.(*types.StructType))
-			for name, field := range lit.Fields {
-				ptr := b.NewGetElementPtr(st, val, constant.NewInt(types.I32, int64(0)), constant.NewInt(types.I32, int64(t.fields[name])))
-				expr := codegenExpression(c, field, b)
-
-				fieldType := st.Fields[t.fields[name]]
-				if !fieldType.Equal(expr.Type()) {
-					panic(NewUError("%s: field '%s' has type '%s', not type '%s'", posOf(field), name, fieldType.Name(), expr.Type().Name()))
-				}
+func codegenStruct(c *ctx, lit StructLiteral, st types.Type, kind tawaType) value.Value {
+	fields := underlying(kind).(*structType)
 
-				b.NewStore(expr, ptr)
-			}
+	// fields that aren't given a value are zeroed
+	var val value.Value = constant.NewZeroInitializer(st)
+	for idx, field := range fields.fields {
+		if expr, ok := lit.Fields[field.Name]; ok {
+			val = c.block.NewInsertValue(val, codegenExpression(c, expr), uint64(idx))
+		}
+	}
 
-			return val
-		case StringLiteral:
-			val := b.NewAlloca(String.Type)
-			val.Typ = StringPointer.Type.(*types.PointerType)
+	return val
+}
 
-			dlen := getStructElm(b, String.Type, val, 0)
-			data := getStructElm(b, String.Type, val, 1)
+func codegenLiteral(c *ctx, l Literal, kind tawaType) value.Value {
+	switch lit := l.(type) {
+	case Integer:
+		t := basicLLVMTypes[underlying(kind).(*basicType)].Type
+		if float, ok := t.(*types.FloatType); ok {
+			return constant.NewFloat(float, float64(lit))
+		}
+		it, val := t.(*types.IntType), int64(lit)
+		if it.BitSize < 64 {
+			// unsigned constants like 255 for a byte are written as the
+			// signed value with the same bits
+			shift := 64 - it.BitSize
+			val = val << shift >> shift
+		}
+		return constant.NewInt(it, val)
+	case Char:
+		return codegenLiteral(c, Integer(lit), kind)
+	case Float:
+		return constant.NewFloat(basicLLVMTypes[underlying(kind).(*basicType)].Type.(*types.FloatType), float64(lit))
+	case StructLiteral:
+		if v, ok := c.lookup(lit.Ident).(LLVMVariant); ok {
+			_, payload := underlying(kind).(*sumType).variant(unqualified(lit.Ident.Name))
+			return codegenVariant(c, v, codegenStruct(c, lit, v.Layout.Payloads[v.Tag], payload))
+		}
+		return codegenStruct(c, lit, c.lookup(lit.Ident).(LLVMType).Type, kind)
+	case StringLiteral:
+		val := c.alloca(String.Type)
+		val.Typ = StringPointer.Type.(*types.PointerType)
 
-			b.NewStore(constant.NewInt(Int64.Type.(*types.IntType), int64(len(lit))), dlen)
+		dlen := getStructElm(c.block, String.Type, val, 0)
+		data := getStructElm(c.block, String.Type, val, 1)
 
-			rawdata, ok := c.stringConstants[string(lit)]
-			if !ok {
-				sym := b.Parent.Parent.NewGlobalDef("_str_"+hash(string(lit)), constant.NewCharArrayFromString(string(lit)))
-				sym.Immutable = true
-				sym.Visibility = enum.VisibilityHidden
-				rawdata = sym
+		c.block.NewStore(constant.NewInt(Int64.Type.(*types.IntType), int64(len(lit))), dlen)
 
-				c.stringConstants[string(lit)] = rawdata
-			}
+		rawdata, ok := c.stringConstants[string(lit)]
+		if !ok {
+			sym := c.block.Parent.Parent.NewGlobalDef("_str_"+hash(string(lit)), constant.NewCharArrayFromString(string(lit)))
+			sym.Immutable = true
+			sym.Linkage = enum.LinkageInternal
+			rawdata = sym
 
-			casted := b.NewBitCast(rawdata, types.NewPointer(Byte))
-			b.NewStore(casted, data)
+			c.stringConstants[string(lit)] = rawdata
+		}
 
-			return val
-		default:
-			panic("unimplemented")
+		casted := c.block.NewBitCast(rawdata, types.NewPointer(Byte))
+		c.block.NewStore(casted, data)
+
+		return val
+	case ArrayLiteral:
+		return codegenArrayLiteral(c, lit)
+	}
+
+	panic("unimplemented")
+}
+
+// fieldIndex returns the index of a field in values of the given struct type.
+func fieldIndex(kind tawaType, name string) int64 {
+	idx, _ := underlying(kind).(*structType).field(name)
+	return int64(idx)
+}
+
+// codegenAddress returns a pointer to the storage behind an expression that
+// the checker has verified to be addressable.
+func codegenAddress(c *ctx, e Expression) value.Value {
+	switch expr := e.(type) {
+	case Typed:
+		return codegenAddress(c, expr.Expr)
+	case Var:
+		return c.lookup(Identifier(expr)).(LLVMMutableValue).Value
+	case Field:
+		of := codegenAddress(c, expr.Of)
+		return getStructElm(c.block, of.Type().(*types.PointerType).ElemType, of, fieldIndex(typeOf(expr.Of), expr.Ident.Name))
+	case Index:
+		return codegenElement(c, expr)
+	case Unary:
+		return codegenExpression(c, expr.Of)
+	}
+
+	panic("unhandled")
+}
+
+func codegenExpression(c *ctx, e Expression) value.Value {
+	switch expr := e.(type) {
+	case Typed:
+		if box, ok := expr.Expr.(Box); ok {
+			return codegenBox(c, box.Of, expr.Kind)
 		}
+		if lit, ok := expr.Expr.(Lit); ok {
+			return codegenLiteral(c, lit.Literal, expr.Kind)
+		}
+		if v, ok := expr.Expr.(Var); ok && v.Name == "nil" {
+			return constant.NewNull(codegenType(c, typeExpr(expr.Kind, v.Pos)).(*types.PointerType))
+		}
+		if call, ok := expr.Expr.(Call); ok {
+			if to, ok := c.lookup(call.Function).(LLVMType); ok {
+				return codegenConversion(c, call.Arguments[0], to.Type, expr.Kind)
+			}
+		}
+		return codegenExpression(c, expr.Expr)
 	case Var:
 		switch v := c.lookup(Identifier(expr)).(type) {
 		case LLVMValue:
+			if fn, ok := v.Value.(*ir.Func); ok {
+				return c.funcValue(fn)
+			}
 			return v.Value
 		case LLVMMutableValue:
-			return b.NewLoad(v.Value.Type().(*types.PointerType).ElemType, v.Value)
+			return c.block.NewLoad(v.Value.Type().(*types.PointerType).ElemType, v.Value)
+		case LLVMVariant:
+			return codegenVariant(c, v, nil)
 		default:
 			panic("unhandled")
 		}
 	case Call:
-		fn := c.lookup(expr.Function).(LLVMValue).Value
-		fnType := fn.Type().(*types.PointerType).ElemType.(*types.FuncType)
+		if v, ok := c.lookup(expr.Function).(LLVMVariant); ok {
+			return codegenVariant(c, v, codegenExpression(c, expr.Arguments[0]))
+		}
+		if b, ok := c.lookup(expr.Function).(LLVMBuiltin); ok {
+			return codegenBuiltin(c, b.Name, expr.Arguments)
+		}
 
 		var args []value.Value
-		for idx, arg := range expr.Arguments {
-			val := codegenExpression(c, arg, b)
-
-			if pmType := fnType.Params[idx]; !pmType.Equal(val.Type()) {
-				println("===")
-				repr.Println(fnType.Params[idx])
-				repr.Println(val.Type())
-				panic(NewUError("argument %d of function '%s' is of type '%s', not type '%s'", idx, expr.Function.Name, pmType.Name(), val.Type().Name()))
+		for _, arg := range expr.Arguments {
+			args = append(args, codegenExpression(c, arg))
+		}
+		if v, ok := c.lookup(expr.Function).(LLVMValue); ok {
+			if fn, ok := v.Value.(*ir.Func); ok {
+				return c.block.NewCall(fn, args...)
 			}
+		}
+		return codegenClosureCall(c, codegenExpression(c, Var(expr.Function)), args)
+	case CallValue:
+		callee := codegenExpression(c, expr.Callee)
+
+		var args []value.Value
+		for _, arg := range expr.Arguments {
+			args = append(args, codegenExpression(c, arg))
+		}
+		return codegenClosureCall(c, callee, args)
+	case Lambda:
+		return codegenLambda(c, expr)
+	case MethodCall:
+		if iface, ok := underlying(typeOf(expr.Of)).(*interfaceType); ok {
+			return codegenInterfaceCall(c, expr, iface)
+		}
+		named := typeOf(expr.Of).(*namedType)
+		fn := c.lookup(Identifier{Name: named.name + "." + expr.Method.Name}).(LLVMValue).Value
 
-			args = append(args, codegenExpression(c, arg, b))
+		args := []value.Value{codegenExpression(c, expr.Of)}
+		for _, arg := range expr.Arguments {
+			args = append(args, codegenExpression(c, arg))
 		}
-		return b.NewCall(fn, args...)
+		return c.block.NewCall(fn, args...)
 	case Block:
 		var last value.Value
 
 		c.pushScope()
 		for _, statement := range expr {
-			last = codegenExpression(c, statement, b)
+			last = codegenExpression(c, statement)
 		}
 		c.popScope()
 
 		return last
 	case Declaration:
-		val := codegenExpression(c, expr.Value, b)
+		val := codegenExpression(c, expr.Value)
 
 		c.top()[expr.To.Name] = LLVMValue{Value: val}
 
 		return val
 	case MutDeclaration:
-		val := codegenExpression(c, expr.Value, b)
+		val := codegenExpression(c, expr.Value)
 
-		alloca := b.NewAlloca(val.Type())
-		b.NewStore(val, alloca)
+		// variables closures capture are shared with them, so they have to
+		// outlive the function declaring them
+		var storage value.Value
+		if expr.Captured {
+			storage = c.malloc(val.Type())
+		} else {
+			storage = c.alloca(val.Type())
+		}
+		c.block.NewStore(val, storage)
 
-		c.top()[expr.To.Name] = LLVMMutableValue{Value: alloca}
+		c.top()[expr.To.Name] = LLVMMutableValue{Value: storage}
 
 		return val
 	case Assignment:
-		val := codegenExpression(c, expr.Value, b)
-		to, ok := c.lookup(expr.To).(LLVMMutableValue)
-		if !ok {
-			panic(NewUError("%s: %s is not mutable", expr.Pos, expr.To))
-		}
-
-		valType := val.Type()
-		elmType := to.Type().(*types.PointerType).ElemType
-		if !val.Type().Equal(elmType) {
-			if ptr, ok := valType.(*types.PointerType); ok {
-				valType = ptr.ElemType
-			}
-			if ptr, ok := elmType.(*types.PointerType); ok {
-				elmType = ptr.ElemType
-			}
-			panic(NewUError("%s: tried to assign something of type '%s' to type '%s'", expr.Pos, valType.Name(), elmType.Name()))
-		}
-		b.NewStore(val, to.Value)
+		val := codegenExpression(c, expr.Value)
+		to := c.lookup(expr.To).(LLVMMutableValue)
+		c.block.NewStore(val, to.Value)
 
 		return val
 	case FieldAssignment:
-		val := codegenExpression(c, expr.Value, b)
-
-		of := codegenExpression(c, expr.Struct, b)
-		ptr, ok := of.Type().(*types.PointerType)
-		strType, strOk := ptr.ElemType.(*types.StructType)
-
-		if !ok || !strOk {
-			panic(NewUError("%s: tried to assign to a field of a non-struct", expr.Pos))
-		}
-
-		field, err := c.lookupField(strType, string(expr.Field.Name))
-		if err != nil {
-			panic(NewUError("%s: struct type '%s' does not have field '%s'", expr.Pos, strType.Name(), expr.Field))
-		}
+		val := codegenExpression(c, expr.Value)
+		ptr := codegenAddress(c, Field{Of: expr.Struct, Ident: expr.Field})
+		c.block.NewStore(val, ptr)
 
-		if !strType.Equal(val.Type()) {
-			panic(NewUError("%s: field '%s' has type '%s', not type '%s'", expr.Pos, expr.Field, strType.Fields[field].Name(), val.Type().Name()))
-		}
+		return val
+	case Index:
+		ptr := codegenElement(c, expr)
+		return c.block.NewLoad(ptr.Type().(*types.PointerType).ElemType, ptr)
+	case IndexAssignment:
+		val := codegenExpression(c, expr.Value)
+		c.block.NewStore(val, codegenElement(c, Index{expr.Of, expr.Index, expr.Pos}))
 
-		eep := b.NewGetElementPtr(strType, of, constant.NewInt(types.I32, int64(0)), constant.NewInt(types.I32, int64(field)))
+		return val
+	case Subslice:
+		return codegenSubslice(c, expr)
+	case DerefAssignment:
+		ptr := codegenExpression(c, expr.Of)
+		val := codegenExpression(c, expr.Value)
+		c.block.NewStore(val, ptr)
 
-		b.NewStore(val, eep)
 		return val
+	case New:
+		return codegenNew(c, expr)
+	case Match:
+		return codegenMatch(c, expr)
 	case If:
-		condVal := codegenExpression(c, expr.Condition, b)
+		condVal := codegenExpression(c, expr.Condition)
+
+		thenBloc := c.newBlock("then")
+		elseBloc := c.newBlock("else")
+		mergeBloc := c.newBlock("ifcont")
+
+		condCmp := c.block.NewICmp(enum.IPredNE, condVal, constant.False)
+		c.block.NewCondBr(condCmp, thenBloc, elseBloc)
+
+		// the branches may introduce blocks of their own, so the phi has to
+		// come from whichever block each branch finished in
+		c.block = thenBloc
+		thenValue := codegenExpression(c, expr.Then)
+		thenEnd := c.block
+		thenEnd.NewBr(mergeBloc)
+
+		c.block = elseBloc
+		elseValue := codegenExpression(c, expr.Else)
+		elseEnd := c.block
+		elseEnd.NewBr(mergeBloc)
+
+		c.block = mergeBloc
+		if thenValue == nil || elseValue == nil || types.IsVoid(thenValue.Type()) || !thenValue.Type().Equal(elseValue.Type()) {
+			return nil
+		}
 
-		fn := b.Parent
-		thenBloc := fn.NewBlock("then")
-		thenValue := codegenExpression(c, expr.Then, thenBloc)
+		return mergeBloc.NewPhi(ir.NewIncoming(thenValue, thenEnd), ir.NewIncoming(elseValue, elseEnd))
+	case Binary:
+		return codegenBinary(c, expr)
+	case Unary:
+		return codegenUnary(c, expr)
+	case While:
+		headerBloc := c.newBlock("while.cond")
+		bodyBloc := c.newBlock("while.body")
+		exitBloc := c.newBlock("while.exit")
+
+		c.block.NewBr(headerBloc)
+
+		c.block = headerBloc
+		condVal := codegenExpression(c, expr.Condition)
+		c.block.NewCondBr(condVal, bodyBloc, exitBloc)
+
+		c.block = bodyBloc
+		c.loops = append(c.loops, loopTargets{continueTo: headerBloc, breakTo: exitBloc})
+		codegenExpression(c, expr.Body)
+		c.loops = c.loops[:len(c.loops)-1]
+		c.block.NewBr(headerBloc)
+
+		c.block = exitBloc
+		return nil
+	case For:
+		from := codegenExpression(c, expr.From)
+		to := codegenExpression(c, expr.To)
+
+		kind := from.Type().(*types.IntType)
+		counter := c.alloca(kind)
+		c.block.NewStore(from, counter)
+
+		headerBloc := c.newBlock("for.cond")
+		bodyBloc := c.newBlock("for.body")
+		nextBloc := c.newBlock("for.next")
+		exitBloc := c.newBlock("for.exit")
+
+		c.block.NewBr(headerBloc)
+
+		c.block = headerBloc
+		current := c.block.NewLoad(kind, counter)
+		pred := enum.IPredSLT
+		if isUnsigned(typeOf(expr.From)) {
+			pred = enum.IPredULT
+		}
+		c.block.NewCondBr(c.block.NewICmp(pred, current, to), bodyBloc, exitBloc)
 
-		elseBloc := fn.NewBlock("else")
-		elseValue := codegenExpression(c, expr.Else, elseBloc)
+		c.block = bodyBloc
+		c.pushScope()
+		c.top()[expr.Ident.Name] = LLVMValue{Value: current}
+		c.loops = append(c.loops, loopTargets{continueTo: nextBloc, breakTo: exitBloc})
+		codegenExpression(c, expr.Body)
+		c.loops = c.loops[:len(c.loops)-1]
+		c.popScope()
+		c.block.NewBr(nextBloc)
 
-		mergeBloc := fn.NewBlock("ifcont")
-		phi := mergeBloc.NewPhi(ir.NewIncoming(thenValue, thenBloc), ir.NewIncoming(elseValue, elseBloc))
+		c.block = nextBloc
+		c.block.NewStore(c.block.NewAdd(current, constant.NewInt(kind, 1)), counter)
+		c.block.NewBr(headerBloc)
 
-		// time to add the conditional now that we built the blocks
-		condCmp := b.NewICmp(enum.IPredNE, condVal, constant.False)
-		b.NewCondBr(condCmp, thenBloc, elseBloc)
+		c.block = exitBloc
+		return nil
+	case Break:
+		c.jump(c.loops[len(c.loops)-1].breakTo)
 
-		// now we chain the branches to the merge block
-		thenBloc.NewBr(mergeBloc)
-		elseBloc.NewBr(mergeBloc)
+		return nil
+	case Continue:
+		c.jump(c.loops[len(c.loops)-1].continueTo)
 
-		return phi
+		return nil
 	case Field:
-		of := codegenExpression(c, expr.Of, b)
-		ptr, ok := of.Type().(*types.PointerType)
-		strType, strOk := ptr.ElemType.(*types.StructType)
-
-		if !ok || !strOk {
-			panic("tried to get a field of a non-struct")
-		}
-
-		field, err := c.lookupField(strType, string(expr.Ident.Name))
-		if err != nil {
-			panic(NewUError("struct type '%s' does not have field '%s'", strType.Name(), expr.Ident))
-		}
-
-		return b.NewGetElementPtr(strType, of, constant.NewInt(types.I32, int64(0)), constant.NewInt(types.I32, int64(field)))
+		of := codegenExpression(c, expr.Of)
+		return c.block.NewExtractValue(of, uint64(fieldIndex(typeOf(expr.Of), expr.Ident.Name)))
 	default:
 		panic("unhandled")
 	}
@@ -331,17 +543,7 @@ func codegenType(c *ctx, t Type) types.Type {
 	case Ident:
 		return c.lookup(Identifier(kind)).(LLVMType).Type
 	case FunctionPointer:
-		var ret types.Type = types.Void
-		if kind.Returns != nil {
-			ret = codegenType(c, *kind.Returns)
-		}
-
-		var args []types.Type
-		for _, kind := range kind.Arguments {
-			args = append(args, codegenType(c, kind))
-		}
-
-		return types.NewFunc(ret, args...)
+		return closureType(codegenSignature(c, kind))
 	case Struct:
 		var args []types.Type
 		for _, kind := range kind {
@@ -349,12 +551,29 @@ func codegenType(c *ctx, t Type) types.Type {
 		}
 
 		return types.NewStruct(args...)
+	case Interface:
+		return codegenInterface(c, kind)
+	case Array:
+		return types.NewArray(uint64(kind.Length), codegenType(c, kind.Elem))
+	case Slice:
+		return codegenSliceType(codegenType(c, kind.Elem))
+	case Pointer:
+		return types.NewPointer(codegenType(c, kind.Elem))
 	default:
 		panic("unhandled")
 	}
 
 }
 
+// symbol returns the symbol something the package declares is compiled to.
+// only libraries mangle them, since nothing links against programs.
+func (c *ctx) symbol(name string) string {
+	if !c.sets.isLibrary {
+		return name
+	}
+	return mangle(c.sets.packageName, name)
+}
+
 func firstRune(str string) (r rune) {
 	for _, r = range str {
 		return
@@ -370,72 +589,194 @@ func codegenToplevel(c *ctx, t TopLevel, m *ir.Module) {
 			ret = codegenType(c, *tl.Returns)
 		}
 
+		// methods are functions named after their type, taking the
+		// receiver before the other arguments
+		name := funcName(tl)
 		if c.forwardDeclarationPass {
 			var params []*ir.Param
+			if tl.Receiver != nil {
+				params = append(params, ir.NewParam(tl.Receiver.Ident.Name, codegenType(c, Ident(tl.Receiver.Kind))))
+			}
 			for _, param := range tl.Arguments {
 				params = append(params, ir.NewParam(string(param.Ident.Name), codegenType(c, param.Kind)))
 			}
 
-			fn := m.NewFunc(c.publicSymbolPrefix+string(tl.Ident.Name), ret, params...)
+			fn := m.NewFunc(c.symbol(name), ret, params...)
 			fn.Visibility = enum.VisibilityHidden
-			if unicode.IsUpper(firstRune(tl.Ident.Name)) {
+			if exported(tl.Ident.Name) {
 				fn.Visibility = enum.VisibilityDefault
-				c.ti.Functions[c.publicSymbolPrefix+string(tl.Ident.Name)] = tl.String()
+				if tl.Receiver != nil {
+					c.ti.Methods[name] = tl.String()
+				} else {
+					c.ti.Functions[name] = tl.String()
+				}
 			}
-			c.top()[tl.Ident.Name] = LLVMValue{Value: fn}
+			c.top()[name] = LLVMValue{Value: fn}
 			return
 		}
 
-		fn := c.lookup(tl.Ident).(LLVMValue).Value.(*ir.Func)
-		bloc := fn.NewBlock("entry")
+		fn := c.lookup(Identifier{Name: name}).(LLVMValue).Value.(*ir.Func)
+		c.block = fn.NewBlock("entry")
 
-		if tl.Ident.Name == "main" && !c.sets.isLibrary {
+		if name == "main" && !c.sets.isLibrary {
 			c.entry = fn
 		}
 
 		c.pushScope()
+		params := fn.Params
+		if tl.Receiver != nil {
+			c.top()[tl.Receiver.Ident.Name] = LLVMValue{Value: params[0]}
+			params = params[1:]
+		}
 		for i, arg := range tl.Arguments {
-			c.top()[arg.Ident.Name] = LLVMValue{Value: fn.Params[i]}
+			c.top()[arg.Ident.Name] = LLVMValue{Value: params[i]}
 		}
-		retValue := codegenExpression(c, tl.Expr, bloc)
+		retValue := codegenExpression(c, tl.Expr)
 		c.popScope()
 
 		if types.IsVoid(ret) {
-			fn.Blocks[len(fn.Blocks)-1].NewRet(nil)
+			c.block.NewRet(nil)
 		} else {
-			fn.Blocks[len(fn.Blocks)-1].NewRet(retValue)
+			c.block.NewRet(retValue)
 		}
 	case TypeDeclaration:
-		c.top()[tl.Ident.Name] = LLVMType{Type: codegenType(c, tl.Kind)}
-		if v, ok := tl.Kind.(Struct); ok {
-			t := c.top()[tl.Ident.Name].(LLVMType)
-			t.Type.SetName(string(tl.Ident.Name))
-			m.TypeDefs = append(m.TypeDefs, t.Type)
-			t.fields = make(map[string]int)
-			for idx, field := range v {
-				t.fields[field.Ident] = idx
-			}
-			c.top()[tl.Ident.Name] = t
+		c.ti.Types = append(c.ti.Types, typeDecl{tl.Ident.Name, typeToString(&tl.Kind)})
+		if _, ok := tl.Kind.(Sum); ok {
+			// laid out by defineSum
+			return
 		}
+		if isNominal(tl.Kind) {
+			t := c.top()[tl.Ident.Name].(LLVMType).Type.(*types.StructType)
+			t.Fields = codegenType(c, tl.Kind).(*types.StructType).Fields
+			return
+		}
+		c.top()[tl.Ident.Name] = LLVMType{Type: codegenType(c, tl.Kind)}
 	case Import:
-		// not dealing with this
+		// the libraries imports refer to are declared by declareLibrary
+		if !containsString(c.ti.Imports, tl.Path) {
+			c.ti.Imports = append(c.ti.Imports, tl.Path)
+		}
 	default:
 		panic("unhandled")
 	}
 }
 
+// declareLibrary declares the types, functions and methods a library exports
+// by their qualified names.
+func (c *ctx) declareLibrary(lib string, ti typeInfo) {
+	// the library's types refer to each other by the names they have in it,
+	// like the checker sees them
+	c.pushScope()
+	kinds := map[string]Type{}
+	var sums []*sumLayout
+	for _, decl := range ti.Types {
+		kinds[decl.Name] = parseKind(decl.Kind, lib)
+		if sum, ok := kinds[decl.Name].(Sum); ok {
+			layout := c.declareSum(c.module, qualifiedName(ti.Package, decl.Name), sum)
+			c.names[0][layout.Type.TypeName] = LLVMType{Type: layout.Type}
+			c.top()[decl.Name] = LLVMType{Type: layout.Type}
+			for tag, variant := range sum {
+				c.names[0][qualifiedName(ti.Package, variant.Ident.Name)] = LLVMVariant{Layout: layout, Tag: tag}
+			}
+			sums = append(sums, layout)
+		} else if isNominal(kinds[decl.Name]) {
+			strct := &types.StructType{TypeName: qualifiedName(ti.Package, decl.Name)}
+			c.module.TypeDefs = append(c.module.TypeDefs, strct)
+			c.names[0][strct.TypeName] = LLVMType{Type: strct}
+			c.top()[decl.Name] = LLVMType{Type: strct}
+		}
+	}
+	for _, decl := range ti.Types {
+		if !isNominal(kinds[decl.Name]) {
+			t := LLVMType{Type: codegenType(c, kinds[decl.Name])}
+			c.names[0][qualifiedName(ti.Package, decl.Name)] = t
+			c.top()[decl.Name] = t
+		}
+	}
+	for _, decl := range ti.Types {
+		if _, ok := kinds[decl.Name].(Sum); !ok && isNominal(kinds[decl.Name]) {
+			strct := c.top()[decl.Name].(LLVMType).Type.(*types.StructType)
+			strct.Fields = codegenType(c, kinds[decl.Name]).(*types.StructType).Fields
+		}
+	}
+	// sums are laid out once the structs they carry have fields
+	for _, layout := range sums {
+		if len(layout.Type.Fields) == 0 {
+			c.defineSum(layout)
+		}
+	}
+
+	declare := func(name, kind string, receiver types.Type) {
+		fnType := codegenSignature(c, parseKind(kind, lib).(FunctionPointer))
+		params := []*ir.Param{}
+		if receiver != nil {
+			params = append(params, ir.NewParam("", receiver))
+		}
+		for _, param := range fnType.Params {
+			params = append(params, ir.NewParam("", param))
+		}
+		fn := c.module.NewFunc(mangle(ti.Package, name), fnType.RetType, params...)
+		c.names[0][qualifiedName(ti.Package, name)] = LLVMValue{Value: fn}
+	}
+	for name, kind := range ti.Functions {
+		declare(name, kind, nil)
+	}
+	for name, kind := range ti.Methods {
+		recv := c.top()[name[:strings.LastIndex(name, ".")]].(LLVMType)
+		declare(name, kind, recv.Type)
+	}
+	c.popScope()
+}
+
 type settings struct {
 	packageName     string
 	isLibrary       bool
 	forceimportlibs []string
+
+	// dir is the directory of the package, which relative imports are
+	// resolved against, and importPaths the directories other packages are
+	// looked for in
+	dir         string
+	importPaths []string
+
+	// memory is how heap memory is managed, memoryManual or memoryGC
+	memory string
+}
+
+// instances replaces generic functions and types with the instances the
+// checker made of them, which are generated like any other.
+func instances(tls []TopLevel) []TopLevel {
+	var out []TopLevel
+	for _, tl := range tls {
+		switch decl := tl.(type) {
+		case Func:
+			if decl.TypeParams != nil {
+				for _, inst := range decl.Instances {
+					out = append(out, inst)
+				}
+				continue
+			}
+		case TypeDeclaration:
+			if decl.TypeParams != nil {
+				for _, inst := range decl.Instances {
+					out = append(out, inst)
+				}
+				continue
+			}
+		}
+		out = append(out, tl)
+	}
+	return out
 }
 
-func codegen(tls []TopLevel, sets settings) *ir.Module {
+// codegen lowers a checked package to LLVM IR. problems that only show up
+// while generating code are reported to diags, in which case nil is returned.
+func codegen(tls []TopLevel, sets settings, diags *Diagnostics) (modu *ir.Module) {
 	defer func() {
 		if v := recover(); v != nil {
 			if uerror, ok := v.(uerror); ok {
-				println(uerror.UError())
-				os.Exit(1)
+				diags.Errorf(ErrCodegen, Span{}, "%s", uerror.UError())
+				modu = nil
 			} else {
 				panic(v)
 			}
@@ -457,6 +798,7 @@ func codegen(tls []TopLevel, sets settings) *ir.Module {
 				"bool":     Boolean,
 				"niets":    Niets,
 				"byte":     Byte,
+				"rune":     Int32,
 
 				"string":      StringPointer,
 				"string_impl": String,
@@ -464,19 +806,27 @@ func codegen(tls []TopLevel, sets settings) *ir.Module {
 				"true":  True,
 				"false": False,
 				"nil":   Nil,
+
+				"len":  LLVMBuiltin{Name: "len"},
+				"free": LLVMBuiltin{Name: "free"},
 			},
 		},
 		stringConstants: map[string]value.Value{},
+		sums:            map[string]*sumLayout{},
+		vtables:         map[string]*ir.Global{},
+		thunks:          map[string]*ir.Func{},
 		sets:            sets,
 		ti: typeInfo{
+			Version:   typeInfoVersion,
+			Package:   sets.packageName,
 			Functions: map[string]string{},
+			Methods:   map[string]string{},
+			Memory:    memoryManagement(sets.memory),
 		},
 	}
-	if sets.isLibrary {
-		c.publicSymbolPrefix = sets.packageName + "/"
-	}
-
-	modu := ir.NewModule()
+	modu = ir.NewModule()
+	c.module = modu
+	tls = instances(tls)
 
 	keys := []string{
 		"int8",
@@ -507,26 +857,52 @@ func codegen(tls []TopLevel, sets settings) *ir.Module {
 		}
 	}
 
+	switch {
+	case sets.memory == memoryGC && sets.isLibrary:
+		c.mallocFn = declareCollector(modu)
+	case sets.memory == memoryGC:
+		c.gc = addCollector(modu)
+		c.mallocFn = c.gc.alloc
+	}
+
 	names := addBuiltins(modu)
 	for name, value := range names {
 		c.names[0][name] = LLVMValue{Value: value}
 	}
 
-	for _, lib := range sets.forceimportlibs {
+	libs := append([]string{}, sets.forceimportlibs...)
+	for _, lib := range importedLibraries(tls) {
+		if !containsString(libs, lib) {
+			libs = append(libs, lib)
+		}
+	}
+	for _, lib := range libs {
 		ti, err := getTypeInfoFromFile(lib)
 		if err != nil {
-			log.Fatalf("error with type info: %s", err)
+			diags.Errorf(ErrImport, Span{}, "error with type info for %s: %s", lib, err)
+			return nil
+		}
+		if memory := memoryManagement(ti.Memory); memory != c.ti.Memory {
+			diags.Errorf(ErrImport, Span{}, "%s was built with --memory=%s, but this package is built with --memory=%s", lib, memory, c.ti.Memory)
+			return nil
 		}
+		c.declareLibrary(lib, ti)
+	}
 
-		for name, kind := range ti.Functions {
-			p := NewParser(NewLexer(strings.NewReader(kind), lib))
-			t := p.parseType()
-			fnType := codegenType(c, t).(*types.FuncType)
-			params := []*ir.Param{}
-			for _, param := range fnType.Params {
-				params = append(params, ir.NewParam("", param))
+	// struct, sum and interface types are declared before anything else, so
+	// that they can refer to each other regardless of the order they're
+	// declared in
+	var sums []*sumLayout
+	for _, tl := range tls {
+		if decl, ok := tl.(TypeDeclaration); ok {
+			switch kind := decl.Kind.(type) {
+			case Struct, Interface:
+				strct := &types.StructType{TypeName: decl.Ident.Name}
+				modu.TypeDefs = append(modu.TypeDefs, strct)
+				c.top()[decl.Ident.Name] = LLVMType{Type: strct}
+			case Sum:
+				sums = append(sums, c.declareSum(modu, decl.Ident.Name, kind))
 			}
-			c.names[0][name] = LLVMValue{Value: modu.NewFunc(name, fnType.RetType, params...)}
 		}
 	}
 
@@ -534,6 +910,12 @@ func codegen(tls []TopLevel, sets settings) *ir.Module {
 	for _, tl := range tls {
 		codegenToplevel(c, tl, modu)
 	}
+	// sums are laid out once the structs they carry have fields
+	for _, layout := range sums {
+		if len(layout.Type.Fields) == 0 {
+			c.defineSum(layout)
+		}
+	}
 	c.forwardDeclarationPass = false
 	for _, tl := range tls {
 		if _, ok := tl.(Func); ok {
@@ -546,6 +928,9 @@ func codegen(tls []TopLevel, sets settings) *ir.Module {
 		opening := modu.NewFunc("_tawa_main", types.Void)
 		bloc := opening.NewBlock("_entry")
 
+		if c.gc != nil {
+			bloc.NewStore(bloc.NewAlloca(types.I8), c.gc.stackBottom)
+		}
 		bloc.NewCall(c.entry)
 		bloc.NewCall(ir.NewInlineAsm(types.NewPointer(types.NewFunc(types.Void)), `movq $$0x3C, %rax; movq $$0x0, %rbx; syscall`, ``))
 		bloc.NewRet(nil)
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// codegenSliceType returns the type of slices of elem, which are laid out like
+// strings: the length, then a pointer to the first element.
+func codegenSliceType(elem types.Type) *types.StructType {
+	return types.NewStruct(Int64.Type, types.NewPointer(elem))
+}
+
+// makeSlice creates a slice of the length values starting at data.
+func (c *ctx) makeSlice(data, length value.Value) value.Value {
+	var slice value.Value = constant.NewUndef(codegenSliceType(data.Type().(*types.PointerType).ElemType))
+	slice = c.block.NewInsertValue(slice, length, 0)
+	return c.block.NewInsertValue(slice, data, 1)
+}
+
+// codegenArrayLiteral creates an array or slice. the elements of slices are
+// stored on the heap, so that they can outlive the function creating them.
+func codegenArrayLiteral(c *ctx, lit ArrayLiteral) value.Value {
+	t := codegenType(c, lit.Kind)
+	if array, ok := t.(*types.ArrayType); ok {
+		var val value.Value = constant.NewZeroInitializer(array)
+		for idx, elem := range lit.Elements {
+			val = c.block.NewInsertValue(val, codegenExpression(c, elem), uint64(idx))
+		}
+		return val
+	}
+
+	elem := t.(*types.StructType).Fields[1].(*types.PointerType).ElemType
+	length := constant.NewInt(types.I64, int64(len(lit.Elements)))
+	if len(lit.Elements) == 0 {
+		return c.makeSlice(constant.NewNull(types.NewPointer(elem)), length)
+	}
+
+	array := types.NewArray(uint64(len(lit.Elements)), elem)
+	mem := c.malloc(array)
+	for idx, e := range lit.Elements {
+		c.block.NewStore(codegenExpression(c, e), getStructElm(c.block, array, mem, int64(idx)))
+	}
+	return c.makeSlice(getStructElm(c.block, array, mem, 0), length)
+}
+
+// addressable reports whether e is stored somewhere that codegenAddress can
+// point to.
+func (c *ctx) addressable(e Expression) bool {
+	switch expr := e.(type) {
+	case Typed:
+		return c.addressable(expr.Expr)
+	case Var:
+		_, ok := c.lookup(Identifier(expr)).(LLVMMutableValue)
+		return ok
+	case Field:
+		return c.addressable(expr.Of)
+	case Index:
+		switch underlying(typeOf(expr.Of)).(type) {
+		case *arrayType:
+			return c.addressable(expr.Of)
+		case *sliceType:
+			return true
+		}
+	case Unary:
+		return expr.Op == STAR
+	}
+
+	return false
+}
+
+// arrayPointer returns a pointer to an array. arrays that aren't stored in a
+// variable are copied into the frame first, or onto the heap if the pointer
+// has to outlive the function.
+func (c *ctx) arrayPointer(e Expression, heap bool) value.Value {
+	if c.addressable(e) {
+		return codegenAddress(c, e)
+	}
+
+	val := codegenExpression(c, e)
+	var ptr value.Value
+	if heap {
+		ptr = c.malloc(val.Type())
+	} else {
+		ptr = c.alloca(val.Type())
+	}
+	c.block.NewStore(val, ptr)
+	return ptr
+}
+
+// checkBounds aborts unless idx is less than length, or at most length for the
+// bounds of slices. negative indices are huge when compared unsigned, so
+// they're out of range too.
+func (c *ctx) checkBounds(idx, length value.Value, bound bool) {
+	pred := enum.IPredULT
+	if bound {
+		pred = enum.IPredULE
+	}
+
+	inRange := c.newBlock("inrange")
+	outOfRange := c.newBlock("outofrange")
+	c.block.NewCondBr(c.block.NewICmp(pred, idx, length), inRange, outOfRange)
+
+	outOfRange.NewCall(c.lookup(Identifier{Name: "tawa.outOfRange"}).(LLVMValue).Value)
+	outOfRange.NewUnreachable()
+	c.block = inRange
+}
+
+// codegenElement returns a pointer to the element of an array, slice or
+// string an index refers to, once it's checked to be in range.
+func codegenElement(c *ctx, expr Index) value.Value {
+	zero := constant.NewInt(types.I64, 0)
+
+	switch kind := underlying(typeOf(expr.Of)).(type) {
+	case *arrayType:
+		ptr := c.arrayPointer(expr.Of, false)
+		idx := codegenConversion(c, expr.Index, Int64.Type, typeInt64)
+		c.checkBounds(idx, constant.NewInt(types.I64, kind.length), false)
+		return c.block.NewGetElementPtr(ptr.Type().(*types.PointerType).ElemType, ptr, zero, idx)
+	case *sliceType:
+		slice := codegenExpression(c, expr.Of)
+		idx := codegenConversion(c, expr.Index, Int64.Type, typeInt64)
+		c.checkBounds(idx, c.block.NewExtractValue(slice, 0), false)
+		data := c.block.NewExtractValue(slice, 1)
+		return c.block.NewGetElementPtr(data.Type().(*types.PointerType).ElemType, data, idx)
+	}
+
+	str := codegenExpression(c, expr.Of)
+	idx := codegenConversion(c, expr.Index, Int64.Type, typeInt64)
+	c.checkBounds(idx, c.block.NewLoad(Int64.Type, getStructElm(c.block, String.Type, str, 0)), false)
+	data := c.block.NewLoad(types.NewPointer(Byte.Type), getStructElm(c.block, String.Type, str, 1))
+	return c.block.NewGetElementPtr(Byte.Type, data, idx)
+}
+
+// codegenSubslice slices an array or slice. slices of arrays that aren't
+// stored in a variable point to a copy of them.
+func codegenSubslice(c *ctx, expr Subslice) value.Value {
+	var data, length value.Value
+	switch kind := underlying(typeOf(expr.Of)).(type) {
+	case *arrayType:
+		ptr := c.arrayPointer(expr.Of, true)
+		zero := constant.NewInt(types.I64, 0)
+		data = c.block.NewGetElementPtr(ptr.Type().(*types.PointerType).ElemType, ptr, zero, zero)
+		length = constant.NewInt(types.I64, kind.length)
+	case *sliceType:
+		slice := codegenExpression(c, expr.Of)
+		data, length = c.block.NewExtractValue(slice, 1), c.block.NewExtractValue(slice, 0)
+	}
+
+	var low, high value.Value = constant.NewInt(types.I64, 0), length
+	if expr.Low != nil {
+		low = codegenConversion(c, expr.Low, Int64.Type, typeInt64)
+	}
+	if expr.High != nil {
+		high = codegenConversion(c, expr.High, Int64.Type, typeInt64)
+	}
+	c.checkBounds(high, length, true)
+	c.checkBounds(low, high, true)
+
+	elem := data.Type().(*types.PointerType).ElemType
+	return c.makeSlice(c.block.NewGetElementPtr(elem, data, low), c.block.NewSub(high, low))
+}
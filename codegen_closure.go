@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// codegenSignature returns the type of a function that isn't a closure, like
+// the functions libraries export.
+func codegenSignature(c *ctx, f FunctionPointer) *types.FuncType {
+	var ret types.Type = types.Void
+	if f.Returns != nil {
+		ret = codegenType(c, *f.Returns)
+	}
+
+	var args []types.Type
+	for _, kind := range f.Arguments {
+		args = append(args, codegenType(c, kind))
+	}
+
+	return types.NewFunc(ret, args...)
+}
+
+// closureType returns the type of function values with the signature sig: a
+// pointer to a function taking the closure's environment before the other
+// arguments, and a pointer to the environment, which holds the variables the
+// closure captured. functions that aren't closures have a nil environment.
+func closureType(sig *types.FuncType) *types.StructType {
+	params := append([]types.Type{types.I8Ptr}, sig.Params...)
+	return types.NewStruct(types.NewPointer(types.NewFunc(sig.RetType, params...)), types.I8Ptr)
+}
+
+// malloc allocates memory for a value of type t on the heap.
+func (c *ctx) malloc(t types.Type) value.Value {
+	if c.mallocFn == nil {
+		c.mallocFn = c.module.NewFunc("mi_malloc", types.I8Ptr, ir.NewParam("size", types.I64))
+	}
+
+	size, _ := c.sizeOf(t)
+	mem := c.block.NewCall(c.mallocFn, constant.NewInt(types.I64, size))
+	return c.block.NewBitCast(mem, types.NewPointer(t))
+}
+
+// funcValue turns a function into a function value, calling it through a
+// function that ignores the environment.
+func (c *ctx) funcValue(fn *ir.Func) value.Value {
+	name := fn.Name() + ".closure"
+	thunk, ok := c.thunks[name]
+	if !ok {
+		params := []*ir.Param{ir.NewParam("env", types.I8Ptr)}
+		for _, param := range fn.Params {
+			params = append(params, ir.NewParam(param.LocalName, param.Typ))
+		}
+
+		thunk = c.module.NewFunc(name, fn.Sig.RetType, params...)
+		thunk.Linkage = enum.LinkageInternal
+		entry := thunk.NewBlock("entry")
+
+		var args []value.Value
+		for _, param := range thunk.Params[1:] {
+			args = append(args, param)
+		}
+		ret := entry.NewCall(fn, args...)
+		if types.IsVoid(fn.Sig.RetType) {
+			entry.NewRet(nil)
+		} else {
+			entry.NewRet(ret)
+		}
+
+		c.thunks[name] = thunk
+	}
+
+	return constant.NewStruct(closureType(fn.Sig), thunk, constant.NewNull(types.I8Ptr))
+}
+
+// codegenClosureCall calls a function value.
+func codegenClosureCall(c *ctx, closure value.Value, args []value.Value) value.Value {
+	fn := c.block.NewExtractValue(closure, 0)
+	env := c.block.NewExtractValue(closure, 1)
+	return c.block.NewCall(fn, append([]value.Value{env}, args...)...)
+}
+
+// codegenLambda generates the function of a lambda, and creates a closure of
+// it. variables captured by value are copied into the environment, while the
+// ones captured by reference are already on the heap and shared with it.
+func codegenLambda(c *ctx, expr Lambda) value.Value {
+	var captured []value.Value
+	var fields []types.Type
+	for _, capture := range expr.Captures {
+		var val value.Value
+		switch v := c.lookup(capture.Ident).(type) {
+		case LLVMValue:
+			val = v.Value
+		case LLVMMutableValue:
+			val = v.Value
+		}
+		captured = append(captured, val)
+		fields = append(fields, val.Type())
+	}
+	env := types.NewStruct(fields...)
+
+	var ret types.Type = types.Void
+	if expr.Returns != nil {
+		ret = codegenType(c, *expr.Returns)
+	}
+	params := []*ir.Param{ir.NewParam("env", types.I8Ptr)}
+	for _, arg := range expr.Arguments {
+		params = append(params, ir.NewParam(arg.Ident.Name, codegenType(c, arg.Kind)))
+	}
+
+	c.lambdaCount++
+	fn := c.module.NewFunc(fmt.Sprintf("%s.lambda.%d", c.block.Parent.Name(), c.lambdaCount), ret, params...)
+	fn.Linkage = enum.LinkageInternal
+
+	var envPtr value.Value = constant.NewNull(types.I8Ptr)
+	if len(captured) != 0 {
+		mem := c.malloc(env)
+		for idx, val := range captured {
+			c.block.NewStore(val, getStructElm(c.block, env, mem, int64(idx)))
+		}
+		envPtr = c.block.NewBitCast(mem, types.I8Ptr)
+	}
+
+	// the body only sees the package and what it captured
+	names, block, loops := c.names, c.block, c.loops
+	c.names = []map[string]namedThing{c.names[0], {}}
+	c.block = fn.NewBlock("entry")
+	c.loops = nil
+
+	if len(captured) != 0 {
+		of := c.block.NewBitCast(fn.Params[0], types.NewPointer(env))
+		for idx, capture := range expr.Captures {
+			val := c.block.NewLoad(fields[idx], getStructElm(c.block, env, of, int64(idx)))
+			if capture.ByReference {
+				c.top()[capture.Ident.Name] = LLVMMutableValue{Value: val}
+			} else {
+				c.top()[capture.Ident.Name] = LLVMValue{Value: val}
+			}
+		}
+	}
+	for idx, arg := range expr.Arguments {
+		c.top()[arg.Ident.Name] = LLVMValue{Value: fn.Params[idx+1]}
+	}
+
+	retValue := codegenExpression(c, expr.Expr)
+	if types.IsVoid(ret) {
+		c.block.NewRet(nil)
+	} else {
+		c.block.NewRet(retValue)
+	}
+	c.names, c.block, c.loops = names, block, loops
+
+	var closure value.Value = constant.NewUndef(types.NewStruct(fn.Type(), types.I8Ptr))
+	closure = c.block.NewInsertValue(closure, fn, 0)
+	return c.block.NewInsertValue(closure, envPtr, 1)
+}
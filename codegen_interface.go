@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// codegenInterface returns the type of values of an interface type: a pointer
+// to the boxed value, and a pointer to the vtable of the boxed value's type.
+// the vtable has a function for each method, which takes the boxed value
+// instead of the receiver.
+func codegenInterface(c *ctx, iface Interface) types.Type {
+	var methods []types.Type
+	for _, method := range iface {
+		var ret types.Type = types.Void
+		if method.Returns != nil {
+			ret = codegenType(c, *method.Returns)
+		}
+
+		params := []types.Type{types.I8Ptr}
+		for _, arg := range method.Arguments {
+			params = append(params, codegenType(c, arg.Kind))
+		}
+		methods = append(methods, types.NewPointer(types.NewFunc(ret, params...)))
+	}
+
+	return types.NewStruct(types.I8Ptr, types.NewPointer(types.NewStruct(methods...)))
+}
+
+// codegenBox converts a value to the interface type to.
+func codegenBox(c *ctx, of Expression, to tawaType) value.Value {
+	concrete := typeOf(of).(*namedType)
+	iface := to.(*namedType)
+	ifaceType := c.lookup(Identifier{Name: iface.name}).(LLVMType).Type.(*types.StructType)
+
+	// boxed values are on the heap, since interface values can outlive the
+	// function boxing them
+	val := codegenExpression(c, of)
+	box := c.malloc(val.Type())
+	c.block.NewStore(val, box)
+
+	var fat value.Value = constant.NewUndef(ifaceType)
+	fat = c.block.NewInsertValue(fat, c.block.NewBitCast(box, types.I8Ptr), 0)
+	return c.block.NewInsertValue(fat, c.vtable(concrete, iface, ifaceType), 1)
+}
+
+// vtable returns the vtable for boxed values of type concrete as the
+// interface iface, creating it the first time it's needed.
+func (c *ctx) vtable(concrete, iface *namedType, ifaceType *types.StructType) value.Value {
+	name := c.symbol(concrete.name + "." + iface.name + ".vtable")
+	if vtable, ok := c.vtables[name]; ok {
+		return vtable
+	}
+
+	vtableType := ifaceType.Fields[1].(*types.PointerType).ElemType.(*types.StructType)
+	var entries []constant.Constant
+	for idx, method := range underlying(iface).(*interfaceType).methods {
+		var entry constant.Constant = c.thunk(concrete, method.Name)
+		if !entry.Type().Equal(vtableType.Fields[idx]) {
+			entry = constant.NewBitCast(entry, vtableType.Fields[idx])
+		}
+		entries = append(entries, entry)
+	}
+
+	vtable := c.module.NewGlobalDef(name, constant.NewStruct(vtableType, entries...))
+	vtable.Immutable = true
+	vtable.Linkage = enum.LinkageInternal
+	c.vtables[name] = vtable
+	return vtable
+}
+
+// thunk returns a function calling a method of concrete with the receiver
+// unboxed, which is what vtables point to.
+func (c *ctx) thunk(concrete *namedType, method string) *ir.Func {
+	name := concrete.name + "." + method
+	if thunk, ok := c.thunks[name]; ok {
+		return thunk
+	}
+
+	target := c.lookup(Identifier{Name: name}).(LLVMValue).Value.(*ir.Func)
+	params := []*ir.Param{ir.NewParam("boxed", types.I8Ptr)}
+	for _, param := range target.Params[1:] {
+		params = append(params, ir.NewParam(param.Name(), param.Typ))
+	}
+
+	thunk := c.module.NewFunc(c.symbol(name+".boxed"), target.Sig.RetType, params...)
+	thunk.Linkage = enum.LinkageInternal
+	entry := thunk.NewBlock("entry")
+
+	recvType := target.Params[0].Typ
+	args := []value.Value{entry.NewLoad(recvType, entry.NewBitCast(thunk.Params[0], types.NewPointer(recvType)))}
+	for _, param := range thunk.Params[1:] {
+		args = append(args, param)
+	}
+	ret := entry.NewCall(target, args...)
+	if types.IsVoid(target.Sig.RetType) {
+		entry.NewRet(nil)
+	} else {
+		entry.NewRet(ret)
+	}
+
+	c.thunks[name] = thunk
+	return thunk
+}
+
+// codegenInterfaceCall calls a method of a value of an interface type through
+// its vtable.
+func codegenInterfaceCall(c *ctx, expr MethodCall, iface *interfaceType) value.Value {
+	idx, _ := iface.method(expr.Method.Name)
+	fat := codegenExpression(c, expr.Of)
+	vtable := c.block.NewExtractValue(fat, 1)
+	vtableType := vtable.Type().(*types.PointerType).ElemType
+	fnType := vtableType.(*types.StructType).Fields[idx]
+	fn := c.block.NewLoad(fnType, getStructElm(c.block, vtableType, vtable, int64(idx)))
+
+	args := []value.Value{c.block.NewExtractValue(fat, 0)}
+	for _, arg := range expr.Arguments {
+		args = append(args, codegenExpression(c, arg))
+	}
+	return c.block.NewCall(fn, args...)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+var (
+	signedPredicates = map[TokenKind]enum.IPred{
+		DOUBLEEQUALS:  enum.IPredEQ,
+		NOTEQUALS:     enum.IPredNE,
+		LESS:          enum.IPredSLT,
+		LESSEQUALS:    enum.IPredSLE,
+		GREATER:       enum.IPredSGT,
+		GREATEREQUALS: enum.IPredSGE,
+	}
+	unsignedPredicates = map[TokenKind]enum.IPred{
+		DOUBLEEQUALS:  enum.IPredEQ,
+		NOTEQUALS:     enum.IPredNE,
+		LESS:          enum.IPredULT,
+		LESSEQUALS:    enum.IPredULE,
+		GREATER:       enum.IPredUGT,
+		GREATEREQUALS: enum.IPredUGE,
+	}
+	floatPredicates = map[TokenKind]enum.FPred{
+		DOUBLEEQUALS:  enum.FPredOEQ,
+		NOTEQUALS:     enum.FPredONE,
+		LESS:          enum.FPredOLT,
+		LESSEQUALS:    enum.FPredOLE,
+		GREATER:       enum.FPredOGT,
+		GREATEREQUALS: enum.FPredOGE,
+	}
+)
+
+// isUnsigned reports whether an integer type should use unsigned division,
+// comparison and shifts.
+func isUnsigned(t tawaType) bool {
+	b, ok := underlying(t).(*basicType)
+	return ok && b.unsigned
+}
+
+func codegenBinary(c *ctx, expr Binary) value.Value {
+	switch expr.Op {
+	case ANDAND, OROR:
+		return codegenLogical(c, expr)
+	}
+
+	lhs := codegenExpression(c, expr.Left)
+	rhs := codegenExpression(c, expr.Right)
+
+	kind := underlying(typeOf(expr.Left))
+	if _, ok := kind.(*pointerType); ok {
+		return c.block.NewICmp(signedPredicates[expr.Op], lhs, rhs)
+	}
+	switch {
+	case isKind(kind, basicBool):
+		switch expr.Op {
+		case DOUBLEEQUALS:
+			return c.block.NewICmp(enum.IPredEQ, lhs, rhs)
+		case NOTEQUALS:
+			return c.block.NewICmp(enum.IPredNE, lhs, rhs)
+		case AMPERSAND:
+			return c.block.NewAnd(lhs, rhs)
+		case PIPE:
+			return c.block.NewOr(lhs, rhs)
+		case CARET:
+			return c.block.NewXor(lhs, rhs)
+		}
+	case isKind(kind, basicInt):
+		unsigned := isUnsigned(kind)
+		if pred, ok := signedPredicates[expr.Op]; ok {
+			if unsigned {
+				pred = unsignedPredicates[expr.Op]
+			}
+			return c.block.NewICmp(pred, lhs, rhs)
+		}
+
+		switch expr.Op {
+		case PLUS:
+			return c.block.NewAdd(lhs, rhs)
+		case MINUS:
+			return c.block.NewSub(lhs, rhs)
+		case STAR:
+			return c.block.NewMul(lhs, rhs)
+		case SLASH:
+			if unsigned {
+				return c.block.NewUDiv(lhs, rhs)
+			}
+			return c.block.NewSDiv(lhs, rhs)
+		case PERCENT:
+			if unsigned {
+				return c.block.NewURem(lhs, rhs)
+			}
+			return c.block.NewSRem(lhs, rhs)
+		case AMPERSAND:
+			return c.block.NewAnd(lhs, rhs)
+		case PIPE:
+			return c.block.NewOr(lhs, rhs)
+		case CARET:
+			return c.block.NewXor(lhs, rhs)
+		case SHIFTLEFT:
+			return c.block.NewShl(lhs, rhs)
+		case SHIFTRIGHT:
+			if unsigned {
+				return c.block.NewLShr(lhs, rhs)
+			}
+			return c.block.NewAShr(lhs, rhs)
+		}
+	case isKind(kind, basicFloat):
+		if pred, ok := floatPredicates[expr.Op]; ok {
+			return c.block.NewFCmp(pred, lhs, rhs)
+		}
+
+		switch expr.Op {
+		case PLUS:
+			return c.block.NewFAdd(lhs, rhs)
+		case MINUS:
+			return c.block.NewFSub(lhs, rhs)
+		case STAR:
+			return c.block.NewFMul(lhs, rhs)
+		case SLASH:
+			return c.block.NewFDiv(lhs, rhs)
+		case PERCENT:
+			return c.block.NewFRem(lhs, rhs)
+		}
+	}
+
+	panic("unhandled")
+}
+
+// codegenLogical lowers && and ||, only evaluating the right hand side when
+// the left hand side doesn't already decide the result.
+func codegenLogical(c *ctx, expr Binary) value.Value {
+	lhs := codegenExpression(c, expr.Left)
+	lhsEnd := c.block
+
+	rhsBloc := c.newBlock("logic.rhs")
+	mergeBloc := c.newBlock("logic.cont")
+
+	// the value of the whole expression if the right hand side is skipped
+	shortCircuit := constant.False
+	if expr.Op == ANDAND {
+		lhsEnd.NewCondBr(lhs, rhsBloc, mergeBloc)
+	} else {
+		shortCircuit = constant.True
+		lhsEnd.NewCondBr(lhs, mergeBloc, rhsBloc)
+	}
+
+	c.block = rhsBloc
+	rhs := codegenExpression(c, expr.Right)
+	rhsEnd := c.block
+	rhsEnd.NewBr(mergeBloc)
+
+	c.block = mergeBloc
+	return mergeBloc.NewPhi(ir.NewIncoming(shortCircuit, lhsEnd), ir.NewIncoming(rhs, rhsEnd))
+}
+
+func codegenUnary(c *ctx, expr Unary) value.Value {
+	switch expr.Op {
+	case AMPERSAND:
+		return codegenAddress(c, expr.Of)
+	case STAR:
+		ptr := codegenExpression(c, expr.Of)
+		return c.block.NewLoad(ptr.Type().(*types.PointerType).ElemType, ptr)
+	}
+
+	of := codegenExpression(c, expr.Of)
+
+	switch expr.Op {
+	case MINUS:
+		if isKind(underlying(typeOf(expr.Of)), basicFloat) {
+			return c.block.NewFNeg(of)
+		}
+		return c.block.NewSub(constant.NewInt(of.Type().(*types.IntType), 0), of)
+	case BANG:
+		return c.block.NewXor(of, constant.True)
+	}
+
+	panic("unhandled")
+}
+
+func floatBits(t *types.FloatType) int {
+	switch t.Kind {
+	case types.FloatKindHalf:
+		return 16
+	case types.FloatKindFloat:
+		return 32
+	case types.FloatKindDouble:
+		return 64
+	}
+	return 128
+}
+
+// codegenConversion converts a number to the type to, which is an integer or
+// floating point type of any width.
+func codegenConversion(c *ctx, arg Expression, to types.Type, kind tawaType) value.Value {
+	val := codegenExpression(c, arg)
+	fromUnsigned, toUnsigned := isUnsigned(typeOf(arg)), isUnsigned(kind)
+
+	switch from := val.Type().(type) {
+	case *types.IntType:
+		switch to := to.(type) {
+		case *types.IntType:
+			switch {
+			case from.BitSize > to.BitSize:
+				return c.block.NewTrunc(val, to)
+			case from.BitSize < to.BitSize && fromUnsigned:
+				return c.block.NewZExt(val, to)
+			case from.BitSize < to.BitSize:
+				return c.block.NewSExt(val, to)
+			}
+			return val
+		case *types.FloatType:
+			if fromUnsigned {
+				return c.block.NewUIToFP(val, to)
+			}
+			return c.block.NewSIToFP(val, to)
+		}
+	case *types.FloatType:
+		switch to := to.(type) {
+		case *types.IntType:
+			if toUnsigned {
+				return c.block.NewFPToUI(val, to)
+			}
+			return c.block.NewFPToSI(val, to)
+		case *types.FloatType:
+			switch {
+			case floatBits(from) > floatBits(to):
+				return c.block.NewFPTrunc(val, to)
+			case floatBits(from) < floatBits(to):
+				return c.block.NewFPExt(val, to)
+			}
+			return val
+		}
+	}
+
+	panic("unhandled")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// free frees memory allocated on the heap.
+func (c *ctx) free(ptr value.Value) {
+	if c.freeFn == nil {
+		c.freeFn = c.module.NewFunc("mi_free", types.Void, ir.NewParam("p", types.I8Ptr))
+	}
+
+	c.block.NewCall(c.freeFn, c.block.NewBitCast(ptr, types.I8Ptr))
+}
+
+// codegenNew allocates a value on the heap, storing the value it's
+// initialized with or zeroing it.
+func codegenNew(c *ctx, expr New) value.Value {
+	t := codegenType(c, expr.Kind)
+
+	var val value.Value = constant.NewZeroInitializer(t)
+	if expr.Value != nil {
+		val = codegenExpression(c, expr.Value)
+	}
+	mem := c.malloc(t)
+	c.block.NewStore(val, mem)
+
+	return mem
+}
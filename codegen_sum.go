@@ -0,0 +1,192 @@
+package main
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// sumLayout is how values of a sum type are stored: a tag saying which
+// variant a value is, followed by enough space for the largest payload.
+type sumLayout struct {
+	Type *types.StructType
+	decl Sum
+
+	// Payloads are the types of the variants' payloads, nil for variants
+	// that don't carry one.
+	Payloads []types.Type
+}
+
+// LLVMVariant constructs values of a variant of a sum type.
+type LLVMVariant struct {
+	NamedThingImpl
+	Layout *sumLayout
+	Tag    int
+}
+
+func roundUp(n, to int64) int64 {
+	return (n + to - 1) / to * to
+}
+
+// sizeOf returns the size and alignment of t in bytes, defining the sum types
+// it contains if that hasn't happened yet.
+func (c *ctx) sizeOf(t types.Type) (size, align int64) {
+	switch t := t.(type) {
+	case *types.IntType:
+		size, align = (int64(t.BitSize)+7)/8, 1
+		for align < size && align < 16 {
+			align *= 2
+		}
+		return roundUp(size, align), align
+	case *types.FloatType:
+		switch t.Kind {
+		case types.FloatKindHalf:
+			return 2, 2
+		case types.FloatKindFloat:
+			return 4, 4
+		case types.FloatKindDouble:
+			return 8, 8
+		}
+		return 16, 16
+	case *types.PointerType:
+		return 8, 8
+	case *types.ArrayType:
+		size, align = c.sizeOf(t.ElemType)
+		return size * int64(t.Len), align
+	case *types.StructType:
+		if layout, ok := c.sums[t.TypeName]; ok && layout.Type == t && len(t.Fields) == 0 {
+			c.defineSum(layout)
+		}
+		align = 1
+		for _, field := range t.Fields {
+			fieldSize, fieldAlign := c.sizeOf(field)
+			size = roundUp(size, fieldAlign) + fieldSize
+			if fieldAlign > align {
+				align = fieldAlign
+			}
+		}
+		return roundUp(size, align), align
+	}
+
+	panic("unhandled")
+}
+
+// defineSum lays out a sum type declared with declareSum, once the types of
+// its payloads are known.
+func (c *ctx) defineSum(layout *sumLayout) {
+	var size, align int64 = 0, 1
+	for _, variant := range layout.decl {
+		var payload types.Type
+		if variant.Payload != nil {
+			payload = codegenType(c, *variant.Payload)
+
+			payloadSize, payloadAlign := c.sizeOf(payload)
+			if payloadSize > size {
+				size = payloadSize
+			}
+			if payloadAlign > align {
+				align = payloadAlign
+			}
+		}
+		layout.Payloads = append(layout.Payloads, payload)
+	}
+
+	// the payload is stored as words of the strictest alignment any payload
+	// needs, so that it's aligned whichever variant is there
+	word := types.NewInt(uint64(align * 8))
+	layout.Type.Fields = []types.Type{types.I32, types.NewArray(uint64(roundUp(size, align)/align), word)}
+}
+
+// declareSum declares a sum type and the constructors of its variants. its
+// layout is defined by defineSum after every struct type has been.
+func (c *ctx) declareSum(m *ir.Module, name string, decl Sum) *sumLayout {
+	strct := &types.StructType{TypeName: name}
+	m.TypeDefs = append(m.TypeDefs, strct)
+
+	layout := &sumLayout{Type: strct, decl: decl}
+	c.sums[name] = layout
+	c.top()[name] = LLVMType{Type: strct}
+	for tag, variant := range decl {
+		c.top()[variant.Ident.Name] = LLVMVariant{Layout: layout, Tag: tag}
+	}
+
+	return layout
+}
+
+func (c *ctx) payloadPointer(layout *sumLayout, sum value.Value, tag int) value.Value {
+	return c.block.NewBitCast(getStructElm(c.block, layout.Type, sum, 1), types.NewPointer(layout.Payloads[tag]))
+}
+
+// codegenVariant builds a value of a sum type from one of its variants and
+// the payload, which is nil for variants without one.
+func codegenVariant(c *ctx, v LLVMVariant, payload value.Value) value.Value {
+	tmp := c.alloca(v.Layout.Type)
+	c.block.NewStore(constant.NewInt(types.I32, int64(v.Tag)), getStructElm(c.block, v.Layout.Type, tmp, 0))
+	if payload != nil {
+		c.block.NewStore(payload, c.payloadPointer(v.Layout, tmp, v.Tag))
+	}
+
+	return c.block.NewLoad(v.Layout.Type, tmp)
+}
+
+// codegenMatch switches on the tag of a value of a sum type. a match without
+// a wildcard arm handles every variant, so the default case is unreachable.
+func codegenMatch(c *ctx, expr Match) value.Value {
+	sum := underlying(typeOf(expr.Of)).(*sumType)
+	of := codegenExpression(c, expr.Of)
+	layout := c.sums[of.Type().(*types.StructType).TypeName]
+
+	tmp := c.alloca(layout.Type)
+	c.block.NewStore(of, tmp)
+	tag := c.block.NewLoad(types.I32, getStructElm(c.block, layout.Type, tmp, 0))
+	start := c.block
+
+	mergeBloc := c.newBlock("match.cont")
+	var defaultBloc *ir.Block
+	var cases []*ir.Case
+
+	var values []value.Value
+	var ends []*ir.Block
+	for _, arm := range expr.Arms {
+		bloc := c.newBlock("match.arm")
+		c.block = bloc
+		c.pushScope()
+
+		if arm.Variant.Name == "_" {
+			defaultBloc = bloc
+		} else {
+			idx, _ := sum.variant(arm.Variant.Name)
+			cases = append(cases, ir.NewCase(constant.NewInt(types.I32, int64(idx)), bloc))
+
+			if arm.Binding != nil {
+				payload := c.block.NewLoad(layout.Payloads[idx], c.payloadPointer(layout, tmp, idx))
+				c.top()[arm.Binding.Name] = LLVMValue{Value: payload}
+			}
+		}
+
+		values = append(values, codegenExpression(c, arm.Body))
+		c.popScope()
+		ends = append(ends, c.block)
+		c.block.NewBr(mergeBloc)
+	}
+
+	if defaultBloc == nil {
+		defaultBloc = c.newBlock("match.unreachable")
+		defaultBloc.NewUnreachable()
+	}
+	start.NewSwitch(tag, defaultBloc, cases...)
+
+	c.block = mergeBloc
+	if len(values) == 0 {
+		return nil
+	}
+	var incoming []*ir.Incoming
+	for i, val := range values {
+		if val == nil || types.IsVoid(val.Type()) || !val.Type().Equal(values[0].Type()) {
+			return nil
+		}
+		incoming = append(incoming, ir.NewIncoming(val, ends[i]))
+	}
+	return mergeBloc.NewPhi(incoming...)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// codegenSource checks and compiles src, returning the module's IR.
+func codegenSource(t *testing.T, src string, sets settings) string {
+	t.Helper()
+
+	p := NewParser(NewLexer(strings.NewReader(src), "test"))
+	if err := p.Parse(); err != nil {
+		t.Fatalf("failed to parse %q: %s", src, err)
+	}
+
+	diags := &Diagnostics{}
+	tls := check(p.ast.Toplevels, sets, diags)
+	var modu string
+	if !diags.HasErrors() {
+		if m := codegen(tls, sets, diags); m != nil {
+			modu = m.String()
+		}
+	}
+	if diags.HasErrors() {
+		t.Fatalf("failed to compile %q: %v", src, diags.List())
+	}
+	return modu
+}
+
+// function returns the IR of the function called name.
+func function(t *testing.T, modu, name string) string {
+	t.Helper()
+
+	start := strings.Index(modu, "@"+name+"(")
+	if start == -1 {
+		t.Fatalf("no function %s in:\n%s", name, modu)
+	}
+	start = strings.LastIndex(modu[:start], "\ndefine")
+	return modu[start : start+strings.Index(modu[start:], "\n}\n")]
+}
+
+func TestCodegenBoxesOnHeap(t *testing.T) {
+	modu := codegenSource(t, "type W interface {\n    Get() int64\n}\ntype C struct {\n    n: int64\n}\nfunc (c C) Get() int64 => c.n\nfunc mk(n: int64) W => C { n: n }\nfunc main() => mk(1).Get() + mk(2).Get()\n", settings{})
+
+	mk := function(t, modu, "mk")
+	if strings.Contains(mk, "alloca") {
+		t.Errorf("mk boxes its result in its frame:\n%s", mk)
+	}
+	if !strings.Contains(mk, "@mi_malloc(") {
+		t.Errorf("mk doesn't box its result on the heap:\n%s", mk)
+	}
+}
+
+func TestCodegenSlicedArrayEscapes(t *testing.T) {
+	modu := codegenSource(t, "func mk() []int64 {\n    var a = [3]int64 { 1, 2, 3 }\n    a[0:2]\n}\nfunc main() => mk()[1]\n", settings{})
+
+	mk := function(t, modu, "mk")
+	if strings.Contains(mk, "alloca [3 x") {
+		t.Errorf("mk slices an array in its frame:\n%s", mk)
+	}
+}
+
+func TestCodegenCollectedAllocations(t *testing.T) {
+	modu := codegenSource(t, "type Node struct {\n    value: int64\n}\nfunc main() {\n    let n = new Node { value: 1 }\n    let xs = []int64 { 1, 2, 3 }\n    let k = 2\n    let f = func(x: int64) int64 => x * k\n    free(n)\n    f(n.value) + xs[0]\n}\n", settings{memory: memoryGC})
+
+	main := function(t, modu, "main")
+	if n := strings.Count(main, "@tawa.gc.alloc("); n < 3 {
+		t.Errorf("expected the node, the slice and the closure's environment to be collected, but only %d allocations are:\n%s", n, main)
+	}
+	for _, fn := range []string{"@mi_malloc(", "@mi_free("} {
+		if strings.Contains(main, fn) {
+			t.Errorf("main calls %s even though memory is collected:\n%s", fn, main)
+		}
+	}
+}
+
+func TestCodegenQualifiedNames(t *testing.T) {
+	modu := codegenSource(t, "import o `ooflib`\nfunc x(s: o.Shape) int64 => s.X()\nfunc main() => o.Oof(x(o.Dot(o.Pt { x: 1, y: 2 })))\n", settings{importPaths: []string{ooflib(t)}})
+
+	for _, want := range []string{"declare %int64 @_TW6ooflib3Oof(%int64", "call %int64 @_TW6ooflib3Oof(", "%ooflib.Pt = type { %int64, %int64 }", "(%ooflib.Shape %s)", "@_TW6ooflib7Shape.X("} {
+		if !strings.Contains(modu, want) {
+			t.Errorf("expected the IR to contain %q:\n%s", want, modu)
+		}
+	}
+}
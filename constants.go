@@ -0,0 +1,207 @@
+package main
+
+import (
+	"go/constant"
+	"go/token"
+	"math"
+	"strings"
+)
+
+// constantOperators are the operators that untyped constants are folded
+// with, and their go/constant equivalents.
+var constantOperators = map[TokenKind]token.Token{
+	PLUS:      token.ADD,
+	MINUS:     token.SUB,
+	STAR:      token.MUL,
+	SLASH:     token.QUO,
+	PERCENT:   token.REM,
+	AMPERSAND: token.AND,
+	PIPE:      token.OR,
+	CARET:     token.XOR,
+}
+
+// integerValue returns the value of an integer literal, which is unknown if
+// it's invalid. misplaced underscores are reported by the lexer.
+func integerValue(lit string) constant.Value {
+	digits := strings.ReplaceAll(lit, "_", "")
+	if len(digits) > 1 && digits[0] == '0' && !strings.ContainsRune("xXoObB", rune(digits[1])) {
+		// a leading zero doesn't make a literal octal
+		digits = strings.TrimLeft(digits, "0")
+		if digits == "" {
+			digits = "0"
+		}
+	}
+	return constant.MakeFromLiteral(digits, token.INT, 0)
+}
+
+// truncate rounds a constant towards zero, like converting it to an integer
+// type does.
+func truncate(val constant.Value) constant.Value {
+	if val.Kind() != constant.Float {
+		return val
+	}
+	if i := constant.ToInt(val); i.Kind() == constant.Int {
+		return i
+	}
+	f, _ := constant.Float64Val(val)
+	return constant.ToInt(constant.MakeFloat64(math.Trunc(f)))
+}
+
+// constantValue evaluates e if it's an untyped constant: a number literal or
+// arithmetic on them. dividing by zero gives an unknown value.
+func constantValue(e Expression) (constant.Value, bool) {
+	switch e := e.(type) {
+	case Lit:
+		switch lit := e.Literal.(type) {
+		case Integer:
+			// literals too big for an Integer are folded from their text
+			if e.Text != "" {
+				return integerValue(e.Text), true
+			}
+			return constant.MakeInt64(int64(lit)), true
+		case Float:
+			return constant.MakeFloat64(float64(lit)), true
+		case Char:
+			return constant.MakeInt64(int64(lit)), true
+		}
+	case Unary:
+		if e.Op != MINUS {
+			return nil, false
+		}
+		if of, ok := constantValue(e.Of); ok {
+			return constant.UnaryOp(token.SUB, of, 0), true
+		}
+	case Binary:
+		lhs, ok := constantValue(e.Left)
+		if !ok {
+			return nil, false
+		}
+		rhs, ok := constantValue(e.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldBinary(e.Op, lhs, rhs)
+	}
+
+	return nil, false
+}
+
+func foldBinary(op TokenKind, lhs, rhs constant.Value) (constant.Value, bool) {
+	if lhs.Kind() == constant.Unknown || rhs.Kind() == constant.Unknown {
+		return constant.MakeUnknown(), true
+	}
+	ints := lhs.Kind() == constant.Int && rhs.Kind() == constant.Int
+
+	switch op {
+	case SHIFTLEFT, SHIFTRIGHT:
+		count, exact := constant.Uint64Val(rhs)
+		if !ints || !exact || count > 1024 {
+			return nil, false
+		}
+		if op == SHIFTLEFT {
+			return constant.Shift(lhs, token.SHL, uint(count)), true
+		}
+		return constant.Shift(lhs, token.SHR, uint(count)), true
+	case SLASH, PERCENT:
+		if constant.Sign(rhs) == 0 {
+			return constant.MakeUnknown(), true
+		}
+	}
+
+	tok, ok := constantOperators[op]
+	if !ok {
+		return nil, false
+	}
+	switch {
+	case ints && tok == token.QUO:
+		// integers divide the same way they do at run time
+		tok = token.QUO_ASSIGN
+	case !ints && tok != token.ADD && tok != token.SUB && tok != token.MUL && tok != token.QUO:
+		return nil, false
+	}
+
+	return constant.BinaryOp(lhs, tok, rhs), true
+}
+
+// constantDefault is the type an untyped constant has when nothing else
+// decides it.
+func constantDefault(val constant.Value) tawaType {
+	if val.Kind() == constant.Float {
+		return typeFloat64
+	}
+	return typeInt64
+}
+
+// intRange returns the smallest and largest values of an integer type that
+// fit in an Integer literal.
+func intRange(b *basicType) (min, max int64) {
+	switch {
+	case b.bits >= 64:
+		return math.MinInt64, math.MaxInt64
+	case b.unsigned:
+		return 0, 1<<b.bits - 1
+	}
+	return -1 << (b.bits - 1), 1<<(b.bits-1) - 1
+}
+
+// floatMax returns the largest finite value of a floating point type that fits
+// in a Float literal.
+func floatMax(b *basicType) float64 {
+	switch b.bits {
+	case 16:
+		return 65504
+	case 32:
+		return math.MaxFloat32
+	}
+	return math.MaxFloat64
+}
+
+// constant gives the untyped constant e the type want, folding it into a
+// single literal. want must be numeric, but an integer type only takes
+// constants without a fraction.
+func (c *checker) constant(e Expression, val constant.Value, want tawaType) Typed {
+	at := posOf(e)
+	if val.Kind() == constant.Unknown {
+		c.errorf(ErrDivisionByZero, at, "division by zero in constant expression")
+		return Typed{Lit{Literal: Integer(0), Pos: at}, want}
+	}
+
+	b := underlying(want).(*basicType)
+	if b.kind == basicInt && val.Kind() == constant.Float {
+		want = typeFloat64
+		b = typeFloat64
+	}
+
+	if b.kind == basicFloat {
+		f, _ := constant.Float64Val(val)
+		if math.IsInf(f, 0) || math.Abs(f) > floatMax(b) {
+			c.errorf(ErrConstantOverflow, at, "constant %s overflows %s", val, want)
+		}
+		return Typed{Lit{Literal: Float(f), Pos: at}, want}
+	}
+
+	i, exact := constant.Int64Val(val)
+	if min, max := intRange(b); !exact || i < min || i > max {
+		c.errorf(ErrConstantOverflow, at, "constant %s overflows %s", val, want)
+	}
+	return Typed{Lit{Literal: Integer(i), Pos: at}, want}
+}
+
+// exprExpecting checks e where a value of type want is expected, which gives
+// untyped constants in it that type instead of their default one, and boxes
+// values expected to be of an interface type.
+func (c *checker) exprExpecting(e Expression, want tawaType) Typed {
+	switch expr := e.(type) {
+	case Block:
+		return c.block(expr, want)
+	case If:
+		return c.ifExpr(expr, want)
+	case Match:
+		return c.match(expr, want)
+	}
+
+	if val, ok := constantValue(e); ok && isNumeric(want) {
+		return c.constant(e, val, want)
+	}
+	return c.toInterface(untypedNil(c.expr(e), want), want)
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	return "unknown"
+}
+
+// Note is extra information attached to a diagnostic. Location may be empty
+// for notes that aren't about a particular piece of code.
+type Note struct {
+	Message  string
+	Location Span
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Code     ErrorCode
+	Location Span
+	Message  string
+	// Label is printed next to the underlined code, when there is any.
+	Label string
+	// Notes with a location are shown as secondary labels in the snippet.
+	Notes []Note
+	// Hints are suggestions on how to fix the problem.
+	Hints []string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s. %s", d.Message, d.Location)
+}
+
+// Diagnostics collects the problems found while compiling a package, so
+// that they can all be reported at once instead of stopping at the first.
+type Diagnostics struct {
+	list    []Diagnostic
+	sources map[string][]string
+}
+
+// AddSource registers the contents of a file, so that reported diagnostics
+// can show the code they're about.
+func (d *Diagnostics) AddSource(filename string, src []byte) {
+	if d.sources == nil {
+		d.sources = map[string][]string{}
+	}
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	d.sources[filename] = strings.Split(text, "\n")
+}
+
+func (d *Diagnostics) Add(diag Diagnostic) {
+	d.list = append(d.list, diag)
+}
+
+func (d *Diagnostics) Errorf(code ErrorCode, at Span, format string, args ...interface{}) {
+	d.Add(Diagnostic{
+		Severity: SeverityError,
+		Code:     code,
+		Location: at,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// AddError turns an error from one of the compiler's stages into a diagnostic.
+func (d *Diagnostics) AddError(err error) {
+	switch e := err.(type) {
+	case Diagnostic:
+		d.Add(e)
+	case ExpectedKindGotKind:
+		d.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrUnexpectedToken,
+			Location: e.Location,
+			Message:  fmt.Sprintf("expected %s, found %s", e.Expected.Describe(), e.Got.Describe()),
+			Label:    fmt.Sprintf("expected %s", e.Expected.Describe()),
+		})
+	case ExpectedOneOfKindGotKind:
+		var expected []string
+		for _, kind := range e.Expected {
+			expected = append(expected, kind.Describe())
+		}
+		message := fmt.Sprintf("expected one of %s, found %s", strings.Join(expected, ", "), e.Got.Describe())
+		if len(expected) == 1 {
+			message = fmt.Sprintf("expected %s, found %s", expected[0], e.Got.Describe())
+		}
+		d.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrUnexpectedToken,
+			Location: e.Location,
+			Message:  message,
+			Label:    fmt.Sprintf("unexpected %s", e.Got.Describe()),
+		})
+	case DuplicateField:
+		d.Errorf(ErrDuplicateField, e.Location, "field %s specified more than once", e.Name)
+	default:
+		d.Errorf(ErrIO, Span{}, "%s", err)
+	}
+}
+
+func (d *Diagnostics) List() []Diagnostic {
+	return d.list
+}
+
+func (d *Diagnostics) Count(s Severity) (n int) {
+	for _, diag := range d.list {
+		if diag.Severity == s {
+			n++
+		}
+	}
+	return
+}
+
+func (d *Diagnostics) HasErrors() bool {
+	return d.Count(SeverityError) > 0
+}
+
+// Report writes out every diagnostic followed by a summary. When w is a
+// terminal the output is coloured.
+func (d *Diagnostics) Report(w io.Writer) {
+	r := renderer{w: w, sources: d.sources, color: isTerminal(w)}
+	for _, diag := range d.list {
+		r.diagnostic(diag)
+	}
+
+	if n := d.Count(SeverityError); n == 1 {
+		r.printf(styleBold, "error: aborting due to previous error\n")
+	} else if n > 1 {
+		r.printf(styleBold, "error: aborting due to %d previous errors\n", n)
+	}
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type jsonRange struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonNote struct {
+	Message string     `json:"message"`
+	File    string     `json:"file,omitempty"`
+	Range   *jsonRange `json:"range,omitempty"`
+}
+
+type jsonDiagnostic struct {
+	File     string     `json:"file,omitempty"`
+	Range    *jsonRange `json:"range,omitempty"`
+	Severity string     `json:"severity"`
+	Code     ErrorCode  `json:"code,omitempty"`
+	Message  string     `json:"message"`
+	Label    string     `json:"label,omitempty"`
+	Notes    []jsonNote `json:"notes,omitempty"`
+	Hints    []string   `json:"hints,omitempty"`
+}
+
+// toJSONRange returns nil for spans that don't point anywhere.
+func toJSONRange(s Span) *jsonRange {
+	if s.From.Line == 0 {
+		return nil
+	}
+	return &jsonRange{
+		Start: jsonPosition{s.From.Line, s.From.Column},
+		End:   jsonPosition{s.To.Line, s.To.Column},
+	}
+}
+
+// ReportJSON writes out every diagnostic as a JSON object on its own line,
+// for editors and CI tools. ranges are inclusive, and lines and columns
+// start at 1.
+func (d *Diagnostics) ReportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, diag := range d.list {
+		out := jsonDiagnostic{
+			File:     diag.Location.From.Filename,
+			Range:    toJSONRange(diag.Location),
+			Severity: diag.Severity.String(),
+			Code:     diag.Code,
+			Message:  diag.Message,
+			Label:    diag.Label,
+			Hints:    diag.Hints,
+		}
+		for _, note := range diag.Notes {
+			out.Notes = append(out.Notes, jsonNote{
+				Message: note.Message,
+				File:    note.Location.From.Filename,
+				Range:   toJSONRange(note.Location),
+			})
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTerminal(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	styleBold    = "\x1b[1m"
+	styleRed     = "\x1b[1;31m"
+	styleYellow  = "\x1b[1;33m"
+	styleGreen   = "\x1b[1;32m"
+	styleBlue    = "\x1b[1;34m"
+	styleCyan    = "\x1b[1;36m"
+	styleReset   = "\x1b[0m"
+	tabWidth     = 4
+	contextLines = 1
+)
+
+type renderer struct {
+	w       io.Writer
+	sources map[string][]string
+	color   bool
+}
+
+func (r renderer) printf(style string, format string, args ...interface{}) {
+	if r.color && style != "" {
+		fmt.Fprint(r.w, style)
+		fmt.Fprintf(r.w, format, args...)
+		fmt.Fprint(r.w, styleReset)
+		return
+	}
+	fmt.Fprintf(r.w, format, args...)
+}
+
+func (s Severity) style() string {
+	switch s {
+	case SeverityError:
+		return styleRed
+	case SeverityWarning:
+		return styleYellow
+	}
+	return styleGreen
+}
+
+// label is an underlined piece of code. the primary label is the one the
+// diagnostic is about, and is underlined with carets.
+type label struct {
+	span    Span
+	message string
+	primary bool
+}
+
+func (r renderer) diagnostic(diag Diagnostic) {
+	if diag.Code != "" {
+		r.printf(diag.Severity.style(), "%s[%s]", diag.Severity, diag.Code)
+	} else {
+		r.printf(diag.Severity.style(), "%s", diag.Severity)
+	}
+	r.printf(styleBold, ": %s\n", diag.Message)
+
+	labels := []label{{diag.Location, diag.Label, true}}
+	var notes []string
+	for _, note := range diag.Notes {
+		if note.Location == (Span{}) {
+			notes = append(notes, note.Message)
+		} else {
+			labels = append(labels, label{note.Location, note.Message, false})
+		}
+	}
+
+	// labels are grouped by file, with the primary label's file first.
+	var files []string
+	byFile := map[string][]label{}
+	for _, l := range labels {
+		name := l.span.From.Filename
+		if _, ok := byFile[name]; !ok {
+			files = append(files, name)
+		}
+		byFile[name] = append(byFile[name], l)
+	}
+
+	width := 0
+	for _, l := range labels {
+		if n := len(strconv.Itoa(l.span.To.Line)); n > width {
+			width = n
+		}
+	}
+	gutter := strings.Repeat(" ", width)
+
+	for i, name := range files {
+		ls := byFile[name]
+		arrow := "-->"
+		if i > 0 {
+			arrow = ":::"
+		}
+		r.printf(styleBlue, "%s%s ", gutter, arrow)
+		fmt.Fprintf(r.w, "%s\n", ls[0].span.From)
+
+		lines, ok := r.sources[name]
+		if !ok || ls[0].span.From.Line == 0 {
+			for _, l := range ls {
+				if !l.primary {
+					r.printf(styleBlue, "%s = ", gutter)
+					fmt.Fprintf(r.w, "note: %s (%s)\n", l.message, l.span.From)
+				}
+			}
+			continue
+		}
+		r.snippet(lines, ls, gutter)
+	}
+
+	if len(notes) > 0 || len(diag.Hints) > 0 {
+		r.printf(styleBlue, "%s |\n", gutter)
+	}
+	for _, note := range notes {
+		r.printf(styleBlue, "%s = ", gutter)
+		r.printf(styleBold, "note")
+		fmt.Fprintf(r.w, ": %s\n", note)
+	}
+	for _, hint := range diag.Hints {
+		r.printf(styleBlue, "%s = ", gutter)
+		r.printf(styleBold, "help")
+		fmt.Fprintf(r.w, ": %s\n", hint)
+	}
+	fmt.Fprintln(r.w)
+}
+
+// snippet prints the lines of a file that labels point at, each followed by
+// its underlines.
+func (r renderer) snippet(lines []string, labels []label, gutter string) {
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].span.From.Line < labels[j].span.From.Line
+	})
+
+	// which lines to show, and which labels end on each of them. labels
+	// spanning several lines are underlined on their first and last line.
+	shown := map[int]bool{}
+	underlines := map[int][]label{}
+	for _, l := range labels {
+		from, to := l.span.From, l.span.To
+		if to.Line < from.Line || (to.Line == from.Line && to.Column < from.Column) {
+			to = from
+		}
+		if from.Line == to.Line {
+			shown[from.Line] = true
+			underlines[from.Line] = append(underlines[from.Line], label{Span{from, to}, l.message, l.primary})
+			continue
+		}
+		shown[from.Line] = true
+		shown[to.Line] = true
+		first := label{Span{from, Position{Line: from.Line, Column: len([]rune(line(lines, from.Line)))}}, "", l.primary}
+		underlines[from.Line] = append(underlines[from.Line], first)
+		start := Position{Line: to.Line, Column: firstNonSpace(line(lines, to.Line))}
+		underlines[to.Line] = append(underlines[to.Line], label{Span{start, to}, l.message, l.primary})
+	}
+
+	var numbers []int
+	for n := range shown {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	r.printf(styleBlue, "%s |\n", gutter)
+	for i, n := range numbers {
+		if i > 0 && n-numbers[i-1] > contextLines+1 {
+			r.printf(styleBlue, "...\n")
+		} else if i > 0 {
+			for m := numbers[i-1] + 1; m < n; m++ {
+				r.sourceLine(lines, m, gutter)
+			}
+		}
+		r.sourceLine(lines, n, gutter)
+		r.underline(lines, n, underlines[n], gutter)
+	}
+}
+
+func (r renderer) sourceLine(lines []string, n int, gutter string) {
+	r.printf(styleBlue, "%*d | ", len(gutter), n)
+	fmt.Fprintf(r.w, "%s\n", expandTabs(line(lines, n)))
+}
+
+// underline prints the carets and dashes under a line of code. each label
+// gets its own row, so that their messages don't run into each other.
+func (r renderer) underline(lines []string, n int, labels []label, gutter string) {
+	text := []rune(line(lines, n))
+	sort.SliceStable(labels, func(i, j int) bool {
+		if labels[i].primary != labels[j].primary {
+			return labels[i].primary
+		}
+		return labels[i].span.From.Column < labels[j].span.From.Column
+	})
+	for _, l := range labels {
+		from := displayColumn(text, l.span.From.Column)
+		to := displayColumn(text, l.span.To.Column)
+		if to < from {
+			to = from
+		}
+		mark, style := "-", styleBlue
+		if l.primary {
+			mark, style = "^", styleRed
+		}
+		r.printf(styleBlue, "%s | ", gutter)
+		fmt.Fprint(r.w, strings.Repeat(" ", from-1))
+		r.printf(style, "%s", strings.Repeat(mark, to-from+1))
+		if l.message != "" {
+			r.printf(style, " %s", l.message)
+		}
+		fmt.Fprintln(r.w)
+	}
+}
+
+func line(lines []string, n int) string {
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return lines[n-1]
+}
+
+func firstNonSpace(s string) int {
+	for i, r := range []rune(s) {
+		if r != ' ' && r != '\t' {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// displayColumn converts a column counted in runes into one counted in
+// cells, once tabs have been expanded.
+func displayColumn(text []rune, column int) int {
+	display := 0
+	for i := 0; i < column-1; i++ {
+		if i < len(text) && text[i] == '\t' {
+			display += tabWidth
+		} else {
+			display++
+		}
+	}
+	return display + 1
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", strings.Repeat(" ", tabWidth))
+}
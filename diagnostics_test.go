@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReportSnippet(t *testing.T) {
+	diags := &Diagnostics{}
+	diags.AddSource("a.tawa", []byte("func main() {\n\tlet x = 1\n\tx = 2\n}\n"))
+	at := func(line, from, to int) Span {
+		return Span{Position{line, from, "a.tawa"}, Position{line, to, "a.tawa"}}
+	}
+	diags.Add(Diagnostic{
+		Severity: SeverityError,
+		Location: at(3, 2, 6),
+		Message:  "x is not mutable",
+		Label:    "cannot assign twice",
+		Notes:    []Note{{"declared here", at(2, 6, 6)}},
+		Hints:    []string{"declare x with 'var'"},
+	})
+
+	var out strings.Builder
+	diags.Report(&out)
+
+	expected := `error: x is not mutable
+ --> a.tawa:3:2
+  |
+2 |     let x = 1
+  |         - declared here
+3 |     x = 2
+  |     ^^^^^ cannot assign twice
+  |
+  = help: declare x with 'var'
+
+error: aborting due to previous error
+`
+	if out.String() != expected {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	diags := &Diagnostics{}
+	diags.Errorf(ErrUndefined, Span{Position{1, 2, "a.tawa"}, Position{1, 4, "a.tawa"}}, "undefined: %s", "foo")
+	diags.Errorf(ErrCodegen, Span{}, "oops")
+
+	var out strings.Builder
+	if err := diags.ReportJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"file":"a.tawa","range":{"start":{"line":1,"column":2},"end":{"line":1,"column":4}},"severity":"error","code":"E0100","message":"undefined: foo"}
+{"severity":"error","code":"E0200","message":"oops"}
+`
+	if out.String() != expected {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestParserRecoversInLiterals(t *testing.T) {
+	sources := []string{
+		"type P struct {\n    a: int64\n}\nfunc main() {\n    let x = P { a: ) }\n    let y = 1\n}\n",
+		"type B struct {\n    c: int64\n}\nfunc main() {\n    let x = B { c: 0b102 }\n    let y = 1\n}\n",
+	}
+
+	for _, src := range sources {
+		p := NewParser(NewLexer(strings.NewReader(src), "test"))
+		p.Parse()
+		if diags := p.l.diags.List(); len(diags) != 1 {
+			t.Errorf("expected 1 error for %q, got %v", src, diags)
+		}
+	}
+}
@@ -2,6 +2,50 @@ package main
 
 import "fmt"
 
+// ErrorCode identifies what kind of problem a diagnostic is about. codes are
+// stable, so that tools consuming diagnostics can rely on them.
+type ErrorCode string
+
+const (
+	ErrUnexpectedToken     ErrorCode = "E0001"
+	ErrUnexpectedCharacter ErrorCode = "E0002"
+	ErrDuplicateField      ErrorCode = "E0003"
+	ErrUnterminatedComment ErrorCode = "E0004"
+	ErrInvalidLiteral      ErrorCode = "E0005"
+	ErrUnterminatedString  ErrorCode = "E0006"
+
+	ErrUndefined         ErrorCode = "E0100"
+	ErrNotAType          ErrorCode = "E0101"
+	ErrNotAValue         ErrorCode = "E0102"
+	ErrNotAFunction      ErrorCode = "E0103"
+	ErrNotAStruct        ErrorCode = "E0104"
+	ErrRedeclared        ErrorCode = "E0105"
+	ErrRecursiveType     ErrorCode = "E0106"
+	ErrMismatchedTypes   ErrorCode = "E0107"
+	ErrArgumentCount     ErrorCode = "E0108"
+	ErrUndefinedOperator ErrorCode = "E0109"
+	ErrUnknownField      ErrorCode = "E0110"
+	ErrImmutable         ErrorCode = "E0111"
+	ErrOutsideLoop       ErrorCode = "E0112"
+	ErrConstantOverflow  ErrorCode = "E0113"
+	ErrDivisionByZero    ErrorCode = "E0114"
+	ErrNotASum           ErrorCode = "E0115"
+	ErrUnknownVariant    ErrorCode = "E0116"
+	ErrUnreachableArm    ErrorCode = "E0117"
+	ErrNonExhaustive     ErrorCode = "E0118"
+	ErrUnknownMethod     ErrorCode = "E0119"
+	ErrInvalidReceiver   ErrorCode = "E0120"
+	ErrCannotInfer       ErrorCode = "E0121"
+	ErrTypeParameters    ErrorCode = "E0122"
+	ErrNotIndexable      ErrorCode = "E0123"
+	ErrOutOfRange        ErrorCode = "E0124"
+	ErrNotAddressable    ErrorCode = "E0125"
+
+	ErrCodegen ErrorCode = "E0200"
+	ErrImport  ErrorCode = "E0300"
+	ErrIO      ErrorCode = "E0400"
+)
+
 type ExpectedKindGotKind struct {
 	Expected TokenKind
 	Got      TokenKind
@@ -9,7 +53,7 @@ type ExpectedKindGotKind struct {
 }
 
 func (e ExpectedKindGotKind) Error() string {
-	return fmt.Sprintf("got a %d, expected a %d. %s", e.Got, e.Expected, e.Location)
+	return fmt.Sprintf("got a %s, expected a %s. %s", e.Got, e.Expected, e.Location)
 }
 
 type ExpectedOneOfKindGotKind struct {
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const indentation = "    "
+
+// precedences of things that aren't binary operators, for deciding where
+// parentheses are needed. open ended expressions like if and let swallow
+// any operators following them, so they always need them as operands.
+const (
+	precOpen    = 0
+	precUnary   = 6
+	precPostfix = 7
+)
+
+// formatter prints an AST in the canonical layout. its output always parses
+// back into the same AST.
+type formatter struct {
+	buf    bytes.Buffer
+	indent int
+
+	// struct literals can't appear unparenthesised in loop headers
+	noStructLiteral bool
+
+	// comments that haven't been printed yet, and the source line of the
+	// last thing printed. comments are placed by their line in the source,
+	// keeping single blank lines around them.
+	comments []Comment
+	prevLine int
+}
+
+// formatFile returns the canonical source for a file.
+func formatFile(file AST) []byte {
+	f := &formatter{comments: file.Comments}
+	tls := file.Toplevels
+	for i, tl := range tls {
+		if i > 0 {
+			_, prevImport := tls[i-1].(Import)
+			_, isImport := tl.(Import)
+			if !prevImport || !isImport {
+				f.buf.WriteString("\n")
+			}
+		}
+
+		start := toplevelPos(tl)
+		for f.commentBefore(start.From.Line) {
+			f.gap(f.comments[0].Pos.From.Line)
+			f.comment()
+			f.buf.WriteString("\n")
+		}
+		f.gap(start.From.Line)
+
+		f.toplevel(tl)
+		f.prevLine = start.To.Line
+		f.trailingComment()
+		f.buf.WriteString("\n")
+	}
+
+	for len(f.comments) > 0 {
+		f.gap(f.comments[0].Pos.From.Line)
+		f.comment()
+		f.buf.WriteString("\n")
+	}
+	return f.buf.Bytes()
+}
+
+func toplevelPos(tl TopLevel) Span {
+	switch tl := tl.(type) {
+	case Import:
+		return tl.Pos
+	case TypeDeclaration:
+		// type declarations end where their type does, which isn't
+		// recorded
+		return tl.Ident.Pos
+	case Func:
+		return tl.Pos
+	}
+	return Span{}
+}
+
+// commentBefore reports whether the next comment is before line.
+func (f *formatter) commentBefore(line int) bool {
+	return len(f.comments) > 0 && line != 0 && f.comments[0].Pos.From.Line < line
+}
+
+// comment prints the next comment.
+func (f *formatter) comment() {
+	c := f.comments[0]
+	f.comments = f.comments[1:]
+	f.buf.WriteString(c.Text)
+	f.prevLine = c.Pos.To.Line
+}
+
+// trailingComment prints the next comment after what was just printed if it
+// was on the same line in the source.
+func (f *formatter) trailingComment() {
+	if len(f.comments) > 0 && f.prevLine != 0 && f.comments[0].Pos.From.Line == f.prevLine {
+		f.buf.WriteString(" ")
+		f.comment()
+	}
+}
+
+// gap keeps a blank line between what was printed last and something on
+// line in the source, if there was one there.
+func (f *formatter) gap(line int) {
+	if f.prevLine == 0 || line <= f.prevLine+1 || bytes.HasSuffix(f.buf.Bytes(), []byte("\n\n")) {
+		return
+	}
+	f.buf.WriteString("\n")
+}
+
+func (f *formatter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&f.buf, format, args...)
+}
+
+func (f *formatter) newline() {
+	f.buf.WriteString("\n")
+	f.buf.WriteString(strings.Repeat(indentation, f.indent))
+}
+
+func (f *formatter) toplevel(tl TopLevel) {
+	switch tl := tl.(type) {
+	case Import:
+		if tl.Alias != nil {
+			f.printf("import %s `%s`", tl.Alias.Name, tl.Path)
+		} else {
+			f.printf("import `%s`", tl.Path)
+		}
+	case TypeDeclaration:
+		f.printf("type %s%s", tl.Ident.Name, typeParamsToString(tl.TypeParams))
+		if sum, ok := tl.Kind.(Sum); ok {
+			f.printf(" =")
+			f.sum(sum)
+			return
+		}
+		f.printf(" ")
+		f.kind(tl.Kind)
+	case Func:
+		f.printf("func ")
+		if tl.Receiver != nil {
+			f.printf("(%s %s) ", tl.Receiver.Ident.Name, tl.Receiver.Kind.Name)
+		}
+		f.printf("%s%s", tl.Ident.Name, typeParamsToString(tl.TypeParams))
+		f.signature(tl.Arguments, tl.Returns)
+
+		if block, ok := unwrapTyped(tl.Expr).(Block); ok {
+			f.printf(" ")
+			f.block(block, tl.Pos.To.Line)
+		} else {
+			f.printf(" => ")
+			f.expr(tl.Expr)
+		}
+	default:
+		panic(fmt.Sprintf("unhandled top level %T", tl))
+	}
+}
+
+// signature prints the parameters and return type of a function or method.
+func (f *formatter) signature(params []struct {
+	Ident Identifier
+	Kind  Type
+}, returns *Type) {
+	f.printf("(")
+	for i, param := range params {
+		if i > 0 {
+			f.printf(", ")
+		}
+		f.printf("%s: ", param.Ident.Name)
+		f.kind(param.Kind)
+	}
+	f.printf(")")
+	if returns != nil {
+		f.printf(" ")
+		f.kind(*returns)
+	}
+}
+
+func (f *formatter) kind(t Type) {
+	switch t := t.(type) {
+	case Ident:
+		f.printf("%s", t.Name)
+	case Generic:
+		f.printf("%s", t.Ident.Name)
+		f.typeArguments(t.Arguments)
+	case Array:
+		f.printf("[%d]", t.Length)
+		f.kind(t.Elem)
+	case Slice:
+		f.printf("[]")
+		f.kind(t.Elem)
+	case Pointer:
+		f.printf("*")
+		f.kind(t.Elem)
+	case FunctionPointer:
+		f.printf("func(")
+		for i, arg := range t.Arguments {
+			if i > 0 {
+				f.printf(", ")
+			}
+			f.kind(arg)
+		}
+		f.printf(")")
+		if t.Returns != nil {
+			f.printf(" ")
+			f.kind(*t.Returns)
+		}
+	case Struct:
+		if len(t) == 0 {
+			f.printf("struct {}")
+			return
+		}
+		f.printf("struct {")
+		f.indent++
+		f.prevLine = 0
+		for _, field := range t {
+			line := field.Pos.From.Line
+			for f.commentBefore(line) {
+				f.gap(f.comments[0].Pos.From.Line)
+				f.newline()
+				f.comment()
+			}
+			f.gap(line)
+
+			f.newline()
+			f.printf("%s: ", field.Ident)
+			f.kind(field.Kind)
+			if line != 0 {
+				// nested struct types end lines after the field starts
+				f.prevLine = field.Pos.To.Line
+				f.trailingComment()
+			}
+		}
+		f.indent--
+		f.newline()
+		f.printf("}")
+	case Interface:
+		if len(t) == 0 {
+			f.printf("interface {}")
+			return
+		}
+		f.printf("interface {")
+		f.indent++
+		f.prevLine = 0
+		for _, method := range t {
+			line := method.Ident.Pos.From.Line
+			for f.commentBefore(line) {
+				f.gap(f.comments[0].Pos.From.Line)
+				f.newline()
+				f.comment()
+			}
+			f.gap(line)
+
+			f.newline()
+			f.printf("%s", method.Ident.Name)
+			f.signature(method.Arguments, method.Returns)
+			if line != 0 {
+				f.prevLine = line
+				f.trailingComment()
+			}
+		}
+		f.indent--
+		f.newline()
+		f.printf("}")
+	default:
+		panic(fmt.Sprintf("unhandled type %T", t))
+	}
+}
+
+// typeArguments prints the type arguments given to a generic function or
+// type, if there are any.
+func (f *formatter) typeArguments(args []Type) {
+	if len(args) == 0 {
+		return
+	}
+	f.printf("[")
+	for i, arg := range args {
+		if i > 0 {
+			f.printf(", ")
+		}
+		f.kind(arg)
+	}
+	f.printf("]")
+}
+
+// sum prints the variants of a sum type, one per line.
+func (f *formatter) sum(s Sum) {
+	f.indent++
+	f.prevLine = 0
+	for _, variant := range s {
+		line := variant.Ident.Pos.From.Line
+		for f.commentBefore(line) {
+			f.gap(f.comments[0].Pos.From.Line)
+			f.newline()
+			f.comment()
+		}
+		f.gap(line)
+
+		f.newline()
+		f.printf("| %s", variant.Ident.Name)
+		if variant.Payload != nil {
+			f.printf(" of ")
+			f.kind(*variant.Payload)
+		}
+		if line != 0 {
+			// payloads can end lines after the variant starts
+			f.prevLine = variant.Pos.To.Line
+			f.trailingComment()
+		}
+	}
+	f.indent--
+}
+
+func unwrapTyped(e Expression) Expression {
+	if typed, ok := e.(Typed); ok {
+		return unwrapTyped(typed.Expr)
+	}
+	return e
+}
+
+func precedence(e Expression) int {
+	switch e := unwrapTyped(e).(type) {
+	case Binary:
+		return binaryPrecedence[e.Op]
+	case Unary:
+		return precUnary
+	case Lit:
+		if i, ok := e.Literal.(Integer); ok && i < 0 {
+			return precUnary
+		}
+		if f, ok := e.Literal.(Float); ok && math.Signbit(float64(f)) {
+			return precUnary
+		}
+		return precPostfix
+	case Var, Call, CallValue, MethodCall, Field, Index, Subslice, New, Block:
+		return precPostfix
+	}
+	return precOpen
+}
+
+// operand prints an expression that needs to bind at least as tightly as
+// prec, parenthesising it if it doesn't.
+func (f *formatter) operand(e Expression, prec int) {
+	if precedence(e) >= prec {
+		f.expr(e)
+		return
+	}
+
+	noStructLiteral := f.noStructLiteral
+	f.noStructLiteral = false
+	f.printf("(")
+	f.expr(e)
+	f.printf(")")
+	f.noStructLiteral = noStructLiteral
+}
+
+// loopHeader prints the condition or range of a loop, where a struct literal
+// would be taken for the start of the body.
+func (f *formatter) loopHeader(e Expression) {
+	f.noStructLiteral = true
+	f.expr(e)
+	f.noStructLiteral = false
+}
+
+// block prints a block, which ends on line end in the source if that's known.
+// comments before the end are printed inside of the block.
+func (f *formatter) block(b Block, end int) {
+	if len(b) == 0 && !f.commentBefore(end) {
+		f.printf("{}")
+		return
+	}
+
+	f.printf("{")
+	f.indent++
+	f.prevLine = 0
+	for _, stmt := range b {
+		for f.commentBefore(firstLine(stmt)) {
+			f.gap(f.comments[0].Pos.From.Line)
+			f.newline()
+			f.comment()
+		}
+		f.gap(firstLine(stmt))
+
+		f.newline()
+		f.expr(stmt)
+		f.prevLine = lastLine(stmt)
+		f.trailingComment()
+	}
+	for f.commentBefore(end) {
+		f.gap(f.comments[0].Pos.From.Line)
+		f.newline()
+		f.comment()
+	}
+	f.indent--
+	f.newline()
+	f.printf("}")
+}
+
+func (f *formatter) expr(e Expression) {
+	switch e := e.(type) {
+	case Typed:
+		f.expr(e.Expr)
+	case Lit:
+		f.literal(e)
+	case Var:
+		f.printf("%s", e.Name)
+	case Declaration:
+		f.printf("let %s = ", e.To.Name)
+		f.expr(e.Value)
+	case MutDeclaration:
+		f.printf("var %s = ", e.To.Name)
+		f.expr(e.Value)
+	case Field:
+		f.operand(e.Of, precPostfix)
+		f.printf(".%s", e.Ident.Name)
+	case Assignment:
+		f.printf("%s = ", e.To.Name)
+		f.expr(e.Value)
+	case FieldAssignment:
+		f.operand(e.Struct, precPostfix)
+		f.printf(".%s = ", e.Field.Name)
+		f.expr(e.Value)
+	case Index:
+		f.operand(e.Of, precPostfix)
+		f.printf("[")
+		f.expr(e.Index)
+		f.printf("]")
+	case IndexAssignment:
+		f.operand(e.Of, precPostfix)
+		f.printf("[")
+		f.expr(e.Index)
+		f.printf("] = ")
+		f.expr(e.Value)
+	case DerefAssignment:
+		// pointers that aren't postfix expressions need parentheses, as
+		// the operand of * would take the = with it otherwise
+		f.printf("*")
+		f.operand(e.Of, precPostfix)
+		f.printf(" = ")
+		f.expr(e.Value)
+	case New:
+		f.printf("new ")
+		if e.Value != nil {
+			f.expr(e.Value)
+		} else {
+			f.kind(e.Kind)
+		}
+	case Subslice:
+		f.operand(e.Of, precPostfix)
+		f.printf("[")
+		if e.Low != nil {
+			f.expr(e.Low)
+		}
+		f.printf(":")
+		if e.High != nil {
+			f.expr(e.High)
+		}
+		f.printf("]")
+	case Call:
+		f.printf("%s", e.Function.Name)
+		f.typeArguments(e.TypeArguments)
+		f.arguments(e.Arguments)
+	case CallValue:
+		f.operand(e.Callee, precPostfix)
+		f.arguments(e.Arguments)
+	case MethodCall:
+		f.operand(e.Of, precPostfix)
+		f.printf(".%s", e.Method.Name)
+		f.arguments(e.Arguments)
+	case Lambda:
+		f.printf("func")
+		f.signature(e.Arguments, e.Returns)
+		if block, ok := unwrapTyped(e.Expr).(Block); ok {
+			f.printf(" ")
+			f.block(block, e.Pos.To.Line)
+		} else {
+			f.printf(" => ")
+			f.expr(e.Expr)
+		}
+	case Block:
+		f.block(e, 0)
+	case If:
+		f.printf("if ")
+		f.expr(e.Condition)
+		f.printf(" then ")
+		f.expr(e.Then)
+		f.printf(" else ")
+		f.expr(e.Else)
+	case Binary:
+		prec := binaryPrecedence[e.Op]
+		f.operand(e.Left, prec)
+		f.printf(" %s ", tokenText[e.Op])
+		// operators are left associative, so an operator of the same
+		// precedence on the right needs parentheses
+		f.operand(e.Right, prec+1)
+	case Unary:
+		f.printf("%s", tokenText[e.Op])
+		f.operand(e.Of, precUnary)
+	case While:
+		f.printf("while ")
+		f.loopHeader(e.Condition)
+		f.printf(" ")
+		f.body(e.Body, e.Pos.To.Line)
+	case For:
+		f.printf("for %s in ", e.Ident.Name)
+		f.loopHeader(e.From)
+		f.printf("..")
+		f.loopHeader(e.To)
+		f.printf(" ")
+		f.body(e.Body, e.Pos.To.Line)
+	case Match:
+		f.printf("match ")
+		f.loopHeader(e.Of)
+		f.printf(" ")
+		f.arms(e.Arms, e.Pos.To.Line)
+	case Break:
+		f.printf("break")
+	case Continue:
+		f.printf("continue")
+	default:
+		panic(fmt.Sprintf("unhandled expression %T", e))
+	}
+}
+
+func (f *formatter) arguments(args []Expression) {
+	f.printf("(")
+	for i, arg := range args {
+		if i > 0 {
+			f.printf(", ")
+		}
+		f.expr(arg)
+	}
+	f.printf(")")
+}
+
+// arms prints the arms of a match, which ends on line end in the source.
+func (f *formatter) arms(arms []MatchArm, end int) {
+	f.printf("{")
+	f.indent++
+	f.prevLine = 0
+	for _, arm := range arms {
+		line := arm.Variant.Pos.From.Line
+		for f.commentBefore(line) {
+			f.gap(f.comments[0].Pos.From.Line)
+			f.newline()
+			f.comment()
+		}
+		f.gap(line)
+
+		f.newline()
+		f.printf("%s", arm.Variant.Name)
+		if arm.Binding != nil {
+			f.printf("(%s)", arm.Binding.Name)
+		}
+		f.printf(" => ")
+		f.expr(arm.Body)
+		f.prevLine = lastLine(arm.Body)
+		f.trailingComment()
+	}
+	for f.commentBefore(end) {
+		f.gap(f.comments[0].Pos.From.Line)
+		f.newline()
+		f.comment()
+	}
+	f.indent--
+	f.newline()
+	f.printf("}")
+}
+
+// body prints the body of a loop, which is always a block.
+func (f *formatter) body(e Expression, end int) {
+	if block, ok := unwrapTyped(e).(Block); ok {
+		f.block(block, end)
+		return
+	}
+	f.block(Block{e}, end)
+}
+
+// formatFloat prints a float so that it lexes as a float again.
+func formatFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	s = strings.NewReplacer("e+0", "e", "e+", "e", "e-0", "e-").Replace(s)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+func (f *formatter) literal(lit Lit) {
+	switch l := lit.Literal.(type) {
+	case Integer:
+		if lit.Text != "" {
+			f.printf("%s", lit.Text)
+		} else {
+			f.printf("%d", int64(l))
+		}
+	case Float:
+		if lit.Text != "" {
+			f.printf("%s", lit.Text)
+		} else {
+			f.printf("%s", formatFloat(float64(l)))
+		}
+	case Char:
+		if lit.Text != "" {
+			f.printf("%s", lit.Text)
+		} else {
+			f.printf("%s", strconv.QuoteRune(rune(l)))
+		}
+	case StringLiteral:
+		if lit.Text != "" {
+			f.printf("%s", lit.Text)
+		} else {
+			f.printf("`%s`", string(l))
+		}
+	case StructLiteral:
+		if f.noStructLiteral {
+			f.noStructLiteral = false
+			f.printf("(")
+			f.structLiteral(l)
+			f.printf(")")
+			f.noStructLiteral = true
+			return
+		}
+		f.structLiteral(l)
+	case ArrayLiteral:
+		f.kind(l.Kind)
+		if len(l.Elements) == 0 {
+			f.printf(" {}")
+			return
+		}
+		f.printf(" { ")
+		for i, elem := range l.Elements {
+			if i > 0 {
+				f.printf(", ")
+			}
+			f.expr(elem)
+		}
+		f.printf(" }")
+	default:
+		panic(fmt.Sprintf("unhandled literal %T", l))
+	}
+}
+
+func (f *formatter) structLiteral(l StructLiteral) {
+	f.printf("%s", l.Ident.Name)
+	f.typeArguments(l.TypeArguments)
+	if len(l.Fields) == 0 {
+		f.printf(" {}")
+		return
+	}
+
+	// fields are kept in the order they were written in
+	names := make([]string, 0, len(l.Fields))
+	for name := range l.Fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := posOf(l.Fields[names[i]]).From, posOf(l.Fields[names[j]]).From
+		if a != b {
+			return before(a, b)
+		}
+		return names[i] < names[j]
+	})
+
+	f.printf(" { ")
+	for i, name := range names {
+		if i > 0 {
+			f.printf(", ")
+		}
+		f.printf("%s: ", name)
+		f.expr(l.Fields[name])
+	}
+	f.printf(" }")
+}
+
+// firstLine and lastLine return the lines an expression starts and ends on,
+// as far as the positions in the AST tell.
+func firstLine(e Expression) int {
+	switch e := e.(type) {
+	case Typed:
+		return firstLine(e.Expr)
+	case Block:
+		if len(e) > 0 {
+			return firstLine(e[0])
+		}
+		return 0
+	}
+	return posOf(e).From.Line
+}
+
+func lastLine(e Expression) int {
+	last := posOf(e).To.Line
+	more := func(es ...Expression) {
+		for _, e := range es {
+			if e == nil {
+				continue
+			}
+			if line := lastLine(e); line > last {
+				last = line
+			}
+		}
+	}
+
+	switch e := e.(type) {
+	case Typed:
+		more(e.Expr)
+	case Lit:
+		if lit, ok := e.Literal.(StructLiteral); ok {
+			for _, field := range lit.Fields {
+				more(field)
+			}
+		}
+		if lit, ok := e.Literal.(ArrayLiteral); ok {
+			more(lit.Elements...)
+		}
+	case Declaration:
+		more(e.Value)
+	case MutDeclaration:
+		more(e.Value)
+	case Field:
+		more(e.Of)
+	case Assignment:
+		more(e.Value)
+	case FieldAssignment:
+		more(e.Value)
+	case Index:
+		more(e.Of, e.Index)
+	case IndexAssignment:
+		more(e.Value)
+	case Subslice:
+		more(e.Of, e.Low, e.High)
+	case DerefAssignment:
+		more(e.Value)
+	case New:
+		more(e.Value)
+	case Call:
+		more(e.Arguments...)
+	case CallValue:
+		more(e.Arguments...)
+	case MethodCall:
+		more(e.Of)
+		more(e.Arguments...)
+	case Lambda:
+		more(e.Expr)
+	case Block:
+		more(e...)
+	case If:
+		last = e.Pos.To.Line
+		more(e.Condition, e.Then, e.Else)
+	case Binary:
+		more(e.Right)
+	case Unary:
+		more(e.Of)
+	case While:
+		last = e.Pos.To.Line
+		more(e.Body)
+	case For:
+		last = e.Pos.To.Line
+		more(e.Body)
+	case Match:
+		last = e.Pos.To.Line
+	}
+	return last
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"testing"
+)
+
+func formatString(t *testing.T, src string) string {
+	diags := &Diagnostics{}
+	ast := parseFile("test", []byte(src), diags)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors for %q: %v", src, diags.List())
+	}
+	return string(formatFile(ast))
+}
+
+func TestFormat(t *testing.T) {
+	src := `import o  "ooflib"
+import "other"
+type P struct { a: int64; b: int64 }
+type F func(int64,  P) bool
+type S = | A of int64 | B
+type W interface { Write(s: string,  n: int64) int64; Close() }
+type Box[T] struct { v: T }
+func Max[ T ](a: T, b: T) T => if a > b then a else b
+func (p P) Sum(  c: int64) int64 => p.a+p.b+c
+func g(x: int64) int64 => (x + 1) * 2 - (3 - 4) - -x
+func h(w: o . W) string => o.Name( w)
+func main() {
+    let p = P{b: 2, a: 1}
+    p.Sum(1) + (P { a: 1, b: 2 }).Sum( 2 )
+    while (P { a: 1, b: 2 }).a < (if true then 1 else 2) { break }
+    var y = !(1 < 2) == false
+
+
+
+    y = (let z = 3) + 1
+    for i in 0..10 {}
+    let f = 2.0 * 1.5e3 + 0x1p-2
+    let n = 0xff_ff+0b1 * 1_000
+    let s = "a\tb" + 'c'
+    let b = Box[Box[int64]]{v: Box[int64] {v: Max[int64](1, 2)}}
+    match B { A(a) => a
+      B => 0 }
+    let add = func(x: int64)  int64 =>x+1
+    (func() { print("hi") })()
+    let xs = [3]int64{1,2}
+    xs[0:2][ 1 ] + len([]byte{})
+    let n = new  P{a: 1}
+    *(&n.a) = *n .b
+}
+`
+	expected := `import o ` + "`ooflib`" + `
+import ` + "`other`" + `
+
+type P struct {
+    a: int64
+    b: int64
+}
+
+type F func(int64, P) bool
+
+type S =
+    | A of int64
+    | B
+
+type W interface {
+    Write(s: string, n: int64) int64
+    Close()
+}
+
+type Box[T] struct {
+    v: T
+}
+
+func Max[T](a: T, b: T) T => if a > b then a else b
+
+func (p P) Sum(c: int64) int64 => p.a + p.b + c
+
+func g(x: int64) int64 => (x + 1) * 2 - (3 - 4) - -x
+
+func h(w: o.W) string => o.Name(w)
+
+func main() {
+    let p = P { b: 2, a: 1 }
+    p.Sum(1) + P { a: 1, b: 2 }.Sum(2)
+    while (P { a: 1, b: 2 }).a < (if true then 1 else 2) {
+        break
+    }
+    var y = !(1 < 2) == false
+
+    y = (let z = 3) + 1
+    for i in 0..10 {}
+    let f = 2.0 * 1.5e3 + 0x1p-2
+    let n = 0xff_ff + 0b1 * 1_000
+    let s = "a\tb" + 'c'
+    let b = Box[Box[int64]] { v: Box[int64] { v: Max[int64](1, 2) } }
+    match B {
+        A(a) => a
+        B => 0
+    }
+    let add = func(x: int64) int64 => x + 1
+    (func() {
+        print("hi")
+    })()
+    let xs = [3]int64 { 1, 2 }
+    xs[0:2][1] + len([]byte {})
+    let n = new P { a: 1 }
+    *(&n.a) = *n.b
+}
+`
+
+	formatted := formatString(t, src)
+	if formatted != expected {
+		t.Fatalf("unexpected output:\n%s", formatted)
+	}
+	if again := formatString(t, formatted); again != formatted {
+		t.Fatalf("formatting isn't stable:\n%s", again)
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	src := `// header
+
+/// Point is a point.
+type Point struct {
+    x: int64 // the x
+    y: int64
+}
+/* a block comment
+   /* nested */
+*/
+func add(a: int64, b: int64) int64 => a + b // trailing
+func main() {
+    // leading
+    let p = Point { x: 1, y: 2 }
+
+
+    var total = add(p.x, p.y)
+    while total > 2 {
+        total = total - 1
+        // end of loop
+    }
+    // end of main
+}
+// the end
+`
+	expected := `// header
+
+/// Point is a point.
+type Point struct {
+    x: int64 // the x
+    y: int64
+}
+
+/* a block comment
+   /* nested */
+*/
+func add(a: int64, b: int64) int64 => a + b // trailing
+
+func main() {
+    // leading
+    let p = Point { x: 1, y: 2 }
+
+    var total = add(p.x, p.y)
+    while total > 2 {
+        total = total - 1
+        // end of loop
+    }
+    // end of main
+}
+// the end
+`
+
+	formatted := formatString(t, src)
+	if formatted != expected {
+		t.Fatalf("unexpected output:\n%s", formatted)
+	}
+	if again := formatString(t, formatted); again != formatted {
+		t.Fatalf("formatting isn't stable:\n%s", again)
+	}
+
+	ast := parseFile("test", []byte(src), &Diagnostics{})
+	if doc := ast.Toplevels[0].(TypeDeclaration).Doc; doc != "Point is a point." {
+		t.Errorf("wrong doc comment %q", doc)
+	}
+}
+
+func TestFormatNestedTypes(t *testing.T) {
+	sources := []string{
+		`type S =
+    | Rect of struct {
+        w: int64
+        h: int64
+    }
+    | Circle of int64
+
+    | Empty
+`,
+		`type P struct {
+    a: struct {
+        x: int64
+        y: int64
+    }
+    b: int64
+
+    c: struct {
+        z: int64
+    } // trailing
+    d: int64
+}
+`,
+	}
+
+	for _, src := range sources {
+		if formatted := formatString(t, src); formatted != src {
+			t.Errorf("formatting %q changed it to:\n%s", src, formatted)
+		}
+	}
+}
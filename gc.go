@@ -0,0 +1,456 @@
+package main
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// how programs manage the memory they allocate on the heap. manually managed
+// memory is released with free, while programs built with a collector leave
+// it to a conservative mark-and-sweep collector: every word on the stack or in
+// an object that's still reachable keeps the object it points into alive.
+const (
+	memoryManual = "manual"
+	memoryGC     = "gc"
+)
+
+const (
+	// gcHeaderSize is the size of the header in front of every object the
+	// collector allocates, which keeps objects 16 byte aligned.
+	gcHeaderSize = 32
+	// gcThreshold is how many bytes are allocated before the first
+	// collection. later ones happen once the heap has grown to twice what
+	// the last one left alive.
+	gcThreshold = 1 << 20
+)
+
+// collector is the runtime of the garbage collector, which is generated into
+// programs built with one.
+type collector struct {
+	module *ir.Module
+
+	// object is the header of objects, which are kept in a list:
+	// { next object, size, marked, next gray object }
+	object *types.StructType
+
+	// objects is the list of every object, gray the ones that have been
+	// marked but not scanned yet, and low and high bound the addresses
+	// objects have been allocated at.
+	objects, gray, low, high *ir.Global
+	// allocated is the size of the heap, and count how many objects are in
+	// it.
+	allocated, threshold, count *ir.Global
+	// table holds the objects ordered by address while collecting, so that
+	// the object an address points into can be searched for.
+	table *ir.Global
+
+	// stackBottom is where scanning the stack stops, which is set by the
+	// entry point before main is called.
+	stackBottom *ir.Global
+
+	zalloc, free *ir.Func
+	sift, sort   *ir.Func
+	find, scan   *ir.Func
+	collect      *ir.Func
+	alloc        *ir.Func
+}
+
+// declareCollector declares the function libraries built with a collector
+// allocate through, which the program they're linked into defines.
+func declareCollector(m *ir.Module) *ir.Func {
+	return m.NewFunc("tawa.gc.alloc", types.I8Ptr, ir.NewParam("size", types.I64))
+}
+
+// addCollector generates the runtime of the collector.
+func addCollector(m *ir.Module) *collector {
+	gc := &collector{module: m}
+
+	gc.object = &types.StructType{TypeName: "tawa.gc.object"}
+	ptr := types.NewPointer(gc.object)
+	gc.object.Fields = []types.Type{ptr, types.I64, types.I64, ptr}
+	m.TypeDefs = append(m.TypeDefs, gc.object)
+
+	global := func(name string, init constant.Constant) *ir.Global {
+		g := m.NewGlobalDef(name, init)
+		g.Linkage = enum.LinkageInternal
+		return g
+	}
+	gc.objects = global("tawa.gc.objects", constant.NewNull(ptr))
+	gc.gray = global("tawa.gc.gray", constant.NewNull(ptr))
+	gc.low = global("tawa.gc.low", constant.NewInt(types.I64, -1))
+	gc.high = global("tawa.gc.high", constant.NewInt(types.I64, 0))
+	gc.allocated = global("tawa.gc.allocated", constant.NewInt(types.I64, 0))
+	gc.threshold = global("tawa.gc.threshold", constant.NewInt(types.I64, gcThreshold))
+	gc.count = global("tawa.gc.count", constant.NewInt(types.I64, 0))
+	gc.table = global("tawa.gc.table", constant.NewNull(types.NewPointer(ptr)))
+	gc.stackBottom = global("tawa.gc.stackBottom", constant.NewNull(types.I8Ptr))
+
+	gc.zalloc = m.NewFunc("mi_zalloc", types.I8Ptr, ir.NewParam("size", types.I64))
+	gc.free = m.NewFunc("mi_free", types.Void, ir.NewParam("p", types.I8Ptr))
+
+	gc.addSift()
+	gc.addSort()
+	gc.addFind()
+	gc.addScan()
+	gc.addCollect()
+	gc.addAlloc()
+
+	return gc
+}
+
+// field returns a pointer to a field of the header of obj.
+func (gc *collector) field(b *ir.Block, obj value.Value, idx int64) value.Value {
+	return getStructElm(b, gc.object, obj, idx)
+}
+
+// payload returns the address of the object behind a header.
+func (gc *collector) payload(b *ir.Block, obj value.Value) value.Value {
+	return b.NewAdd(b.NewPtrToInt(obj, types.I64), constant.NewInt(types.I64, gcHeaderSize))
+}
+
+// start returns the address of the object behind the header at idx in the
+// table, which it's ordered by.
+func (gc *collector) start(b *ir.Block, idx value.Value) value.Value {
+	ptr := types.NewPointer(gc.object)
+	table := b.NewLoad(types.NewPointer(ptr), gc.table)
+	return gc.payload(b, b.NewLoad(ptr, b.NewGetElementPtr(ptr, table, idx)))
+}
+
+// swap swaps two objects in the table.
+func (gc *collector) swap(b *ir.Block, i, j value.Value) {
+	ptr := types.NewPointer(gc.object)
+	table := b.NewLoad(types.NewPointer(ptr), gc.table)
+	at, to := b.NewGetElementPtr(ptr, table, i), b.NewGetElementPtr(ptr, table, j)
+	a, z := b.NewLoad(ptr, at), b.NewLoad(ptr, to)
+	b.NewStore(z, at)
+	b.NewStore(a, to)
+}
+
+// addSift adds the function moving the object at root of the first n objects
+// of the table down the heap they're in, until it's after both of its
+// children.
+func (gc *collector) addSift() {
+	root, n := ir.NewParam("root", types.I64), ir.NewParam("n", types.I64)
+	fn := gc.module.NewFunc("tawa.gc.sift", types.Void, root, n)
+	fn.Linkage = enum.LinkageInternal
+
+	entry := fn.NewBlock("entry")
+	head := fn.NewBlock("head")
+	right := fn.NewBlock("right")
+	compare := fn.NewBlock("compare")
+	swap := fn.NewBlock("swap")
+	done := fn.NewBlock("done")
+
+	one := constant.NewInt(types.I64, 1)
+	at := entry.NewAlloca(types.I64)
+	entry.NewStore(root, at)
+	entry.NewBr(head)
+
+	parent := head.NewLoad(types.I64, at)
+	left := head.NewAdd(head.NewMul(parent, constant.NewInt(types.I64, 2)), one)
+	head.NewCondBr(head.NewICmp(enum.IPredSLT, left, n), right, done)
+
+	// the child with the higher address is the one moved up
+	other := right.NewAdd(left, one)
+	hasOther := right.NewICmp(enum.IPredSLT, other, n)
+	right.NewCondBr(hasOther, compare, swap)
+
+	higher := compare.NewICmp(enum.IPredUGT, gc.start(compare, other), gc.start(compare, left))
+	pick := compare.NewSelect(higher, other, left)
+	compare.NewBr(swap)
+
+	child := swap.NewPhi(ir.NewIncoming(left, right), ir.NewIncoming(pick, compare))
+	below := swap.NewICmp(enum.IPredUGE, gc.start(swap, parent), gc.start(swap, child))
+	moved := fn.NewBlock("moved")
+	swap.NewCondBr(below, done, moved)
+
+	gc.swap(moved, parent, child)
+	moved.NewStore(child, at)
+	moved.NewBr(head)
+
+	done.NewRet(nil)
+
+	gc.sift = fn
+}
+
+// addSort adds the function filling the table with every object, ordered by
+// address with a heap sort.
+func (gc *collector) addSort() {
+	ptr := types.NewPointer(gc.object)
+	fn := gc.module.NewFunc("tawa.gc.sort", types.Void)
+	fn.Linkage = enum.LinkageInternal
+
+	entry := fn.NewBlock("entry")
+	fillHead := fn.NewBlock("fill.head")
+	fillBody := fn.NewBlock("fill.body")
+	heapHead := fn.NewBlock("heap.head")
+	heapBody := fn.NewBlock("heap.body")
+	sortHead := fn.NewBlock("sort.head")
+	sortBody := fn.NewBlock("sort.body")
+	done := fn.NewBlock("done")
+
+	zero, one := constant.NewInt(types.I64, 0), constant.NewInt(types.I64, 1)
+	cursor := entry.NewAlloca(ptr)
+	idx := entry.NewAlloca(types.I64)
+
+	n := entry.NewLoad(types.I64, gc.count)
+	half := entry.NewSDiv(n, constant.NewInt(types.I64, 2))
+	table := entry.NewCall(gc.zalloc, entry.NewMul(n, constant.NewInt(types.I64, 8)))
+	entry.NewStore(entry.NewBitCast(table, types.NewPointer(ptr)), gc.table)
+	entry.NewStore(entry.NewLoad(ptr, gc.objects), cursor)
+	entry.NewStore(zero, idx)
+	entry.NewBr(fillHead)
+
+	obj := fillHead.NewLoad(ptr, cursor)
+	fillHead.NewCondBr(fillHead.NewICmp(enum.IPredEQ, obj, constant.NewNull(ptr)), heapHead, fillBody)
+
+	at := fillBody.NewLoad(types.I64, idx)
+	fillBody.NewStore(obj, fillBody.NewGetElementPtr(ptr, fillBody.NewLoad(types.NewPointer(ptr), gc.table), at))
+	fillBody.NewStore(fillBody.NewAdd(at, one), idx)
+	fillBody.NewStore(fillBody.NewLoad(ptr, gc.field(fillBody, obj, 0)), cursor)
+	fillBody.NewBr(fillHead)
+
+	// the objects are made into a heap from the last one with children up
+	// to the root, whose object is then swapped to the end of what's left
+	// of the heap until it's sorted
+	heapRoot := heapHead.NewPhi(ir.NewIncoming(half, fillHead))
+	heapHead.NewCondBr(heapHead.NewICmp(enum.IPredSGT, heapRoot, zero), heapBody, sortHead)
+
+	root := heapBody.NewSub(heapRoot, one)
+	heapBody.NewCall(gc.sift, root, n)
+	heapBody.NewBr(heapHead)
+	heapRoot.Incs = append(heapRoot.Incs, ir.NewIncoming(root, heapBody))
+
+	end := sortHead.NewPhi(ir.NewIncoming(n, heapHead))
+	sortHead.NewCondBr(sortHead.NewICmp(enum.IPredSGT, end, one), sortBody, done)
+
+	last := sortBody.NewSub(end, one)
+	gc.swap(sortBody, zero, last)
+	sortBody.NewCall(gc.sift, zero, last)
+	sortBody.NewBr(sortHead)
+	end.Incs = append(end.Incs, ir.NewIncoming(last, sortBody))
+
+	done.NewRet(nil)
+
+	gc.sort = fn
+}
+
+// addFind adds the function returning the header of the object an address
+// points into, or null if it doesn't point into one. it searches the table
+// for the last object starting at or before the address.
+func (gc *collector) addFind() {
+	ptr := types.NewPointer(gc.object)
+	addr := ir.NewParam("addr", types.I64)
+	fn := gc.module.NewFunc("tawa.gc.find", ptr, addr)
+	fn.Linkage = enum.LinkageInternal
+
+	entry := fn.NewBlock("entry")
+	head := fn.NewBlock("head")
+	body := fn.NewBlock("body")
+	check := fn.NewBlock("check")
+	found := fn.NewBlock("found")
+	notFound := fn.NewBlock("notfound")
+
+	zero, one := constant.NewInt(types.I64, 0), constant.NewInt(types.I64, 1)
+	above := entry.NewICmp(enum.IPredUGE, addr, entry.NewLoad(types.I64, gc.low))
+	below := entry.NewICmp(enum.IPredULE, addr, entry.NewLoad(types.I64, gc.high))
+	n := entry.NewLoad(types.I64, gc.count)
+	entry.NewCondBr(entry.NewAnd(above, below), head, notFound)
+
+	// lo ends up as the first object starting after the address
+	lo := head.NewPhi(ir.NewIncoming(zero, entry))
+	hi := head.NewPhi(ir.NewIncoming(n, entry))
+	head.NewCondBr(head.NewICmp(enum.IPredSLT, lo, hi), body, check)
+
+	mid := body.NewSDiv(body.NewAdd(lo, hi), constant.NewInt(types.I64, 2))
+	before := body.NewICmp(enum.IPredULE, gc.start(body, mid), addr)
+	lo.Incs = append(lo.Incs, ir.NewIncoming(body.NewSelect(before, body.NewAdd(mid, one), lo), body))
+	hi.Incs = append(hi.Incs, ir.NewIncoming(body.NewSelect(before, hi, mid), body))
+	body.NewBr(head)
+
+	check.NewCondBr(check.NewICmp(enum.IPredEQ, lo, zero), notFound, found)
+
+	// an address just past the end of an object still counts, like the
+	// data of an empty slice of its end
+	candidate := found.NewSub(lo, one)
+	table := found.NewLoad(types.NewPointer(ptr), gc.table)
+	obj := found.NewLoad(ptr, found.NewGetElementPtr(ptr, table, candidate))
+	end := found.NewAdd(gc.payload(found, obj), found.NewLoad(types.I64, gc.field(found, obj, 1)))
+	inside := fn.NewBlock("inside")
+	found.NewCondBr(found.NewICmp(enum.IPredULE, addr, end), inside, notFound)
+	inside.NewRet(obj)
+
+	notFound.NewRet(constant.NewNull(ptr))
+
+	gc.find = fn
+}
+
+// addScan adds the function marking the objects the words between two
+// addresses point into, which are added to the gray list to be scanned in
+// turn.
+func (gc *collector) addScan() {
+	ptr := types.NewPointer(gc.object)
+	from, to := ir.NewParam("from", types.I64), ir.NewParam("to", types.I64)
+	fn := gc.module.NewFunc("tawa.gc.scan", types.Void, from, to)
+	fn.Linkage = enum.LinkageInternal
+
+	entry := fn.NewBlock("entry")
+	head := fn.NewBlock("head")
+	body := fn.NewBlock("body")
+	check := fn.NewBlock("check")
+	mark := fn.NewBlock("mark")
+	next := fn.NewBlock("next")
+	done := fn.NewBlock("done")
+
+	cursor := entry.NewAlloca(types.I64)
+	entry.NewStore(from, cursor)
+	entry.NewBr(head)
+
+	at := head.NewLoad(types.I64, cursor)
+	word := constant.NewInt(types.I64, 8)
+	head.NewCondBr(head.NewICmp(enum.IPredULE, head.NewAdd(at, word), to), body, done)
+
+	val := body.NewLoad(types.I64, body.NewIntToPtr(at, types.NewPointer(types.I64)))
+	obj := body.NewCall(gc.find, val)
+	body.NewCondBr(body.NewICmp(enum.IPredEQ, obj, constant.NewNull(ptr)), next, check)
+
+	marked := check.NewLoad(types.I64, gc.field(check, obj, 2))
+	check.NewCondBr(check.NewICmp(enum.IPredNE, marked, constant.NewInt(types.I64, 0)), next, mark)
+
+	mark.NewStore(constant.NewInt(types.I64, 1), gc.field(mark, obj, 2))
+	mark.NewStore(mark.NewLoad(ptr, gc.gray), gc.field(mark, obj, 3))
+	mark.NewStore(obj, gc.gray)
+	mark.NewBr(next)
+
+	next.NewStore(next.NewAdd(at, word), cursor)
+	next.NewBr(head)
+
+	done.NewRet(nil)
+
+	gc.scan = fn
+}
+
+// addCollect adds the function collecting garbage. the registers that are
+// preserved across calls are stored on the stack first, so that pointers only
+// held in them are found too.
+func (gc *collector) addCollect() {
+	ptr := types.NewPointer(gc.object)
+	fn := gc.module.NewFunc("tawa.gc.collect", types.Void)
+	fn.Linkage = enum.LinkageInternal
+
+	entry := fn.NewBlock("entry")
+	grayHead := fn.NewBlock("gray.head")
+	grayBody := fn.NewBlock("gray.body")
+	sweepHead := fn.NewBlock("sweep.head")
+	sweepBody := fn.NewBlock("sweep.body")
+	keep := fn.NewBlock("keep")
+	release := fn.NewBlock("release")
+	done := fn.NewBlock("done")
+
+	zero := constant.NewInt(types.I64, 0)
+
+	regs := entry.NewAlloca(types.NewArray(6, types.I64))
+	link := entry.NewAlloca(types.NewPointer(ptr))
+	live := entry.NewAlloca(types.I64)
+
+	spill := ir.NewInlineAsm(
+		types.NewPointer(types.NewFunc(types.Void, regs.Type())),
+		`movq %rbx, 0($0); movq %rbp, 8($0); movq %r12, 16($0); movq %r13, 24($0); movq %r14, 32($0); movq %r15, 40($0)`,
+		`r,~{memory}`,
+	)
+	spill.SideEffect = true
+	entry.NewCall(spill, regs)
+	entry.NewCall(gc.sort)
+
+	// the stack grows down, so everything the functions that called this one
+	// hold is between the registers and the bottom of the stack
+	top := entry.NewPtrToInt(regs, types.I64)
+	bottom := entry.NewPtrToInt(entry.NewLoad(types.I8Ptr, gc.stackBottom), types.I64)
+	entry.NewCall(gc.scan, top, bottom)
+
+	// link points to where the object being swept is linked from, which
+	// objects that are released are unlinked from
+	entry.NewStore(gc.objects, link)
+	entry.NewStore(zero, live)
+	entry.NewBr(grayHead)
+
+	obj := grayHead.NewLoad(ptr, gc.gray)
+	grayHead.NewCondBr(grayHead.NewICmp(enum.IPredEQ, obj, constant.NewNull(ptr)), sweepHead, grayBody)
+
+	grayBody.NewStore(grayBody.NewLoad(ptr, gc.field(grayBody, obj, 3)), gc.gray)
+	start := gc.payload(grayBody, obj)
+	grayBody.NewCall(gc.scan, start, grayBody.NewAdd(start, grayBody.NewLoad(types.I64, gc.field(grayBody, obj, 1))))
+	grayBody.NewBr(grayHead)
+
+	at := sweepHead.NewLoad(types.NewPointer(ptr), link)
+	swept := sweepHead.NewLoad(ptr, at)
+	sweepHead.NewCondBr(sweepHead.NewICmp(enum.IPredEQ, swept, constant.NewNull(ptr)), done, sweepBody)
+
+	marked := sweepBody.NewLoad(types.I64, gc.field(sweepBody, swept, 2))
+	sweepBody.NewCondBr(sweepBody.NewICmp(enum.IPredNE, marked, zero), keep, release)
+
+	keep.NewStore(zero, gc.field(keep, swept, 2))
+	size := keep.NewAdd(keep.NewLoad(types.I64, gc.field(keep, swept, 1)), constant.NewInt(types.I64, gcHeaderSize))
+	keep.NewStore(keep.NewAdd(keep.NewLoad(types.I64, live), size), live)
+	keep.NewStore(gc.field(keep, swept, 0), link)
+	keep.NewBr(sweepHead)
+
+	release.NewStore(release.NewLoad(ptr, gc.field(release, swept, 0)), at)
+	release.NewStore(release.NewSub(release.NewLoad(types.I64, gc.count), constant.NewInt(types.I64, 1)), gc.count)
+	release.NewCall(gc.free, release.NewBitCast(swept, types.I8Ptr))
+	release.NewBr(sweepHead)
+
+	// the next collection happens once the heap has grown to twice what's
+	// still alive
+	done.NewCall(gc.free, done.NewBitCast(done.NewLoad(types.NewPointer(ptr), gc.table), types.I8Ptr))
+	done.NewStore(constant.NewNull(types.NewPointer(ptr)), gc.table)
+	done.NewStore(done.NewLoad(types.I64, live), gc.allocated)
+	twice := done.NewMul(done.NewLoad(types.I64, live), constant.NewInt(types.I64, 2))
+	min := constant.NewInt(types.I64, gcThreshold)
+	done.NewStore(done.NewSelect(done.NewICmp(enum.IPredUGT, twice, min), twice, min), gc.threshold)
+	done.NewRet(nil)
+
+	gc.collect = fn
+}
+
+// addAlloc adds the function programs allocate memory through, which
+// collects garbage first once enough has been allocated since the last
+// collection. the memory is zeroed, so that it doesn't hold stale pointers.
+func (gc *collector) addAlloc() {
+	ptr := types.NewPointer(gc.object)
+	size := ir.NewParam("size", types.I64)
+	fn := gc.module.NewFunc("tawa.gc.alloc", types.I8Ptr, size)
+
+	entry := fn.NewBlock("entry")
+	collect := fn.NewBlock("collect")
+	alloc := fn.NewBlock("alloc")
+
+	total := entry.NewAdd(entry.NewLoad(types.I64, gc.allocated), entry.NewAdd(size, constant.NewInt(types.I64, gcHeaderSize)))
+	entry.NewCondBr(entry.NewICmp(enum.IPredUGT, total, entry.NewLoad(types.I64, gc.threshold)), collect, alloc)
+
+	collect.NewCall(gc.collect)
+	collect.NewBr(alloc)
+
+	withHeader := alloc.NewAdd(size, constant.NewInt(types.I64, gcHeaderSize))
+	raw := alloc.NewCall(gc.zalloc, withHeader)
+	obj := alloc.NewBitCast(raw, ptr)
+	alloc.NewStore(alloc.NewLoad(ptr, gc.objects), gc.field(alloc, obj, 0))
+	alloc.NewStore(size, gc.field(alloc, obj, 1))
+	alloc.NewStore(obj, gc.objects)
+	alloc.NewStore(alloc.NewAdd(alloc.NewLoad(types.I64, gc.count), constant.NewInt(types.I64, 1)), gc.count)
+	alloc.NewStore(alloc.NewAdd(alloc.NewLoad(types.I64, gc.allocated), withHeader), gc.allocated)
+
+	start := gc.payload(alloc, obj)
+	end := alloc.NewAdd(start, size)
+	low := alloc.NewLoad(types.I64, gc.low)
+	alloc.NewStore(alloc.NewSelect(alloc.NewICmp(enum.IPredULT, start, low), start, low), gc.low)
+	high := alloc.NewLoad(types.I64, gc.high)
+	alloc.NewStore(alloc.NewSelect(alloc.NewICmp(enum.IPredUGT, end, high), end, high), gc.high)
+
+	alloc.NewRet(alloc.NewGetElementPtr(types.I8, raw, constant.NewInt(types.I64, gcHeaderSize)))
+
+	gc.alloc = fn
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxInstanceDepth is how deeply instances of generics can be nested in each
+// other, which stops generics that instantiate themselves with ever larger
+// type arguments.
+const maxInstanceDepth = 64
+
+// generic is a generic function or type. it's checked again for every list
+// of type arguments it's used with, which makes an instance of it.
+type generic struct {
+	fn     Func
+	decl   TypeDeclaration
+	isFunc bool
+	params []Identifier
+
+	// instances are the symbols of the instances by name, and made the
+	// instances in the order they were made.
+	instances map[string]*symbol
+	made      []TopLevel
+}
+
+// pendingInstance is an instance of a generic function whose body hasn't
+// been checked yet.
+type pendingInstance struct {
+	g     *generic
+	idx   int
+	name  string
+	sig   *funcType
+	scope *scope
+	depth int
+	at    Span
+}
+
+// declareGeneric declares a generic function or type.
+func (c *checker) declareGeneric(tl TopLevel) *generic {
+	g := &generic{instances: map[string]*symbol{}}
+	sym := &symbol{Type: typeInvalid, Generic: g}
+
+	switch decl := tl.(type) {
+	case Func:
+		g.fn, g.isFunc, g.params = decl, true, decl.TypeParams
+		sym.Name, sym.Kind, sym.Pos = decl.Ident.Name, symbolFunc, decl.Ident.Pos
+		if decl.Receiver != nil {
+			c.errorf(ErrTypeParameters, decl.Ident.Pos, "methods can't have type parameters")
+			return nil
+		}
+	case TypeDeclaration:
+		g.decl, g.params = decl, decl.TypeParams
+		sym.Name, sym.Kind, sym.Pos = decl.Ident.Name, symbolType, decl.Ident.Pos
+		if _, ok := decl.Kind.(Struct); !ok {
+			c.errorf(ErrTypeParameters, decl.Ident.Pos, "only struct types can have type parameters")
+			sym.Generic = nil
+			c.declareTop(sym)
+			return nil
+		}
+	}
+
+	for i, param := range g.params {
+		for _, prev := range g.params[:i] {
+			if prev.Name == param.Name {
+				c.errorf(ErrDuplicateField, param.Pos, "type parameter %s specified more than once", param.Name)
+			}
+		}
+	}
+	c.declareTop(sym)
+	return g
+}
+
+// instanceName is the name of the instance of a generic function or type with
+// the given type arguments, like Box[int64]. it only depends on what the type
+// arguments are, so exported instances keep their names between builds.
+func instanceName(name string, args []tawaType) string {
+	var names []string
+	for _, arg := range args {
+		names = append(names, arg.String())
+	}
+	return name + "[" + strings.Join(names, ", ") + "]"
+}
+
+// genericScope is the scope instances of g are checked in, where its type
+// parameters are the types they're instantiated with.
+func (c *checker) genericScope(g *generic, args []tawaType) *scope {
+	pos := g.decl.Ident.Pos
+	if g.isFunc {
+		pos = g.fn.Ident.Pos
+	}
+	s := newScope(c.fileScope(pos))
+	for i, param := range g.params {
+		s.symbols[param.Name] = &symbol{Name: param.Name, Kind: symbolType, Type: args[i], Pos: param.Pos}
+	}
+	return s
+}
+
+// typeArguments resolves the type arguments given to the generic sym at name.
+// it returns nil if they're invalid.
+func (c *checker) typeArguments(sym *symbol, name Identifier, args []Type) []tawaType {
+	var kinds []tawaType
+	valid := true
+	for _, arg := range args {
+		kind := c.resolveType(arg)
+		kinds = append(kinds, kind)
+		valid = valid && kind != typeInvalid
+	}
+
+	if want := len(sym.Generic.params); len(args) != want {
+		c.errorf(ErrTypeParameters, name.Pos, "%s takes %d type arguments, not %d", sym.Name, want, len(args))
+		return nil
+	}
+	if !valid {
+		return nil
+	}
+	return kinds
+}
+
+// instantiateType returns the instance of the generic type sym with the given
+// type arguments, making it the first time it's used.
+func (c *checker) instantiateType(sym *symbol, args []tawaType, at Span) tawaType {
+	g := sym.Generic
+	name := instanceName(sym.Name, args)
+	if inst, ok := g.instances[name]; ok {
+		return inst.Type
+	}
+	if c.depth >= maxInstanceDepth {
+		c.errorf(ErrTypeParameters, at, "instantiating %s is nested too deeply", name)
+		return typeInvalid
+	}
+
+	named := &namedType{name: name, origin: sym.Name, args: args}
+	g.instances[name] = &symbol{Name: name, Kind: symbolType, Type: named, Pos: sym.Pos}
+
+	scope := c.scope
+	c.scope = c.genericScope(g, args)
+	c.depth++
+	named.underlying = c.resolveType(g.decl.Kind)
+	c.depth--
+	c.scope = scope
+
+	if containsByValue(named.underlying, named, map[*namedType]bool{}) {
+		c.errorf(ErrRecursiveType, at, "invalid recursive type %s", named)
+		named.underlying = typeInvalid
+	}
+
+	decl := g.decl
+	decl.Ident.Name = name
+	decl.TypeParams = nil
+	decl.Kind = resolvedType(decl.Kind, named.underlying, g.params)
+	g.made = append(g.made, decl)
+	return named
+}
+
+// instantiateFunc returns the instance of the generic function sym with the
+// given type arguments. its body is checked once every other function has
+// been, by checkInstances.
+func (c *checker) instantiateFunc(sym *symbol, args []tawaType, at Span) *symbol {
+	g := sym.Generic
+	name := instanceName(sym.Name, args)
+	if inst, ok := g.instances[name]; ok {
+		return inst
+	}
+
+	inst := &symbol{Name: name, Kind: symbolFunc, Type: typeInvalid, Pos: sym.Pos}
+	g.instances[name] = inst
+	if c.depth >= maxInstanceDepth {
+		c.errorf(ErrTypeParameters, at, "instantiating %s is nested too deeply", name)
+		return inst
+	}
+
+	scope := c.scope
+	c.scope = c.genericScope(g, args)
+	sig := c.signature(g.fn.Arguments, g.fn.Returns)
+	c.pending = append(c.pending, pendingInstance{g, len(g.made), name, sig, c.scope, c.depth + 1, at})
+	g.made = append(g.made, nil)
+	c.scope = scope
+
+	inst.Type = sig
+	return inst
+}
+
+// checkInstances checks the bodies of the instances of generic functions,
+// including the ones made while doing so.
+func (c *checker) checkInstances() {
+	for len(c.pending) > 0 {
+		inst := c.pending[0]
+		c.pending = c.pending[1:]
+
+		fn := inst.g.fn
+		fn.Ident.Name = inst.name
+		fn.TypeParams = nil
+
+		reported := len(c.diags.list)
+		c.scope, c.depth, c.typeParams = inst.scope, inst.depth, inst.g.params
+		fn = c.checkFunc(fn, inst.sig, nil)
+		c.scope, c.depth, c.typeParams = c.pkg, 0, nil
+
+		// errors in the body of an instance point back at what it was made
+		// for
+		for i := reported; i < len(c.diags.list); i++ {
+			c.diags.list[i].Notes = append(c.diags.list[i].Notes, Note{"in " + inst.name + ", instantiated here", inst.at})
+		}
+
+		fn.Arguments, fn.Returns = resolvedSignature(inst.g.fn.Arguments, inst.g.fn.Returns, inst.sig, inst.g.params)
+		inst.g.made[inst.idx] = fn
+	}
+}
+
+// output returns the declaration of a generic with the instances made of it.
+func (g *generic) output() TopLevel {
+	if g.isFunc {
+		fn := g.fn
+		for _, inst := range g.made {
+			fn.Instances = append(fn.Instances, inst.(Func))
+		}
+		return fn
+	}
+
+	decl := g.decl
+	for _, inst := range g.made {
+		decl.Instances = append(decl.Instances, inst.(TypeDeclaration))
+	}
+	return decl
+}
+
+// unify binds the type parameters in params that t uses, so that t matches
+// kind, the type of an argument. parameters bound by earlier arguments keep
+// their types.
+func unify(t Type, kind tawaType, params []Identifier, bound map[string]tawaType) {
+	if kind == typeInvalid {
+		return
+	}
+
+	switch t := t.(type) {
+	case Ident:
+		for _, param := range params {
+			if param.Name == t.Name && bound[t.Name] == nil {
+				bound[t.Name] = kind
+			}
+		}
+	case Generic:
+		named, ok := kind.(*namedType)
+		if !ok || named.origin != t.Ident.Name || len(named.args) != len(t.Arguments) {
+			return
+		}
+		for i, arg := range t.Arguments {
+			unify(arg, named.args[i], params, bound)
+		}
+	case FunctionPointer:
+		fn, ok := kind.(*funcType)
+		if !ok || len(fn.params) != len(t.Arguments) {
+			return
+		}
+		for i, arg := range t.Arguments {
+			unify(arg, fn.params[i], params, bound)
+		}
+		if t.Returns != nil {
+			unify(*t.Returns, fn.returns, params, bound)
+		}
+	case Struct:
+		st, ok := kind.(*structType)
+		if !ok {
+			return
+		}
+		for _, field := range t {
+			if idx, fieldType := st.field(field.Ident); idx != -1 {
+				unify(field.Kind, fieldType, params, bound)
+			}
+		}
+	case Array:
+		if array, ok := kind.(*arrayType); ok && array.length == t.Length {
+			unify(t.Elem, array.elem, params, bound)
+		}
+	case Slice:
+		if slice, ok := kind.(*sliceType); ok {
+			unify(t.Elem, slice.elem, params, bound)
+		}
+	case Pointer:
+		if ptr, ok := kind.(*pointerType); ok {
+			unify(t.Elem, ptr.elem, params, bound)
+		}
+	}
+}
+
+// genericCall checks a call of a generic function. type arguments that aren't
+// given are inferred from the types of the arguments.
+func (c *checker) genericCall(expr Call, sym *symbol) Typed {
+	g := sym.Generic
+	args := make([]Expression, len(expr.Arguments))
+
+	var typeArgs []tawaType
+	if len(expr.TypeArguments) != 0 {
+		typeArgs = c.typeArguments(sym, expr.Function, expr.TypeArguments)
+	} else {
+		typeArgs = c.infer(expr, g, args)
+	}
+
+	var params []tawaType
+	inst := &symbol{Name: sym.Name, Type: typeInvalid}
+	if typeArgs != nil {
+		inst = c.instantiateFunc(sym, typeArgs, expr.Function.Pos)
+	}
+	fn, ok := inst.Type.(*funcType)
+	if ok && len(fn.params) == len(args) {
+		params = fn.params
+	}
+
+	for i, arg := range expr.Arguments {
+		switch {
+		case args[i] != nil && params != nil:
+			args[i] = c.toInterface(args[i].(Typed), params[i])
+		case args[i] != nil:
+		case params != nil:
+			args[i] = c.exprExpecting(arg, params[i])
+		default:
+			args[i] = c.expr(arg)
+		}
+	}
+
+	call := Call{Identifier{inst.Name, expr.Function.Pos}, expr.TypeArguments, args, expr.Pos}
+	if !ok {
+		return Typed{call, typeInvalid}
+	}
+	c.checkArguments("function", expr.Function, args, fn, sym.Pos)
+	return Typed{call, fn.returns}
+}
+
+// infer works out the type arguments of a call of the generic g from its
+// arguments, checking the ones it looks at into args. it returns nil if some
+// type parameter isn't used by any argument.
+func (c *checker) infer(expr Call, g *generic, args []Expression) []tawaType {
+	params := g.fn.Arguments
+	bound := map[string]tawaType{}
+
+	// untyped constants are looked at last, so that they can take the type
+	// other arguments give a type parameter
+	for i, arg := range expr.Arguments {
+		if _, ok := constantValue(arg); ok {
+			continue
+		}
+		typed := c.expr(arg)
+		args[i] = typed
+		if i < len(params) {
+			unify(params[i].Kind, typed.Kind, g.params, bound)
+		}
+	}
+	for i, arg := range expr.Arguments {
+		if args[i] != nil || i >= len(params) {
+			continue
+		}
+		val, _ := constantValue(arg)
+		unify(params[i].Kind, constantDefault(val), g.params, bound)
+	}
+
+	var typeArgs []tawaType
+	for _, param := range g.params {
+		if bound[param.Name] == nil {
+			c.diags.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     ErrCannotInfer,
+				Location: expr.Function.Pos,
+				Message:  fmt.Sprintf("cannot infer type parameter %s of %s", param.Name, expr.Function.Name),
+				Notes:    []Note{{"declared here", param.Pos}},
+				Hints:    []string{fmt.Sprintf("pass the type arguments explicitly, like %s[...](...)", expr.Function.Name)},
+			})
+			return nil
+		}
+		typeArgs = append(typeArgs, bound[param.Name])
+	}
+	return typeArgs
+}
+
+// exportedInstance looks up an instance of a generic function that a library
+// exports. only its instances are exported, so they're declared by name.
+func (c *checker) exportedInstance(name Identifier, typeArgs []Type) string {
+	var args []tawaType
+	for _, arg := range typeArgs {
+		args = append(args, c.resolveType(arg))
+	}
+
+	inst := instanceName(name.Name, args)
+	if c.scope.lookup(inst) == nil && c.scope.lookup(name.Name) != nil {
+		c.errorf(ErrTypeParameters, name.Pos, "%s is not generic", name.Name)
+	}
+	return inst
+}
+
+// typeExpr returns how the resolved type kind is written, at the position at.
+func typeExpr(kind tawaType, at Span) Type {
+	switch kind := kind.(type) {
+	case *funcType:
+		f := FunctionPointer{}
+		for _, param := range kind.params {
+			f.Arguments = append(f.Arguments, typeExpr(param, at))
+		}
+		if kind.returns != typeNiets {
+			ret := typeExpr(kind.returns, at)
+			f.Returns = &ret
+		}
+		return f
+	case *structType:
+		s := Struct{}
+		for _, field := range kind.fields {
+			s = append(s, struct {
+				Ident string
+				Kind  Type
+				Pos   Span
+			}{field.Name, typeExpr(field.Kind, at), at})
+		}
+		return s
+	case *arrayType:
+		return Array{kind.length, typeExpr(kind.elem, at)}
+	case *sliceType:
+		return Slice{typeExpr(kind.elem, at)}
+	case *pointerType:
+		return Pointer{typeExpr(kind.elem, at)}
+	}
+
+	return Ident{kind.String(), at}
+}
+
+// resolvedType rewrites t, which resolved to kind, into a type that codegen
+// can generate without resolving it again: uses of generic types become the
+// names of their instances, and the type parameters in params the types
+// they're instantiated with.
+func resolvedType(t Type, kind tawaType, params []Identifier) Type {
+	if kind == typeInvalid {
+		return t
+	}
+
+	switch t := t.(type) {
+	case Ident:
+		// names from other packages are written with what the package is
+		// imported as, but declared by their qualified names
+		if strings.Contains(t.Name, ".") {
+			return Ident{kind.String(), t.Pos}
+		}
+		for _, param := range params {
+			if param.Name == t.Name {
+				return typeExpr(kind, t.Pos)
+			}
+		}
+	case Generic:
+		return Ident{kind.String(), t.Ident.Pos}
+	case FunctionPointer:
+		fn, ok := kind.(*funcType)
+		if !ok || len(fn.params) != len(t.Arguments) {
+			return t
+		}
+		f := FunctionPointer{}
+		for i, arg := range t.Arguments {
+			f.Arguments = append(f.Arguments, resolvedType(arg, fn.params[i], params))
+		}
+		if t.Returns != nil {
+			ret := resolvedType(*t.Returns, fn.returns, params)
+			f.Returns = &ret
+		}
+		return f
+	case Struct:
+		st, ok := kind.(*structType)
+		if !ok {
+			return t
+		}
+		s := Struct{}
+		for _, field := range t {
+			if idx, fieldType := st.field(field.Ident); idx != -1 {
+				field.Kind = resolvedType(field.Kind, fieldType, params)
+			}
+			s = append(s, field)
+		}
+		return s
+	case Sum:
+		sum, ok := kind.(*sumType)
+		if !ok {
+			return t
+		}
+		s := Sum{}
+		for _, variant := range t {
+			if _, payload := sum.variant(variant.Ident.Name); variant.Payload != nil && payload != nil {
+				resolved := resolvedType(*variant.Payload, payload, params)
+				variant.Payload = &resolved
+			}
+			s = append(s, variant)
+		}
+		return s
+	case Interface:
+		iface, ok := kind.(*interfaceType)
+		if !ok {
+			return t
+		}
+		i := Interface{}
+		for _, method := range t {
+			if _, sig := iface.method(method.Ident.Name); sig != nil {
+				method.Arguments, method.Returns = resolvedSignature(method.Arguments, method.Returns, sig, params)
+			}
+			i = append(i, method)
+		}
+		return i
+	case Array:
+		array, ok := kind.(*arrayType)
+		if !ok {
+			return t
+		}
+		return Array{t.Length, resolvedType(t.Elem, array.elem, params)}
+	case Slice:
+		slice, ok := kind.(*sliceType)
+		if !ok {
+			return t
+		}
+		return Slice{resolvedType(t.Elem, slice.elem, params)}
+	case Pointer:
+		ptr, ok := kind.(*pointerType)
+		if !ok {
+			return t
+		}
+		return Pointer{resolvedType(t.Elem, ptr.elem, params)}
+	}
+
+	return t
+}
+
+// resolvedSignature rewrites the parameters and return type of a function or
+// method like resolvedType does.
+func resolvedSignature(args []struct {
+	Ident Identifier
+	Kind  Type
+}, returns *Type, sig *funcType, params []Identifier) ([]struct {
+	Ident Identifier
+	Kind  Type
+}, *Type) {
+	if len(args) != len(sig.params) {
+		return args, returns
+	}
+
+	var resolved []struct {
+		Ident Identifier
+		Kind  Type
+	}
+	for i, arg := range args {
+		arg.Kind = resolvedType(arg.Kind, sig.params[i], params)
+		resolved = append(resolved, arg)
+	}
+	if returns != nil {
+		ret := resolvedType(*returns, sig.returns, params)
+		returns = &ret
+	}
+	return resolved, returns
+}
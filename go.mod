@@ -4,9 +4,7 @@ go 1.14
 
 require (
 	github.com/alecthomas/repr v0.0.0-20201120212035-bb82daffcca2
-	github.com/coreos/pkg v0.0.0-20180928190104-399ea9e2e55f
 	github.com/llir/llvm v0.3.2
 	github.com/urfave/cli/v2 v2.3.0
-	github.com/ztrue/tracerr v0.3.0
 	gopkg.in/yaml.v2 v2.2.3
 )
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pontaoski/tawago/reader"
+	"gopkg.in/yaml.v2"
+)
+
+// libraryExtension is the suffix of the shared libraries built from packages,
+// and staticLibraryExtension the one of the archives they're built into with
+// --library=static.
+const (
+	libraryExtension       = ".Dynamically Linked Tawa Module"
+	staticLibraryExtension = ".Statically Linked Tawa Module"
+)
+
+type tawaModule struct {
+	Package string `yaml:"Package"`
+}
+
+// readModule reads the Tawa Module Information of the package in dir.
+func readModule(dir string) (doc tawaModule, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Tawa Module Information"))
+	if err != nil {
+		return doc, err
+	}
+
+	err = yaml.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// findLibrary returns the built library of the package an import refers to.
+// paths starting with . are relative to the importing package. other ones are
+// looked for in each directory of the search path, and then next to the
+// importing package, which is how packages in the same workspace find each
+// other. a package is found either by its directory or by its name.
+func findLibrary(path string, sets settings) (string, error) {
+	if strings.HasPrefix(path, ".") || filepath.IsAbs(path) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(sets.dir, path)
+		}
+		return libraryIn(path)
+	}
+
+	workspace, err := filepath.Abs(sets.dir)
+	if err != nil {
+		return "", err
+	}
+	roots := append(append([]string{}, sets.importPaths...), filepath.Dir(workspace))
+
+	for _, root := range roots {
+		candidates := []string{
+			filepath.Join(root, path),
+			filepath.Join(root, path+libraryExtension),
+			filepath.Join(root, path+staticLibraryExtension),
+		}
+		for _, candidate := range candidates {
+			if _, err := os.Stat(candidate); err == nil {
+				return libraryIn(candidate)
+			}
+		}
+
+		fis, err := ioutil.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if !fi.IsDir() {
+				continue
+			}
+			dir := filepath.Join(root, fi.Name())
+			if doc, err := readModule(dir); err == nil && doc.Package == path {
+				return libraryIn(dir)
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no package named %s was found", path)
+}
+
+// libraryIn returns the library built from the package in dir, or dir itself
+// if it's a library already. a package that has been built both as a shared
+// and a static library can't be imported by its directory or name, since
+// either could be meant.
+func libraryIn(dir string) (string, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return dir, nil
+	}
+
+	doc, err := readModule(dir)
+	if err != nil {
+		return "", err
+	}
+	var built []string
+	for _, ext := range []string{libraryExtension, staticLibraryExtension} {
+		path := filepath.Join(dir, doc.Package+ext)
+		if _, err := os.Stat(path); err == nil {
+			built = append(built, path)
+		}
+	}
+	switch len(built) {
+	case 0:
+		return "", fmt.Errorf("package %s in %s hasn't been built, build it with tawago build --library", doc.Package, dir)
+	case 2:
+		return "", fmt.Errorf("package %s in %s has been built both as a shared and a static library, remove the one that shouldn't be used or import it by its path", doc.Package, dir)
+	}
+	return built[0], nil
+}
+
+// importedLibraries lists the libraries a checked package imports, each once.
+func importedLibraries(tls []TopLevel) []string {
+	var libs []string
+	for _, tl := range tls {
+		if imp, ok := tl.(Import); ok && imp.Library != "" && !containsString(libs, imp.Library) {
+			libs = append(libs, imp.Library)
+		}
+	}
+	return libs
+}
+
+// linkedLibraries returns what a program or shared library importing libs is
+// linked with. static libraries are followed by the libraries they import
+// themselves, since they aren't linked with them when they're built, and come
+// before them, since linkers only take what's needed from an archive from the
+// ones after what needs it. imports of static libraries are looked for like
+// the ones of the package they're built from, which is next to them.
+func linkedLibraries(libs []string, sets settings) ([]string, error) {
+	var linked []string
+	seen := map[string]bool{}
+	var link func(lib string) error
+	link = func(lib string) error {
+		// the same library can be found from different directories
+		key := lib
+		if abs, err := filepath.Abs(lib); err == nil {
+			key = abs
+		}
+		if seen[key] {
+			return nil
+		}
+		seen[key] = true
+
+		static, err := reader.IsStatic(lib)
+		if err != nil {
+			return fmt.Errorf("cannot link %s: %w", lib, err)
+		}
+		if static {
+			ti, err := getTypeInfoFromFile(lib)
+			if err != nil {
+				return fmt.Errorf("cannot link %s: %w", lib, err)
+			}
+			from := sets
+			from.dir = filepath.Dir(lib)
+			for _, path := range ti.Imports {
+				dep, err := findLibrary(path, from)
+				if err != nil {
+					return fmt.Errorf("cannot link %s, which imports %q: %w", lib, path, err)
+				}
+				if err := link(dep); err != nil {
+					return err
+				}
+			}
+		}
+
+		// reversed once everything is linked, so that it ends up before
+		// what it imports
+		linked = append(linked, lib)
+		return nil
+	}
+
+	for idx := len(libs) - 1; idx >= 0; idx-- {
+		if err := link(libs[idx]); err != nil {
+			return nil, err
+		}
+	}
+	for i, j := 0, len(linked)-1; i < j; i, j = i+1, j-1 {
+		linked[i], linked[j] = linked[j], linked[i]
+	}
+	return linked, nil
+}
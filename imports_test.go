@@ -0,0 +1,236 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// workspace lays out a workspace with the packages app and lib, where lib is
+// the package geo and has been built, and a search path with the built
+// package other. it returns the directories of the workspace and the search
+// path.
+func workspace(t *testing.T) (string, string) {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "tawa-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	ws, search := filepath.Join(root, "ws"), filepath.Join(root, "search")
+	for _, dir := range []string{filepath.Join(ws, "app"), filepath.Join(ws, "lib"), search} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(ws, "lib", "Tawa Module Information"), []byte("Package: geo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeLibrary(t, filepath.Join(ws, "lib", "geo"+libraryExtension), libraryTypeInfo(t, "geo", "func X() int64 => 1\n"))
+	writeLibrary(t, filepath.Join(search, "other"+libraryExtension), libraryTypeInfo(t, "other", "func Y() int64 => 2\n"))
+	return ws, search
+}
+
+// checkFiles checks the files of a package, given as pairs of their names
+// and sources.
+func checkFiles(t *testing.T, sets settings, files ...string) ([]TopLevel, []Diagnostic) {
+	t.Helper()
+
+	diags := &Diagnostics{}
+	var tls []TopLevel
+	for i := 0; i < len(files); i += 2 {
+		p := NewParser(NewLexer(strings.NewReader(files[i+1]), files[i]))
+		if err := p.Parse(); err != nil {
+			t.Fatalf("failed to parse %q: %s", files[i+1], err)
+		}
+		tls = append(tls, p.ast.Toplevels...)
+	}
+	return check(tls, sets, diags), diags.List()
+}
+
+func TestFindLibrary(t *testing.T) {
+	ws, search := workspace(t)
+	sets := settings{dir: filepath.Join(ws, "app"), importPaths: []string{search}}
+	geo := filepath.Join(ws, "lib", "geo"+libraryExtension)
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"../lib", geo},
+		{"./../lib/geo" + libraryExtension, geo},
+		{"other", filepath.Join(search, "other"+libraryExtension)},
+		{"geo", geo},
+		{"lib", geo},
+	}
+	for _, c := range cases {
+		got, err := findLibrary(c.path, sets)
+		if err != nil {
+			t.Errorf("cannot find %q: %s", c.path, err)
+		} else if got != c.want {
+			t.Errorf("expected %q to be found at %s, got %s", c.path, c.want, got)
+		}
+	}
+
+	// the search path comes before the workspace
+	writeLibrary(t, filepath.Join(search, "geo"+libraryExtension), libraryTypeInfo(t, "geo", "func X() int64 => 3\n"))
+	if got, err := findLibrary("geo", sets); err != nil || got != filepath.Join(search, "geo"+libraryExtension) {
+		t.Errorf("expected geo to be found in the search path, got %s, %v", got, err)
+	}
+
+	if _, err := findLibrary("nope", sets); err == nil || !strings.Contains(err.Error(), "no package named nope") {
+		t.Errorf("expected nope not to be found, got %v", err)
+	}
+
+	// either could be meant once a package is built both ways, unless it's
+	// imported by the path of one of them
+	static := filepath.Join(ws, "lib", "geo"+staticLibraryExtension)
+	writeLibrary(t, static, libraryTypeInfo(t, "geo", "func X() int64 => 4\n"))
+	if _, err := findLibrary("../lib", sets); err == nil || !strings.Contains(err.Error(), "built both as a shared and a static library") {
+		t.Errorf("expected a package built both ways to be ambiguous, got %v", err)
+	}
+	if got, err := findLibrary("../lib/geo"+staticLibraryExtension, sets); err != nil || got != static {
+		t.Errorf("expected the static library to be found by its path, got %s, %v", got, err)
+	}
+}
+
+func TestImportsArePerFile(t *testing.T) {
+	ws, search := workspace(t)
+	sets := settings{dir: filepath.Join(ws, "app"), importPaths: []string{search}}
+
+	_, diags := checkFiles(t, sets,
+		"a", "import o `geo`\nfunc a() int64 => o.X()\n",
+		"b", "func b() int64 => o.X()\n",
+	)
+	if len(diags) != 1 || diags[0].Location.From.Filename != "b" || !strings.Contains(diags[0].Message, "undefined: o") {
+		t.Errorf("expected o to only be usable in the file importing it, got %v", diags)
+	}
+}
+
+func TestImportNameConflicts(t *testing.T) {
+	ws, search := workspace(t)
+	sets := settings{dir: filepath.Join(ws, "app"), importPaths: []string{search}}
+
+	for _, decl := range []string{"func geo() int64 => 1\n", "type geo int64\n", "type S = | geo | Other\n"} {
+		_, diags := checkFiles(t, sets, "a", "import `geo`\n", "b", decl)
+		if len(diags) != 1 || !strings.Contains(diags[0].Message, "geo is imported with the name of something declared in the package") {
+			t.Errorf("expected geo to conflict with %q, got %v", decl, diags)
+		}
+	}
+}
+
+func TestImportedLibraries(t *testing.T) {
+	ws, search := workspace(t)
+	sets := settings{dir: filepath.Join(ws, "app"), importPaths: []string{search}}
+
+	tls, diags := checkFiles(t, sets,
+		"a", "import `geo`\nimport `other`\nfunc a() int64 => geo.X() + other.Y()\n",
+		"b", "import g `../lib`\nfunc b() int64 => g.X()\n",
+	)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+
+	want := []string{filepath.Join(ws, "lib", "geo"+libraryExtension), filepath.Join(search, "other"+libraryExtension)}
+	if got := importedLibraries(tls); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected the libraries %v, got %v", want, got)
+	}
+}
+
+// ooflib builds the package ooflib into a temporary search path, which it
+// returns.
+func ooflib(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "tawa-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	writeLibrary(t, filepath.Join(dir, "ooflib"+libraryExtension), libraryTypeInfo(t, "ooflib", `type Pt struct {
+    x: int64
+    y: int64
+}
+type Shape =
+    | Dot of Pt
+    | Empty
+func (s Shape) X() int64 => match s {
+    Dot(p) => p.x
+    Empty => 0
+}
+func Oof(n: int64) int64 => n + 1
+func Max[T](a: T, b: T) T => if a > b then a else b
+func Big() int64 => Max(1, 2)
+`))
+	return dir
+}
+
+func TestLinkedLibraries(t *testing.T) {
+	root, err := ioutil.TempDir("", "tawa-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// geo is static and imports base relative to itself, which the program
+	// imports from elsewhere too
+	ws := filepath.Join(root, "ws")
+	libs := map[string]typeInfo{
+		filepath.Join(ws, "base", "base"+staticLibraryExtension): {Version: typeInfoVersion, Package: "base"},
+		filepath.Join(ws, "geo", "geo"+staticLibraryExtension):   {Version: typeInfoVersion, Package: "geo", Imports: []string{"../base"}},
+		filepath.Join(ws, "shape", "shape"+libraryExtension):     {Version: typeInfoVersion, Package: "shape"},
+	}
+	for path, ti := range libs {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(filepath.Dir(path), "Tawa Module Information"), []byte("Package: "+ti.Package+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		writeLibrary(t, path, ti)
+	}
+
+	geo := filepath.Join(ws, "geo", "geo"+staticLibraryExtension)
+	shape := filepath.Join(ws, "shape", "shape"+libraryExtension)
+	base := ws + "/shape/../base/base" + staticLibraryExtension
+
+	sets := settings{dir: filepath.Join(root, "elsewhere", "app")}
+	got, err := linkedLibraries([]string{geo, shape, base}, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{geo, shape, base}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected to link %v, got %v", want, got)
+	}
+
+	got, err = linkedLibraries([]string{geo}, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{geo, filepath.Join(ws, "base", "base"+staticLibraryExtension)}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected to link %v, got %v", want, got)
+	}
+}
+
+func TestImportSamePackageName(t *testing.T) {
+	ws, search := workspace(t)
+	sets := settings{dir: filepath.Join(ws, "app"), importPaths: []string{search}}
+	writeLibrary(t, filepath.Join(search, "geo2"+libraryExtension), libraryTypeInfo(t, "geo", "func X() int64 => 2\n"))
+
+	_, diags := checkFiles(t, sets, "a", "import a `geo`\nimport b `geo2`\nfunc f() int64 => a.X() + b.X()\n")
+	if len(diags) == 0 || !strings.Contains(diags[0].Message, "a different package named geo is already imported from") {
+		t.Errorf("expected importing two packages named geo to fail, got %v", diags)
+	}
+
+	sets.packageName = "other"
+	_, diags = checkFiles(t, sets, "a", "import o `other`\nfunc f() int64 => o.Y()\n")
+	if len(diags) == 0 || !strings.Contains(diags[0].Message, "it's built from a package named other too") {
+		t.Errorf("expected importing a package named like the one importing it to fail, got %v", diags)
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenKind int
@@ -18,20 +21,47 @@ const (
 	RPAREN
 	LBRACKET
 	RBRACKET
+	LSQUARE
+	RSQUARE
 	COMMA
 	EQUALS
 	FATARROW
 	PERIOD
 
+	PLUS
+	MINUS
+	STAR
+	SLASH
+	PERCENT
+
+	DOUBLEEQUALS
+	NOTEQUALS
+	LESS
+	LESSEQUALS
+	GREATER
+	GREATEREQUALS
+
+	ANDAND
+	OROR
+	BANG
+
+	AMPERSAND
+	PIPE
+	CARET
+	SHIFTLEFT
+	SHIFTRIGHT
+
 	VAR
 	LET
 
 	EOS
 
 	INT
+	FLOAT
 
 	IDENT
 	STRING
+	CHAR
 
 	TYPE
 	IF
@@ -40,6 +70,17 @@ const (
 	FUNC
 	STRUCT
 	IMPORT
+	WHILE
+	FOR
+	IN
+	BREAK
+	CONTINUE
+	MATCH
+	OF
+	INTERFACE
+	NEW
+
+	DOTDOT
 )
 
 func (t TokenKind) String() string {
@@ -50,28 +91,147 @@ func (t TokenKind) String() string {
 		LPAREN:   "LPAREN",
 		RPAREN:   "RPAREN",
 		LBRACKET: "LBRACKET",
+		LSQUARE:  "LSQUARE",
+		RSQUARE:  "RSQUARE",
 		RBRACKET: "RBRACKET",
 		COMMA:    "COMMA",
 		EQUALS:   "EQUALS",
 		FATARROW: "FATARROW",
 		PERIOD:   "PERIOD",
-		VAR:      "VAR",
-		LET:      "LET",
-		EOS:      "EOS",
-		INT:      "INT",
-		IDENT:    "IDENT",
-		STRING:   "STRING",
-		TYPE:     "TYPE",
-		IF:       "IF",
-		THEN:     "THEN",
-		ELSE:     "ELSE",
-		FUNC:     "FUNC",
-		STRUCT:   "STRUCT",
-		IMPORT:   "IMPORT",
+
+		PLUS:    "PLUS",
+		MINUS:   "MINUS",
+		STAR:    "STAR",
+		SLASH:   "SLASH",
+		PERCENT: "PERCENT",
+
+		DOUBLEEQUALS:  "DOUBLEEQUALS",
+		NOTEQUALS:     "NOTEQUALS",
+		LESS:          "LESS",
+		LESSEQUALS:    "LESSEQUALS",
+		GREATER:       "GREATER",
+		GREATEREQUALS: "GREATEREQUALS",
+
+		ANDAND: "ANDAND",
+		OROR:   "OROR",
+		BANG:   "BANG",
+
+		AMPERSAND:  "AMPERSAND",
+		PIPE:       "PIPE",
+		CARET:      "CARET",
+		SHIFTLEFT:  "SHIFTLEFT",
+		SHIFTRIGHT: "SHIFTRIGHT",
+		VAR:        "VAR",
+		LET:        "LET",
+		EOS:        "EOS",
+		INT:        "INT",
+		FLOAT:      "FLOAT",
+		IDENT:      "IDENT",
+		STRING:     "STRING",
+		CHAR:       "CHAR",
+		TYPE:       "TYPE",
+		IF:         "IF",
+		THEN:       "THEN",
+		ELSE:       "ELSE",
+		FUNC:       "FUNC",
+		STRUCT:     "STRUCT",
+		IMPORT:     "IMPORT",
+		WHILE:      "WHILE",
+		FOR:        "FOR",
+		IN:         "IN",
+		BREAK:      "BREAK",
+		CONTINUE:   "CONTINUE",
+		MATCH:      "MATCH",
+		OF:         "OF",
+		INTERFACE:  "INTERFACE",
+		NEW:        "NEW",
+		DOTDOT:     "DOTDOT",
 	}
 	return data[t]
 }
 
+// tokenText holds how tokens with fixed spellings are written in source.
+var tokenText = map[TokenKind]string{
+	COLON:    ":",
+	LPAREN:   "(",
+	RPAREN:   ")",
+	LBRACKET: "{",
+	RBRACKET: "}",
+	LSQUARE:  "[",
+	RSQUARE:  "]",
+	COMMA:    ",",
+	EQUALS:   "=",
+	FATARROW: "=>",
+	PERIOD:   ".",
+	DOTDOT:   "..",
+
+	PLUS:          "+",
+	MINUS:         "-",
+	STAR:          "*",
+	SLASH:         "/",
+	PERCENT:       "%",
+	DOUBLEEQUALS:  "==",
+	NOTEQUALS:     "!=",
+	LESS:          "<",
+	LESSEQUALS:    "<=",
+	GREATER:       ">",
+	GREATEREQUALS: ">=",
+	ANDAND:        "&&",
+	OROR:          "||",
+	BANG:          "!",
+	AMPERSAND:     "&",
+	PIPE:          "|",
+	CARET:         "^",
+	SHIFTLEFT:     "<<",
+	SHIFTRIGHT:    ">>",
+
+	VAR:      "var",
+	LET:      "let",
+	TYPE:     "type",
+	IF:       "if",
+	THEN:     "then",
+	ELSE:     "else",
+	FUNC:     "func",
+	STRUCT:   "struct",
+	IMPORT:   "import",
+	WHILE:    "while",
+	FOR:      "for",
+	IN:       "in",
+	BREAK:    "break",
+	CONTINUE: "continue",
+	MATCH:    "match",
+	OF:       "of",
+	NEW:      "new",
+
+	INTERFACE: "interface",
+}
+
+// Describe returns how a token kind is referred to in messages for users.
+func (t TokenKind) Describe() string {
+	switch t {
+	case EOF:
+		return "end of file"
+	case EOS:
+		return "end of statement"
+	case IDENT:
+		return "identifier"
+	case INT:
+		return "integer"
+	case FLOAT:
+		return "floating point number"
+	case STRING:
+		return "string"
+	case CHAR:
+		return "character"
+	case ILLEGAL:
+		return "illegal token"
+	}
+	if text, ok := tokenText[t]; ok {
+		return "`" + text + "`"
+	}
+	return t.String()
+}
+
 type Position struct {
 	Line     int
 	Column   int
@@ -99,11 +259,40 @@ func SingleCharSpan(p Position) Span {
 }
 
 type Lexer struct {
-	pos           Position
-	reader        *bufio.Reader
-	peeked        *Token
-	peekedString  string
-	insertNewline bool
+	pos    Position
+	reader *bufio.Reader
+	diags  *Diagnostics
+
+	// ahead holds the tokens lexed to look ahead that haven't been handed
+	// out yet, the first of which is the peeked one.
+	ahead []lexed
+
+	// lastKind is the kind of the last token lexed, which decides whether a
+	// newline ends a statement.
+	lastKind TokenKind
+
+	comments []Comment
+	// doc holds the lines of the doc comments directly above the next token,
+	// the last of which is on docLine. tokenDoc is the documentation of the
+	// last token lexed.
+	doc      []string
+	docLine  int
+	tokenDoc string
+
+	// lastEnd is where the last token handed out by Lex ended, for giving
+	// the parser the end of the syntax it just finished.
+	lastEnd Position
+
+	// depth is how many braces the tokens handed out by Lex have opened
+	// without closing them, which the parser recovers from errors with.
+	depth int
+}
+
+// Comment is a comment in the source. comments aren't tokens, but are kept
+// around so that tools like the formatter don't lose them.
+type Comment struct {
+	Text string
+	Pos  Span
 }
 
 type Token struct {
@@ -115,6 +304,7 @@ func NewLexer(reader io.Reader, filename string) *Lexer {
 	return &Lexer{
 		pos:    Position{Line: 1, Column: 0, Filename: filename},
 		reader: bufio.NewReader(reader),
+		diags:  &Diagnostics{},
 	}
 }
 
@@ -145,89 +335,411 @@ func (l *Lexer) kinded(t TokenKind) Token {
 }
 
 func firstChar(r rune) bool {
-	return r == '_' || r == '\'' || unicode.IsLetter(r)
+	return r == '_' || unicode.IsLetter(r)
 }
 
 func otherChar(r rune) bool {
-	return r == '/' || firstChar(r) || unicode.IsDigit(r)
+	return r == '\'' || firstChar(r) || unicode.IsDigit(r)
 }
 
 func (l *Lexer) lexIdent() (Position, Position, string) {
-	var lit string
-	var from Position
-	var to Position
-
 	r, _, err := l.reader.ReadRune()
+	if err != nil {
+		panic(err)
+	}
 	l.pos.Column++
-	from = l.pos
+	from, to := l.pos, l.pos
+	lit := string(r)
 
 	for {
+		// a slash can be part of an identifier, but not when it starts a
+		// comment
+		if next, _ := l.reader.Peek(2); string(next) == "//" || string(next) == "/*" {
+			return from, to, lit
+		}
+
+		r, _, err = l.reader.ReadRune()
 		if err != nil {
 			if err == io.EOF {
 				return from, to, lit
 			}
 			panic(err)
 		}
+		l.pos.Column++
 
-		if otherChar(r) {
-			lit += string(r)
-		} else {
+		if !otherChar(r) {
 			l.backup()
-			to = l.pos
 			return from, to, lit
 		}
 
-		r, _, err = l.reader.ReadRune()
-		l.pos.Column++
+		lit += string(r)
 		to = l.pos
 	}
 }
 
-func (l *Lexer) lexString() (Position, Position, string) {
-	var lit string
-	var from Position
-	var to Position
-	seenOpen := false
+// lexString lexes a raw string, after its opening backtick. raw strings have
+// no escapes and can span several lines.
+func (l *Lexer) lexString() (Token, string) {
+	from := l.pos
+	lit := "`"
 
-	r, _, err := l.reader.ReadRune()
-	l.pos.Column++
-	from = l.pos
+	for {
+		r, ok := l.readRune()
+		if !ok {
+			l.diags.Errorf(ErrUnterminatedString, Span{from, l.pos}, "raw string literal is never closed")
+			return Token{STRING, Span{from, l.pos}}, lit + "`"
+		}
+		lit += string(r)
+
+		switch r {
+		case '`':
+			return Token{STRING, Span{from, l.pos}}, lit
+		case '\n':
+			l.pos.Line++
+			l.pos.Column = 0
+		}
+	}
+}
+
+// lexQuoted lexes a string or character literal after its opening quote. the
+// literal is returned as it was written, with its quotes and escapes, and can
+// be decoded with unquote.
+func (l *Lexer) lexQuoted(quote rune) (Token, string) {
+	from := l.pos
+	lit := string(quote)
+	kind, what := STRING, "string"
+	if quote == '\'' {
+		kind, what = CHAR, "character"
+	}
 
 	for {
+		// the newline is left alone, since it ends the statement
+		if next := l.peekByte(0); next == 0 || next == '\n' {
+			l.diags.Errorf(ErrUnterminatedString, Span{from, l.pos}, "%s literal is never closed", what)
+			return Token{kind, Span{from, l.pos}}, lit + string(quote)
+		}
+
+		r, _ := l.readRune()
+		lit += string(r)
+		if r == quote {
+			break
+		}
+		if r == '\\' && l.peekByte(0) != '\n' {
+			if r, ok := l.readRune(); ok {
+				lit += string(r)
+			}
+		}
+	}
+
+	// quoted literals are on one line, so offsets into them are columns
+	value := unquote(lit, func(start, end int, format string, args ...interface{}) {
+		at := Span{from, from}
+		at.From.Column += utf8.RuneCountInString(lit[:start])
+		at.To.Column += utf8.RuneCountInString(lit[:end]) - 1
+		l.diags.Errorf(ErrInvalidLiteral, at, format, args...)
+	})
+	if kind == CHAR {
+		if _, size := utf8.DecodeRuneInString(value); value == "" || size != len(value) && len(value) != 1 {
+			l.diags.Errorf(ErrInvalidLiteral, Span{from, l.pos}, "character literal should contain exactly one character")
+		}
+	}
+
+	return Token{kind, Span{from, l.pos}}, lit
+}
+
+// unquote decodes a string or character literal written as lit, calling report
+// with the byte offsets of any invalid escape sequence. report can be nil if
+// the literal is known to be valid.
+func unquote(lit string, report func(start, end int, format string, args ...interface{})) string {
+	if report == nil {
+		report = func(int, int, string, ...interface{}) {}
+	}
+	if len(lit) < 2 {
+		return ""
+	}
+
+	body := lit[1 : len(lit)-1]
+	if lit[0] == '`' {
+		return body
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(body); {
+		if body[i] != '\\' {
+			b.WriteByte(body[i])
+			i++
+			continue
+		}
+
+		// offsets reported are into lit, which starts with a quote
+		start := i + 1
+		if i+1 == len(body) {
+			report(start, start+1, "escape sequence is never finished")
+			break
+		}
+
+		switch c := body[i+1]; c {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		case '\\', '"', '\'':
+			b.WriteByte(c)
+		case 'x':
+			if i+4 > len(body) || !isHexDigit(body[i+2]) || !isHexDigit(body[i+3]) {
+				report(start, start+2, "\\x should be followed by two hexadecimal digits")
+				break
+			}
+			n, _ := strconv.ParseUint(body[i+2:i+4], 16, 8)
+			b.WriteByte(byte(n))
+			i += 2
+		case 'u':
+			end := strings.IndexByte(body[i:], '}')
+			if i+2 >= len(body) || body[i+2] != '{' || end == -1 {
+				report(start, start+2, "\\u should be followed by a code point in braces, like \\u{1F600}")
+				break
+			}
+			digits := body[i+3 : i+end]
+			n, err := strconv.ParseUint(digits, 16, 32)
+			if err != nil || digits == "" || !utf8.ValidRune(rune(n)) {
+				report(start, start+end+1, "invalid code point %s", body[i:i+end+1])
+			} else {
+				b.WriteRune(rune(n))
+			}
+			i += end - 1
+		default:
+			r, _ := utf8.DecodeRuneInString(body[i+1:])
+			report(start, start+1+utf8.RuneLen(r), "unknown escape sequence \\%c", r)
+			i += utf8.RuneLen(r) - 1
+		}
+		i += 2
+	}
+
+	return b.String()
+}
+
+// lineComment lexes a comment running to the end of the line, after its
+// first slash. the newline ending it is left alone, since it may end a
+// statement.
+func (l *Lexer) lineComment() {
+	from := l.pos
+	text := "/"
+	to := l.pos
+	for {
+		r, _, err := l.reader.ReadRune()
 		if err != nil {
 			if err == io.EOF {
-				return from, to, lit
+				break
 			}
 			panic(err)
 		}
+		if r == '\n' {
+			l.backup()
+			break
+		}
+		l.pos.Column++
+		text += string(r)
+		to = l.pos
+	}
+	l.comments = append(l.comments, Comment{text, Span{from, to}})
+
+	// three slashes start a doc comment, but four or more don't
+	if strings.HasPrefix(text, "///") && !strings.HasPrefix(text, "////") {
+		if l.docLine != from.Line-1 {
+			l.doc = nil
+		}
+		l.doc = append(l.doc, strings.TrimPrefix(strings.TrimPrefix(text, "///"), " "))
+		l.docLine = from.Line
+	}
+}
+
+// blockComment lexes a comment in /* and */, after its first slash. block
+// comments nest. whether the comment spans several lines is returned.
+func (l *Lexer) blockComment() (multiline bool) {
+	from := l.pos
+	l.readRune()
+	text := "/*"
+
+	for depth := 1; depth > 0; {
+		r, ok := l.readRune()
+		if !ok {
+			l.diags.Errorf(ErrUnterminatedComment, SingleCharSpan(from), "block comment is never closed")
+			break
+		}
+		text += string(r)
 
 		switch r {
-		case '`':
-			if seenOpen {
-				to = l.pos
-				return from, to, lit
+		case '\n':
+			l.newline()
+			multiline = true
+		case '/', '*':
+			next, err := l.reader.Peek(1)
+			if err != nil && err != io.EOF {
+				panic(err)
+			}
+			if r == '/' && string(next) == "*" {
+				l.readRune()
+				text += "*"
+				depth++
+			} else if r == '*' && string(next) == "/" {
+				l.readRune()
+				text += "/"
+				depth--
 			}
-			seenOpen = true
-		default:
-			lit += string(r)
 		}
+	}
 
-		r, _, err = l.reader.ReadRune()
-		l.pos.Column++
-		to = l.pos
+	l.comments = append(l.comments, Comment{text, Span{from, l.pos}})
+	return multiline
+}
+
+// readRune reads a rune, keeping track of the column. false is returned at
+// the end of the input.
+func (l *Lexer) readRune() (rune, bool) {
+	r, _, err := l.reader.ReadRune()
+	if err != nil {
+		if err == io.EOF {
+			return 0, false
+		}
+		panic(err)
 	}
+	l.pos.Column++
+	return r, true
 }
 
-func (l *Lexer) Peek() (Token, string) {
-	if l.peeked != nil {
-		return *l.peeked, l.peekedString
+// peekByte returns the byte i bytes ahead without consuming anything, or 0 at
+// the end of the input.
+func (l *Lexer) peekByte(i int) byte {
+	next, _ := l.reader.Peek(i + 1)
+	if len(next) <= i {
+		return 0
 	}
+	return next[i]
+}
 
-	tok, str := l.Lex()
-	l.peeked = &tok
-	l.peekedString = str
+// digits consumes a run of digits, which are hexadecimal if hex is set. an
+// underscore can separate digits. runs of them and ones at the end are part of
+// the literal too, but are reported.
+func (l *Lexer) digits(hex bool) string {
+	isDigit := func(b byte) bool {
+		return unicode.IsDigit(rune(b)) || hex && isHexDigit(b)
+	}
 
-	return tok, str
+	var lit string
+	for {
+		b := l.peekByte(0)
+		if b == '_' {
+			n := 1
+			for l.peekByte(n) == '_' {
+				n++
+			}
+			next := l.peekByte(n)
+			if !isDigit(next) && unicode.IsLetter(rune(next)) {
+				return lit
+			}
+			from := l.pos
+			for i := 0; i < n; i++ {
+				l.readRune()
+			}
+			lit += strings.Repeat("_", n)
+			if n > 1 || !isDigit(next) {
+				l.diags.Errorf(ErrInvalidLiteral, Span{from, l.pos}, "'_' must separate successive digits")
+			}
+			continue
+		}
+		if !isDigit(b) {
+			return lit
+		}
+		l.readRune()
+		lit += string(b)
+	}
+}
+
+// lexNumber lexes an integer or floating point literal starting with first.
+// a dot only starts a fraction when a digit follows it, so 0..10 is still a
+// range.
+func (l *Lexer) lexNumber(first rune) (Token, string) {
+	from := l.pos
+	lit := string(first)
+	kind := INT
+
+	// binary and octal literals are lexed with all the decimal digits, so that
+	// the parser can complain about the ones that don't belong
+	var hex, prefixed bool
+	if first == '0' {
+		switch l.peekByte(0) {
+		case 'x', 'X':
+			hex, prefixed = true, true
+		case 'o', 'O', 'b', 'B':
+			prefixed = true
+		}
+	}
+	if prefixed {
+		r, _ := l.readRune()
+		lit += string(r)
+	}
+	lit += l.digits(hex)
+	if prefixed && !hex {
+		return Token{kind, Span{from, l.pos}}, lit
+	}
+
+	if l.peekByte(0) == '.' && (unicode.IsDigit(rune(l.peekByte(1))) || hex && isHexDigit(l.peekByte(1))) {
+		l.readRune()
+		lit += "." + l.digits(hex)
+		kind = FLOAT
+	}
+
+	exponent := "eE"
+	if hex {
+		exponent = "pP"
+	}
+	if e := l.peekByte(0); e != 0 && strings.ContainsRune(exponent, rune(e)) {
+		sign := l.peekByte(1) == '+' || l.peekByte(1) == '-'
+		digit := l.peekByte(1)
+		if sign {
+			digit = l.peekByte(2)
+		}
+		if unicode.IsDigit(rune(digit)) {
+			l.readRune()
+			lit += string(e)
+			if sign {
+				r, _ := l.readRune()
+				lit += string(r)
+			}
+			lit += l.digits(false)
+			kind = FLOAT
+		}
+	}
+
+	return Token{kind, Span{from, l.pos}}, lit
+}
+
+func isHexDigit(b byte) bool {
+	return unicode.IsDigit(rune(b)) || strings.ContainsRune("abcdefABCDEF", rune(b))
+}
+
+type lexed struct {
+	tok Token
+	s   string
+}
+
+func (l *Lexer) Peek() (Token, string) {
+	return l.PeekAt(0)
+}
+
+// PeekAt returns the token n tokens after the next one, without lexing any of
+// them.
+func (l *Lexer) PeekAt(n int) (Token, string) {
+	for len(l.ahead) <= n {
+		tok, str := l.lex()
+		l.ahead = append(l.ahead, lexed{tok, str})
+	}
+
+	return l.ahead[n].tok, l.ahead[n].s
 }
 
 func (l *Lexer) PeekIs(k ...TokenKind) bool {
@@ -272,6 +784,11 @@ func (l *Lexer) LexExpecting(k ...TokenKind) (Token, string) {
 		}
 	}
 
+	// the unexpected token is left to be lexed again, so that the parser can
+	// use it to find its footing after reporting the error
+	l.ahead = append([]lexed{{token, lit}}, l.ahead...)
+	l.count(token.Kind, -1)
+
 	panic(ExpectedOneOfKindGotKind{
 		Expected: k,
 		Got:      token.Kind,
@@ -280,46 +797,64 @@ func (l *Lexer) LexExpecting(k ...TokenKind) (Token, string) {
 }
 
 func (l *Lexer) Lex() (r Token, s string) {
-	if l.peeked != nil {
-		defer func() { l.peeked = nil }()
-		return *l.peeked, l.peekedString
+	if len(l.ahead) > 0 {
+		r, s = l.ahead[0].tok, l.ahead[0].s
+		l.ahead = l.ahead[1:]
+	} else {
+		r, s = l.lex()
 	}
-	if l.insertNewline {
-		l.insertNewline = false
-		return Token{
-			Kind:     EOS,
-			Location: SingleCharSpan(l.pos),
-		}, "\n"
+
+	// statements ended by a newline end where their last token did
+	if r.Kind != EOS || s != "\n" {
+		l.lastEnd = r.Location.To
+	}
+	l.count(r.Kind, 1)
+
+	return r, s
+}
+
+// count keeps track of the braces handed out, or handed back with by -1.
+func (l *Lexer) count(kind TokenKind, by int) {
+	switch kind {
+	case LBRACKET:
+		l.depth += by
+	case RBRACKET:
+		l.depth -= by
+	}
+}
+
+// endsStatement reports whether a newline after the last token ends a
+// statement.
+func (l *Lexer) endsStatement() bool {
+	switch l.lastKind {
+	case IDENT, RBRACKET, RPAREN, RSQUARE, INT, FLOAT, STRING, CHAR, BREAK, CONTINUE:
+		return true
 	}
+	return false
+}
 
+func (l *Lexer) lex() (tok Token, s string) {
 	defer func() {
-		byt, err := l.reader.Peek(1)
-		if err != nil && err != io.EOF {
-			panic(err)
+		l.lastKind = tok.Kind
+		if tok.Kind == EOS {
+			return
 		}
-		if err == io.EOF {
-			byt = append(byt, '\n')
-		}
-
-		if byt[0] == '\n' {
-			switch r.Kind {
-			case IDENT, RBRACKET, RPAREN, INT, STRING:
-				_, err = l.reader.ReadByte()
-				if err != nil {
-					panic(err)
-				}
 
-				l.pos.Column = 0
-				l.pos.Line++
-				l.insertNewline = true
-			}
+		l.tokenDoc = ""
+		if len(l.doc) > 0 && l.docLine == tok.Location.From.Line-1 {
+			l.tokenDoc = strings.Join(l.doc, "\n")
 		}
+		l.doc = nil
 	}()
 
 	for {
 		r, _, err := l.reader.ReadRune()
 		if err != nil {
 			if err == io.EOF {
+				// the end of the input ends a statement too
+				if l.endsStatement() {
+					return l.kinded(EOS), "\n"
+				}
 				return l.kinded(EOF), ""
 			}
 			panic(err)
@@ -327,20 +862,68 @@ func (l *Lexer) Lex() (r Token, s string) {
 
 		l.pos.Column++
 
-		switch {
-		case r == '=':
-			byt, err := l.reader.Peek(1)
+		if r == '\n' {
+			if l.endsStatement() {
+				tok := l.kinded(EOS)
+				l.newline()
+				return tok, "\n"
+			}
+			l.newline()
+			continue
+		}
+
+		if r == '/' {
+			next, err := l.reader.Peek(1)
 			if err != nil && err != io.EOF {
 				panic(err)
 			}
-			if byt[0] == '>' {
+			if string(next) == "/" {
+				l.lineComment()
+				continue
+			}
+			if string(next) == "*" {
+				// like a newline, a comment spanning lines ends a statement
+				from := l.pos
+				if l.blockComment() && l.endsStatement() {
+					return Token{EOS, SingleCharSpan(from)}, "\n"
+				}
+				continue
+			}
+		}
+
+		operators := map[string]TokenKind{
+			"=>": FATARROW,
+			"==": DOUBLEEQUALS,
+			"!=": NOTEQUALS,
+			"<=": LESSEQUALS,
+			">=": GREATEREQUALS,
+			"&&": ANDAND,
+			"||": OROR,
+			"<<": SHIFTLEFT,
+			">>": SHIFTRIGHT,
+			"..": DOTDOT,
+		}
 
+		// peeking invalidates backing up, so only look ahead when r could
+		// start a two character operator
+		byt := []byte{}
+		if strings.ContainsRune("=!<>&|.", r) {
+			byt, err = l.reader.Peek(1)
+			if err != nil && err != io.EOF {
+				panic(err)
+			}
+		}
+		if len(byt) > 0 {
+			op := string(r) + string(byt)
+			if kind, ok := operators[op]; ok {
+				from := l.pos
 				if _, _, err := l.reader.ReadRune(); err != nil {
 					panic(err)
 				}
-				return l.kinded(FATARROW), "=>"
+				l.pos.Column++
+
+				return Token{kind, Span{from, l.pos}}, op
 			}
-			return l.kinded(EQUALS), "="
 		}
 
 		data := map[rune]TokenKind{
@@ -349,9 +932,23 @@ func (l *Lexer) Lex() (r Token, s string) {
 			')': RPAREN,
 			'{': LBRACKET,
 			'}': RBRACKET,
+			'[': LSQUARE,
+			']': RSQUARE,
 			',': COMMA,
 			';': EOS,
 			'.': PERIOD,
+			'=': EQUALS,
+			'+': PLUS,
+			'-': MINUS,
+			'*': STAR,
+			'/': SLASH,
+			'%': PERCENT,
+			'<': LESS,
+			'>': GREATER,
+			'!': BANG,
+			'&': AMPERSAND,
+			'|': PIPE,
+			'^': CARET,
 		}
 
 		if kind, ok := data[r]; ok {
@@ -359,48 +956,36 @@ func (l *Lexer) Lex() (r Token, s string) {
 		}
 
 		switch r {
-		case '\n':
-			l.newline()
-			continue
 		case '`':
-			l.backup()
-			from, to, lit := l.lexString()
-
-			return Token{STRING, Span{from, to}}, lit
+			return l.lexString()
+		case '"', '\'':
+			return l.lexQuoted(r)
 		}
 
 		keywords := map[string]TokenKind{
-			"type":   TYPE,
-			"if":     IF,
-			"then":   THEN,
-			"else":   ELSE,
-			"func":   FUNC,
-			"import": IMPORT,
-			"struct": STRUCT,
-			"var":    VAR,
-			"let":    LET,
+			"type":      TYPE,
+			"if":        IF,
+			"then":      THEN,
+			"else":      ELSE,
+			"func":      FUNC,
+			"import":    IMPORT,
+			"struct":    STRUCT,
+			"var":       VAR,
+			"let":       LET,
+			"while":     WHILE,
+			"for":       FOR,
+			"in":        IN,
+			"break":     BREAK,
+			"continue":  CONTINUE,
+			"match":     MATCH,
+			"of":        OF,
+			"interface": INTERFACE,
+			"new":       NEW,
 		}
 
 		switch {
 		case unicode.IsDigit(r):
-			var runes string
-			runes += string(r)
-			for {
-				r, _, err := l.reader.ReadRune()
-				if err != nil {
-					if err == io.EOF {
-						return l.kinded(INT), runes
-					}
-					panic(err)
-				}
-
-				if !unicode.IsDigit(r) {
-					l.backup()
-					return l.kinded(INT), runes
-				}
-
-				runes += string(r)
-			}
+			return l.lexNumber(r)
 		case unicode.IsSpace(r):
 			continue
 		case otherChar(r):
@@ -414,7 +999,7 @@ func (l *Lexer) Lex() (r Token, s string) {
 			return Token{IDENT, Span{from, to}}, lit
 		}
 
-		panic("unhandled")
+		l.diags.Errorf(ErrUnexpectedCharacter, SingleCharSpan(l.pos), "unexpected character %q", r)
 	}
 }
 
@@ -10,3 +10,120 @@ func TestLexer(t *testing.T) {
 	tokens := l.lexToEOF()
 	t.Fatalf("%#v", tokens)
 }
+
+func TestLexerComments(t *testing.T) {
+	src := "a // one\nb /* two\n /* three */ */ c/* four */\n/// doc\n/// more\nfunc d/e"
+	l := NewLexer(strings.NewReader(src), "stdin")
+	l.diags = &Diagnostics{}
+
+	var kinds []TokenKind
+	var lits []string
+	for tok, lit := l.Lex(); tok.Kind != EOF; tok, lit = l.Lex() {
+		kinds = append(kinds, tok.Kind)
+		lits = append(lits, lit)
+		if tok.Kind == FUNC && l.tokenDoc != "doc\nmore" {
+			t.Errorf("wrong doc comment %q", l.tokenDoc)
+		}
+	}
+
+	expected := []TokenKind{IDENT, EOS, IDENT, EOS, IDENT, EOS, FUNC, IDENT, SLASH, IDENT, EOS}
+	if len(kinds) != len(expected) {
+		t.Fatalf("got tokens %v %q, expected %v", kinds, lits, expected)
+	}
+	for i := range kinds {
+		if kinds[i] != expected[i] {
+			t.Fatalf("got tokens %v %q, expected %v", kinds, lits, expected)
+		}
+	}
+	if lits[7] != "d" || lits[9] != "e" {
+		t.Errorf("slashes should separate identifiers, got %q", lits[7:10])
+	}
+
+	if len(l.comments) != 5 {
+		t.Fatalf("expected 5 comments, got %#v", l.comments)
+	}
+	two := l.comments[1]
+	if two.Text != "/* two\n /* three */ */" || two.Pos.From != (Position{2, 3, "stdin"}) || two.Pos.To != (Position{3, 15, "stdin"}) {
+		t.Errorf("wrong block comment %#v", two)
+	}
+	if tok := l.comments[2]; tok.Pos.From != (Position{3, 18, "stdin"}) {
+		t.Errorf("wrong position after a block comment %#v", tok)
+	}
+	if len(l.diags.List()) != 0 {
+		t.Errorf("unexpected errors %v", l.diags.List())
+	}
+}
+
+func TestLexerNumbers(t *testing.T) {
+	cases := []struct {
+		src  string
+		kind TokenKind
+		lit  string
+	}{
+		{"10", INT, "10"},
+		{"1.5", FLOAT, "1.5"},
+		{"1e-9", FLOAT, "1e-9"},
+		{"2.5E+3", FLOAT, "2.5E+3"},
+		{"0x1p3", FLOAT, "0x1p3"},
+		{"0x1.8p-1", FLOAT, "0x1.8p-1"},
+		{"0..10", INT, "0"},
+		{"3.x", INT, "3"},
+		{"1e", INT, "1"},
+		{"0xff_ff", INT, "0xff_ff"},
+		{"0o17", INT, "0o17"},
+		{"0b102", INT, "0b102"},
+		{"1_000", INT, "1_000"},
+		{"1__0", INT, "1__0"},
+		{"1_.5", FLOAT, "1_.5"},
+		{"1_", INT, "1_"},
+		{"1_x", INT, "1"},
+	}
+
+	for _, tc := range cases {
+		l := NewLexer(strings.NewReader(tc.src), "stdin")
+		tok, lit := l.Lex()
+		if tok.Kind != tc.kind || lit != tc.lit {
+			t.Errorf("lexing %q gave %s %q, expected %s %q", tc.src, tok.Kind, lit, tc.kind, tc.lit)
+		}
+		misplaced := strings.Contains(lit, "__") || strings.Contains(lit, "_.") || strings.HasSuffix(lit, "_")
+		if diags := l.diags.List(); misplaced != (len(diags) == 1 && diags[0].Message == "'_' must separate successive digits") {
+			t.Errorf("lexing %q reported %v", tc.src, diags)
+		}
+	}
+}
+
+func TestLexerStrings(t *testing.T) {
+	src := "\"a\\tb\\\"\\x41\\u{e9}\" 'x' '\\n' `two\nlines` c \"\\q\""
+	l := NewLexer(strings.NewReader(src), "stdin")
+	l.diags = &Diagnostics{}
+
+	expected := []struct {
+		kind  TokenKind
+		value string
+	}{
+		{STRING, "a\tb\"A\u00e9"},
+		{CHAR, "x"},
+		{CHAR, "\n"},
+		{STRING, "two\nlines"},
+		{IDENT, "c"},
+		{STRING, ""},
+	}
+	for _, want := range expected {
+		tok, lit := l.Lex()
+		value := lit
+		if tok.Kind != IDENT {
+			value = unquote(lit, nil)
+		}
+		if tok.Kind != want.kind || value != want.value {
+			t.Errorf("got %s %q, expected %s %q", tok.Kind, value, want.kind, want.value)
+		}
+		if want.kind == IDENT && tok.Location.From != (Position{2, 8, "stdin"}) {
+			t.Errorf("wrong position after a multi-line string %v", tok.Location.From)
+		}
+	}
+
+	errs := l.diags.List()
+	if len(errs) != 1 || errs[0].Location.From != (Position{2, 11, "stdin"}) {
+		t.Errorf("expected an error for the unknown escape, got %v", errs)
+	}
+}
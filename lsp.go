@@ -0,0 +1,645 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// the language server speaks JSON-RPC over stdin and stdout. only the parts
+// of the protocol that tawago supports are declared here.
+
+type lspRequest struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type lspResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type lspErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   lspError         `json:"error"`
+}
+
+type lspNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type lspTextDocumentPositionParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	Position     lspPosition               `json:"position"`
+}
+
+type lspDidOpenParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type lspDidChangeParams struct {
+	TextDocument   lspTextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspDocumentParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+}
+
+type lspDiagnostic struct {
+	Range              lspRange                          `json:"range"`
+	Severity           int                               `json:"severity"`
+	Code               ErrorCode                         `json:"code,omitempty"`
+	Source             string                            `json:"source"`
+	Message            string                            `json:"message"`
+	RelatedInformation []lspDiagnosticRelatedInformation `json:"relatedInformation,omitempty"`
+}
+
+type lspDiagnosticRelatedInformation struct {
+	Location lspLocation `json:"location"`
+	Message  string      `json:"message"`
+}
+
+type lspPublishDiagnosticsParams struct {
+	URI         string          `json:"uri"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+type lspMarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents lspMarkupContent `json:"contents"`
+	Range    *lspRange        `json:"range,omitempty"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string   `json:"name"`
+	Detail         string   `json:"detail,omitempty"`
+	Kind           int      `json:"kind"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+// completion item and symbol kinds, as numbered by the protocol
+const (
+	lspCompletionFunction   = 3
+	lspCompletionVariable   = 6
+	lspCompletionClass      = 7
+	lspCompletionInterface  = 8
+	lspCompletionEnum       = 13
+	lspCompletionEnumMember = 20
+	lspCompletionStruct     = 22
+
+	lspSymbolClass     = 5
+	lspSymbolMethod    = 6
+	lspSymbolEnum      = 10
+	lspSymbolInterface = 11
+	lspSymbolFunction  = 12
+	lspSymbolStruct    = 23
+)
+
+// packageAnalysis is what the language server knows about a package.
+type packageAnalysis struct {
+	dir       string
+	files     []string
+	toplevels map[string][]TopLevel
+	diags     *Diagnostics
+	index     *symbolIndex
+}
+
+type lspServer struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	sets     settings
+	imported map[string]string
+
+	// documents the editor has open, which may not have been saved yet
+	docs      map[string][]byte
+	packages  map[string]*packageAnalysis
+	published map[string]bool
+	shutdown  bool
+}
+
+func newLSPServer(in io.Reader, out io.Writer, sets settings) *lspServer {
+	s := &lspServer{
+		in:        bufio.NewReader(in),
+		out:       out,
+		sets:      sets,
+		imported:  map[string]string{},
+		docs:      map[string][]byte{},
+		packages:  map[string]*packageAnalysis{},
+		published: map[string]bool{},
+	}
+	for _, lib := range sets.forceimportlibs {
+		ti, err := getTypeInfoFromFile(lib)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error with type info for %s: %s\n", lib, err)
+			continue
+		}
+		for name, kind := range ti.Functions {
+			s.imported[qualifiedName(ti.Package, name)] = kind
+		}
+	}
+	return s
+}
+
+// serve handles messages until the editor asks the server to exit. the
+// returned code is what the process should exit with.
+func (s *lspServer) serve() int {
+	for {
+		req, err := s.read()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "error reading message: %s\n", err)
+			}
+			return 1
+		}
+		if req.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		s.handle(req)
+	}
+}
+
+func (s *lspServer) read() (req lspRequest, err error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return req, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+			if err != nil {
+				return req, fmt.Errorf("bad Content-Length: %w", err)
+			}
+		}
+	}
+	if length < 0 {
+		return req, fmt.Errorf("message without Content-Length")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return req, err
+	}
+	return req, json.Unmarshal(body, &req)
+}
+
+func (s *lspServer) write(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing message: %s\n", err)
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *lspServer) reply(req lspRequest, result interface{}) {
+	s.write(lspResponse{"2.0", req.ID, result})
+}
+
+func (s *lspServer) replyError(req lspRequest, code int, format string, args ...interface{}) {
+	s.write(lspErrorResponse{"2.0", req.ID, lspError{code, fmt.Sprintf(format, args...)}})
+}
+
+func (s *lspServer) notify(method string, params interface{}) {
+	s.write(lspNotification{"2.0", method, params})
+}
+
+func (s *lspServer) handle(req lspRequest) {
+	switch req.Method {
+	case "initialize":
+		s.reply(req, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    1, // the whole document is sent on every change
+					"save":      true,
+				},
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"completionProvider":     map[string]interface{}{},
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "tawago"},
+		})
+	case "shutdown":
+		s.shutdown = true
+		s.reply(req, nil)
+	case "textDocument/didOpen":
+		var params lspDidOpenParams
+		if json.Unmarshal(req.Params, &params) == nil {
+			path := uriToPath(params.TextDocument.URI)
+			s.docs[path] = []byte(params.TextDocument.Text)
+			s.update(path)
+		}
+	case "textDocument/didChange":
+		var params lspDidChangeParams
+		if json.Unmarshal(req.Params, &params) == nil && len(params.ContentChanges) > 0 {
+			path := uriToPath(params.TextDocument.URI)
+			s.docs[path] = []byte(params.ContentChanges[len(params.ContentChanges)-1].Text)
+			s.update(path)
+		}
+	case "textDocument/didSave":
+		var params lspDocumentParams
+		if json.Unmarshal(req.Params, &params) == nil {
+			s.update(uriToPath(params.TextDocument.URI))
+		}
+	case "textDocument/didClose":
+		var params lspDocumentParams
+		if json.Unmarshal(req.Params, &params) == nil {
+			path := uriToPath(params.TextDocument.URI)
+			delete(s.docs, path)
+			s.update(path)
+		}
+	case "textDocument/definition", "textDocument/hover", "textDocument/completion":
+		var params lspTextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			s.replyError(req, lspInvalidParams, "%s", err)
+			return
+		}
+		path := uriToPath(params.TextDocument.URI)
+		a := s.analysis(path)
+		at := a.position(path, params.Position)
+
+		switch req.Method {
+		case "textDocument/definition":
+			s.reply(req, a.definition(at))
+		case "textDocument/hover":
+			s.reply(req, a.hover(at))
+		case "textDocument/completion":
+			s.reply(req, a.completion(at))
+		}
+	case "textDocument/documentSymbol":
+		var params lspDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			s.replyError(req, lspInvalidParams, "%s", err)
+			return
+		}
+		path := uriToPath(params.TextDocument.URI)
+		s.reply(req, s.analysis(path).documentSymbols(path))
+	default:
+		// notifications the server doesn't know about are ignored, but
+		// requests need an answer
+		if req.ID != nil {
+			s.replyError(req, lspMethodNotFound, "method %s is not supported", req.Method)
+		}
+	}
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.Clean(u.Path)
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// analysis returns what's known about the package containing path.
+func (s *lspServer) analysis(path string) *packageAnalysis {
+	if a, ok := s.packages[filepath.Dir(path)]; ok {
+		return a
+	}
+	return s.analyze(filepath.Dir(path))
+}
+
+// update re-analyses the package containing path after it's changed, and
+// sends the editor the new diagnostics for every file in it.
+func (s *lspServer) update(path string) {
+	a := s.analyze(filepath.Dir(path))
+
+	byFile := map[string][]lspDiagnostic{}
+	for _, file := range a.files {
+		byFile[file] = []lspDiagnostic{}
+	}
+	for _, diag := range a.diags.List() {
+		file := diag.Location.From.Filename
+		if file == "" {
+			// problems that aren't about any particular file are shown in
+			// the one that was just changed
+			file = path
+		}
+		byFile[file] = append(byFile[file], a.diagnostic(diag))
+	}
+
+	for file := range s.published {
+		if _, ok := byFile[file]; !ok && filepath.Dir(file) == a.dir {
+			byFile[file] = []lspDiagnostic{}
+		}
+	}
+	for file, diags := range byFile {
+		s.notify("textDocument/publishDiagnostics", lspPublishDiagnosticsParams{pathToURI(file), diags})
+		if len(diags) > 0 {
+			s.published[file] = true
+		} else {
+			delete(s.published, file)
+		}
+	}
+}
+
+// analyze parses and checks the package in dir the way parseDirectory and
+// checkPackage do, using the editor's contents for open documents.
+func (s *lspServer) analyze(dir string) (a *packageAnalysis) {
+	a = &packageAnalysis{
+		dir:       dir,
+		toplevels: map[string][]TopLevel{},
+		diags:     &Diagnostics{},
+	}
+	s.packages[dir] = a
+
+	files, err := sourceFiles(dir)
+	if err != nil {
+		a.diags.AddError(err)
+	}
+	for path := range s.docs {
+		if filepath.Dir(path) == dir && !containsString(files, path) {
+			files = append(files, path)
+		}
+	}
+	a.files = files
+
+	var all []TopLevel
+	for _, path := range files {
+		src, ok := s.docs[path]
+		if !ok {
+			src, err = ioutil.ReadFile(path)
+			if err != nil {
+				a.diags.AddError(err)
+				continue
+			}
+		}
+		a.toplevels[path] = parseFile(path, src, a.diags).Toplevels
+		all = append(all, a.toplevels[path]...)
+	}
+
+	// the index is built from the checked package when there is one, so
+	// that the types of locals are known
+	indexed := all
+	if !a.diags.HasErrors() {
+		if typed := s.check(dir, all, a.diags); typed != nil {
+			indexed = typed
+		}
+	}
+	a.index = buildIndex(indexed, s.imported)
+
+	return a
+}
+
+// check type checks a package, making sure that a bug in the checker doesn't
+// take the whole server down with it.
+func (s *lspServer) check(dir string, tls []TopLevel, diags *Diagnostics) (typed []TopLevel) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "checker panicked: %v\n", r)
+			typed = nil
+		}
+	}()
+	sets := s.sets
+	sets.dir = dir
+	return check(tls, sets, diags)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *packageAnalysis) lines(path string) []string {
+	return a.diags.sources[path]
+}
+
+// position converts a position from the editor, which counts from 0 in
+// UTF-16 code units, to one from the lexer, which counts from 1 in runes.
+func (a *packageAnalysis) position(path string, p lspPosition) Position {
+	text := []rune(line(a.lines(path), p.Line+1))
+	units, column := 0, 0
+	for column < len(text) && units < p.Character {
+		units += len(utf16.Encode([]rune{text[column]}))
+		column++
+	}
+	return Position{Line: p.Line + 1, Column: column + 1, Filename: path}
+}
+
+func (a *packageAnalysis) lspPosition(p Position) lspPosition {
+	if p.Line == 0 {
+		return lspPosition{}
+	}
+	text := []rune(line(a.lines(p.Filename), p.Line))
+	column := p.Column - 1
+	if column > len(text) {
+		column = len(text)
+	}
+	if column < 0 {
+		column = 0
+	}
+	return lspPosition{p.Line - 1, len(utf16.Encode(text[:column]))}
+}
+
+// lspRange converts a span, whose end is inclusive, to a range, whose end
+// isn't.
+func (a *packageAnalysis) lspRange(s Span) lspRange {
+	end := s.To
+	if before(end, s.From) {
+		end = s.From
+	}
+	end.Column++
+	return lspRange{a.lspPosition(s.From), a.lspPosition(end)}
+}
+
+func (a *packageAnalysis) diagnostic(diag Diagnostic) lspDiagnostic {
+	severity := 1
+	switch diag.Severity {
+	case SeverityWarning:
+		severity = 2
+	case SeverityNote:
+		severity = 3
+	}
+
+	message := diag.Message
+	var related []lspDiagnosticRelatedInformation
+	for _, note := range diag.Notes {
+		if note.Location.From.Line == 0 {
+			message += "\nnote: " + note.Message
+			continue
+		}
+		related = append(related, lspDiagnosticRelatedInformation{
+			Location: lspLocation{pathToURI(note.Location.From.Filename), a.lspRange(note.Location)},
+			Message:  note.Message,
+		})
+	}
+	for _, hint := range diag.Hints {
+		message += "\nhelp: " + hint
+	}
+
+	return lspDiagnostic{
+		Range:              a.lspRange(diag.Location),
+		Severity:           severity,
+		Code:               diag.Code,
+		Source:             "tawago",
+		Message:            message,
+		RelatedInformation: related,
+	}
+}
+
+func (a *packageAnalysis) definition(at Position) interface{} {
+	sym := a.index.symbolAt(at)
+	if sym == nil || sym.Pos.From.Line == 0 {
+		return nil
+	}
+	return lspLocation{pathToURI(sym.Pos.From.Filename), a.lspRange(sym.Pos)}
+}
+
+func (a *packageAnalysis) hover(at Position) interface{} {
+	sym := a.index.symbolAt(at)
+	if sym == nil {
+		return nil
+	}
+	contents := "```tawa\n" + sym.Detail + "\n```"
+	if sym.Doc != "" {
+		contents += "\n\n" + sym.Doc
+	}
+	return lspHover{
+		Contents: lspMarkupContent{"markdown", contents},
+	}
+}
+
+func completionKind(sym *lspSymbol) int {
+	switch sym.Kind {
+	case symbolFunc:
+		return lspCompletionFunction
+	case symbolType:
+		return lspCompletionClass
+	case symbolVariant:
+		return lspCompletionEnumMember
+	}
+	return lspCompletionVariable
+}
+
+func (a *packageAnalysis) completion(at Position) []lspCompletionItem {
+	items := []lspCompletionItem{}
+	for _, sym := range a.index.inScope(at) {
+		kind := completionKind(sym)
+		if global, ok := a.index.globals[sym.Name]; ok && global == sym && sym.Kind == symbolType {
+			if strings.Contains(sym.Detail, " struct {") {
+				kind = lspCompletionStruct
+			} else if strings.Contains(sym.Detail, " = |") {
+				kind = lspCompletionEnum
+			} else if strings.Contains(sym.Detail, " interface {") {
+				kind = lspCompletionInterface
+			}
+		}
+		items = append(items, lspCompletionItem{sym.Name, kind, sym.Detail})
+	}
+	return items
+}
+
+func (a *packageAnalysis) documentSymbols(path string) []lspDocumentSymbol {
+	symbols := []lspDocumentSymbol{}
+	for _, tl := range a.toplevels[path] {
+		switch tl := tl.(type) {
+		case Func:
+			kind := lspSymbolFunction
+			if tl.Receiver != nil {
+				kind = lspSymbolMethod
+			}
+			symbols = append(symbols, lspDocumentSymbol{
+				Name:           funcName(tl),
+				Detail:         tl.String(),
+				Kind:           kind,
+				Range:          a.lspRange(tl.Pos),
+				SelectionRange: a.lspRange(tl.Ident.Pos),
+			})
+		case TypeDeclaration:
+			kind := lspSymbolClass
+			switch tl.Kind.(type) {
+			case Struct:
+				kind = lspSymbolStruct
+			case Sum:
+				kind = lspSymbolEnum
+			case Interface:
+				kind = lspSymbolInterface
+			}
+			symbols = append(symbols, lspDocumentSymbol{
+				Name:           tl.Ident.Name,
+				Detail:         typeToString(&tl.Kind),
+				Kind:           kind,
+				Range:          a.lspRange(tl.Ident.Pos),
+				SelectionRange: a.lspRange(tl.Ident.Pos),
+			})
+		}
+	}
+	return symbols
+}
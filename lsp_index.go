@@ -0,0 +1,482 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// lspSymbol is something a name can refer to, as far as the language server
+// is concerned. Detail and Doc are what's shown when hovering over it.
+type lspSymbol struct {
+	Name   string
+	Kind   symbolKind
+	Pos    Span
+	Detail string
+	Doc    string
+}
+
+type reference struct {
+	Pos    Span
+	Symbol *lspSymbol
+}
+
+// local is a symbol declared inside of a function. it can be used from where
+// it's declared up to until.
+type local struct {
+	Symbol *lspSymbol
+	until  Position
+}
+
+// symbolIndex records where things are declared in a package and which
+// declarations names refer to.
+type symbolIndex struct {
+	universe []*lspSymbol
+	globals  map[string]*lspSymbol
+	locals   []local
+	refs     []reference
+}
+
+var endOfFile = Position{Line: math.MaxInt32}
+
+func before(a, b Position) bool {
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
+
+func spanContains(s Span, p Position) bool {
+	return s.From.Filename == p.Filename && s.From.Line != 0 && !before(p, s.From) && !before(s.To, p)
+}
+
+func funcSignature(fn Func) string {
+	signature := "func "
+	if fn.Receiver != nil {
+		signature += "(" + fn.Receiver.Ident.Name + " " + fn.Receiver.Kind.Name + ") "
+	}
+	return signature + fn.Ident.Name + typeParamsToString(fn.TypeParams) + strings.TrimPrefix(fn.String(), "func")
+}
+
+func startOfToplevel(tl TopLevel) Position {
+	switch tl := tl.(type) {
+	case Func:
+		return tl.Ident.Pos.From
+	case TypeDeclaration:
+		return tl.Ident.Pos.From
+	}
+	return Position{}
+}
+
+// buildIndex indexes the top levels of a package. they don't have to have
+// been checked, but when they have been the types of locals are known.
+func buildIndex(tls []TopLevel, imported map[string]string) *symbolIndex {
+	idx := &symbolIndex{globals: map[string]*lspSymbol{}}
+
+	for _, sym := range universe().symbols {
+		detail := sym.Type.String()
+		if sym.Kind == symbolType {
+			detail = "type " + sym.Name
+		}
+		idx.universe = append(idx.universe, &lspSymbol{Name: sym.Name, Kind: sym.Kind, Detail: detail})
+	}
+	sort.Slice(idx.universe, func(i, j int) bool {
+		return idx.universe[i].Name < idx.universe[j].Name
+	})
+
+	for name, kind := range imported {
+		idx.globals[name] = &lspSymbol{
+			Name:   name,
+			Kind:   symbolFunc,
+			Detail: "func " + name + strings.TrimPrefix(kind, "func"),
+		}
+	}
+
+	for _, tl := range tls {
+		switch tl := tl.(type) {
+		case Func:
+			idx.globals[funcName(tl)] = &lspSymbol{funcName(tl), symbolFunc, tl.Ident.Pos, funcSignature(tl), tl.Doc}
+		case TypeDeclaration:
+			idx.globals[tl.Ident.Name] = &lspSymbol{tl.Ident.Name, symbolType, tl.Ident.Pos, "type " + tl.Ident.Name + typeParamsToString(tl.TypeParams) + " " + typeToString(&tl.Kind), tl.Doc}
+			switch kind := tl.Kind.(type) {
+			case Sum:
+				for _, variant := range kind {
+					idx.globals[variant.Ident.Name] = &lspSymbol{Name: variant.Ident.Name, Kind: symbolVariant, Pos: variant.Ident.Pos, Detail: tl.Ident.Name + " | " + variantToString(variant)}
+				}
+			case Interface:
+				for _, method := range kind {
+					name := tl.Ident.Name + "." + method.Ident.Name
+					idx.globals[name] = &lspSymbol{Name: name, Kind: symbolFunc, Pos: method.Ident.Pos, Detail: "func (" + tl.Ident.Name + ") " + method.Ident.Name + parametersToString(method.Arguments, method.Returns)}
+				}
+			}
+		}
+	}
+
+	// a function's locals are in scope until the next top level in its file
+	starts := map[string][]Position{}
+	for _, tl := range tls {
+		if start := startOfToplevel(tl); start.Line != 0 {
+			starts[start.Filename] = append(starts[start.Filename], start)
+		}
+	}
+	for _, s := range starts {
+		sort.Slice(s, func(i, j int) bool { return before(s[i], s[j]) })
+	}
+	untilNext := func(from Position) Position {
+		for _, start := range starts[from.Filename] {
+			if before(from, start) {
+				return start
+			}
+		}
+		return endOfFile
+	}
+
+	x := &indexer{idx: idx}
+	for _, tl := range tls {
+		switch tl := tl.(type) {
+		case Func:
+			x.fn(tl, untilNext(tl.Ident.Pos.From))
+		case TypeDeclaration:
+			x.push(untilNext(tl.Ident.Pos.From))
+			x.typeParams(tl.TypeParams)
+			x.use(tl.Ident)
+			x.useType(tl.Kind)
+			x.pop()
+		}
+	}
+
+	return idx
+}
+
+type indexerScope struct {
+	names map[string]*lspSymbol
+	until Position
+}
+
+// indexer walks function bodies keeping track of which locals are in scope,
+// the same way the checker does.
+type indexer struct {
+	idx    *symbolIndex
+	scopes []indexerScope
+}
+
+func (x *indexer) push(until Position) {
+	x.scopes = append(x.scopes, indexerScope{map[string]*lspSymbol{}, until})
+}
+
+func (x *indexer) pop() {
+	x.scopes = x.scopes[:len(x.scopes)-1]
+}
+
+func (x *indexer) lookup(name string) *lspSymbol {
+	for i := len(x.scopes) - 1; i >= 0; i-- {
+		if sym, ok := x.scopes[i].names[name]; ok {
+			return sym
+		}
+	}
+	return x.idx.globals[name]
+}
+
+func (x *indexer) declare(sym *lspSymbol) {
+	top := x.scopes[len(x.scopes)-1]
+	top.names[sym.Name] = sym
+	x.idx.locals = append(x.idx.locals, local{sym, top.until})
+}
+
+func (x *indexer) use(id Identifier) {
+	// the checker names uses of generics after the instance they use
+	name := id.Name
+	if i := strings.IndexByte(name, '['); i > 0 {
+		name = name[:i]
+	}
+	if sym := x.lookup(name); sym != nil && id.Pos.From.Line != 0 {
+		x.idx.refs = append(x.idx.refs, reference{id.Pos, sym})
+	}
+}
+
+func (x *indexer) useType(t Type) {
+	switch t := t.(type) {
+	case Ident:
+		x.use(Identifier(t))
+	case Generic:
+		x.use(t.Ident)
+		for _, arg := range t.Arguments {
+			x.useType(arg)
+		}
+	case FunctionPointer:
+		for _, arg := range t.Arguments {
+			x.useType(arg)
+		}
+		if t.Returns != nil {
+			x.useType(*t.Returns)
+		}
+	case Array:
+		x.useType(t.Elem)
+	case Slice:
+		x.useType(t.Elem)
+	case Pointer:
+		x.useType(t.Elem)
+	case Struct:
+		for _, field := range t {
+			x.useType(field.Kind)
+		}
+	case Sum:
+		for _, variant := range t {
+			if variant.Payload != nil {
+				x.useType(*variant.Payload)
+			}
+		}
+	case Interface:
+		for _, method := range t {
+			for _, arg := range method.Arguments {
+				x.useType(arg.Kind)
+			}
+			if method.Returns != nil {
+				x.useType(*method.Returns)
+			}
+		}
+	}
+}
+
+// typeParams declares the type parameters of a generic function or type.
+func (x *indexer) typeParams(params []Identifier) {
+	for _, param := range params {
+		x.declare(&lspSymbol{Name: param.Name, Kind: symbolType, Pos: param.Pos, Detail: "type parameter " + param.Name})
+	}
+}
+
+func (x *indexer) fn(fn Func, until Position) {
+	x.push(until)
+	x.typeParams(fn.TypeParams)
+	if recv := fn.Receiver; recv != nil {
+		x.use(recv.Kind)
+		x.declare(&lspSymbol{Name: recv.Ident.Name, Kind: symbolValue, Pos: recv.Ident.Pos, Detail: recv.Ident.Name + ": " + recv.Kind.Name})
+	}
+	for _, arg := range fn.Arguments {
+		x.useType(arg.Kind)
+		x.declare(&lspSymbol{Name: arg.Ident.Name, Kind: symbolValue, Pos: arg.Ident.Pos, Detail: arg.Ident.Name + ": " + typeToString(&arg.Kind)})
+	}
+	if fn.Returns != nil {
+		x.useType(*fn.Returns)
+	}
+	x.expr(fn.Expr, until)
+	x.pop()
+}
+
+func declarationDetail(keyword string, name Identifier, value Expression) string {
+	if kind := typeOf(value); kind != typeInvalid {
+		return keyword + " " + name.Name + ": " + kind.String()
+	}
+	return keyword + " " + name.Name
+}
+
+// scopeEnd returns where scopes inside of a statement spanning s end.
+func scopeEnd(s Span, until Position) Position {
+	if s.To.Line != 0 && before(s.To, until) {
+		return s.To
+	}
+	return until
+}
+
+// expr indexes an expression. scopes nested inside of it end at until.
+func (x *indexer) expr(e Expression, until Position) {
+	switch e := e.(type) {
+	case Typed:
+		x.expr(e.Expr, until)
+	case Box:
+		x.expr(e.Of, until)
+	case Lit:
+		if lit, ok := e.Literal.(StructLiteral); ok {
+			x.use(lit.Ident)
+			for _, arg := range lit.TypeArguments {
+				x.useType(arg)
+			}
+			for _, field := range lit.Fields {
+				x.expr(field, until)
+			}
+		}
+		if lit, ok := e.Literal.(ArrayLiteral); ok {
+			x.useType(lit.Kind)
+			for _, elem := range lit.Elements {
+				x.expr(elem, until)
+			}
+		}
+	case Var:
+		x.use(Identifier(e))
+	case Declaration:
+		x.expr(e.Value, until)
+		x.declare(&lspSymbol{Name: e.To.Name, Kind: symbolValue, Pos: e.To.Pos, Detail: declarationDetail("let", e.To, e.Value)})
+	case MutDeclaration:
+		x.expr(e.Value, until)
+		x.declare(&lspSymbol{Name: e.To.Name, Kind: symbolMutable, Pos: e.To.Pos, Detail: declarationDetail("var", e.To, e.Value)})
+	case Field:
+		x.expr(e.Of, until)
+	case Assignment:
+		x.use(e.To)
+		x.expr(e.Value, until)
+	case FieldAssignment:
+		x.expr(e.Struct, until)
+		x.expr(e.Value, until)
+	case Index:
+		x.expr(e.Of, until)
+		x.expr(e.Index, until)
+	case IndexAssignment:
+		x.expr(e.Of, until)
+		x.expr(e.Index, until)
+		x.expr(e.Value, until)
+	case Subslice:
+		x.expr(e.Of, until)
+		x.expr(e.Low, until)
+		x.expr(e.High, until)
+	case DerefAssignment:
+		x.expr(e.Of, until)
+		x.expr(e.Value, until)
+	case New:
+		if e.Value != nil {
+			x.expr(e.Value, until)
+		} else {
+			x.useType(e.Kind)
+		}
+	case Call:
+		x.use(e.Function)
+		for _, arg := range e.TypeArguments {
+			x.useType(arg)
+		}
+		for _, arg := range e.Arguments {
+			x.expr(arg, until)
+		}
+	case CallValue:
+		x.expr(e.Callee, until)
+		for _, arg := range e.Arguments {
+			x.expr(arg, until)
+		}
+	case Lambda:
+		x.fn(Func{Arguments: e.Arguments, Returns: e.Returns, Expr: e.Expr}, scopeEnd(e.Pos, until))
+	case MethodCall:
+		x.expr(e.Of, until)
+		if named, ok := typeOf(e.Of).(*namedType); ok {
+			x.use(Identifier{named.name + "." + e.Method.Name, e.Method.Pos})
+		}
+		for _, arg := range e.Arguments {
+			x.expr(arg, until)
+		}
+	case Block:
+		x.push(until)
+		for i, stmt := range e {
+			next := until
+			if i+1 < len(e) {
+				if start := posOf(e[i+1]).From; start.Line != 0 {
+					next = start
+				}
+			}
+			x.expr(stmt, next)
+		}
+		x.pop()
+	case If:
+		until = scopeEnd(e.Pos, until)
+		x.expr(e.Condition, until)
+		x.expr(e.Then, until)
+		x.expr(e.Else, until)
+	case Match:
+		until = scopeEnd(e.Pos, until)
+		x.expr(e.Of, until)
+		sum, _ := underlying(typeOf(e.Of)).(*sumType)
+		for i, arm := range e.Arms {
+			// a binding is in scope until the next arm
+			next := until
+			if i+1 < len(e.Arms) && e.Arms[i+1].Variant.Pos.From.Line != 0 {
+				next = e.Arms[i+1].Variant.Pos.From
+			}
+			x.use(arm.Variant)
+			x.push(next)
+			if arm.Binding != nil {
+				detail := "let " + arm.Binding.Name
+				if sum != nil {
+					if _, payload := sum.variant(arm.Variant.Name); payload != nil {
+						detail += ": " + payload.String()
+					}
+				}
+				x.declare(&lspSymbol{Name: arm.Binding.Name, Kind: symbolValue, Pos: arm.Binding.Pos, Detail: detail})
+			}
+			x.expr(arm.Body, next)
+			x.pop()
+		}
+	case Binary:
+		x.expr(e.Left, until)
+		x.expr(e.Right, until)
+	case Unary:
+		x.expr(e.Of, until)
+	case While:
+		until = scopeEnd(e.Pos, until)
+		x.expr(e.Condition, until)
+		x.expr(e.Body, until)
+	case For:
+		until = scopeEnd(e.Pos, until)
+		x.expr(e.From, until)
+		x.expr(e.To, until)
+		x.push(until)
+		x.declare(&lspSymbol{Name: e.Ident.Name, Kind: symbolValue, Pos: e.Ident.Pos, Detail: declarationDetail("let", e.Ident, e.From)})
+		x.expr(e.Body, until)
+		x.pop()
+	}
+}
+
+// symbolAt returns the symbol declared or referred to at p.
+func (idx *symbolIndex) symbolAt(p Position) *lspSymbol {
+	for _, ref := range idx.refs {
+		if spanContains(ref.Pos, p) {
+			return ref.Symbol
+		}
+	}
+	for _, sym := range idx.globals {
+		if spanContains(sym.Pos, p) {
+			return sym
+		}
+	}
+	for _, l := range idx.locals {
+		if spanContains(l.Symbol.Pos, p) {
+			return l.Symbol
+		}
+	}
+	return nil
+}
+
+// inScope returns the names that can be used at p, innermost first.
+func (idx *symbolIndex) inScope(p Position) []*lspSymbol {
+	var syms []*lspSymbol
+	seen := map[string]bool{}
+
+	// later locals shadow earlier ones
+	for i := len(idx.locals) - 1; i >= 0; i-- {
+		l := idx.locals[i]
+		declared := l.Symbol.Pos.To
+		if declared.Filename != p.Filename || !before(declared, p) || before(l.until, p) {
+			continue
+		}
+		if !seen[l.Symbol.Name] {
+			seen[l.Symbol.Name] = true
+			syms = append(syms, l.Symbol)
+		}
+	}
+
+	var globals []*lspSymbol
+	for name, sym := range idx.globals {
+		// methods are only in scope after a value of their type
+		if strings.Contains(name, ".") {
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			globals = append(globals, sym)
+		}
+	}
+	sort.Slice(globals, func(i, j int) bool { return globals[i].Name < globals[j].Name })
+	syms = append(syms, globals...)
+
+	for _, sym := range idx.universe {
+		if !seen[sym.Name] {
+			syms = append(syms, sym)
+		}
+	}
+	return syms
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLSPAnalysis(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tawa-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	other := filepath.Join(dir, "Other.Tawa Source File")
+	err = ioutil.WriteFile(other, []byte("func add(a: int64, b: int64) int64 => a + b\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the open document hasn't been saved, so only the server knows about it
+	path := filepath.Join(dir, "Main.Tawa Source File")
+	s := newLSPServer(strings.NewReader(""), ioutil.Discard, settings{})
+	s.docs[path] = []byte("func main() {\n    var total = add(1, 2)\n    while total > 2 {\n        let inner = 5\n        total = inner\n    }\n    \n}\n")
+	a := s.analyze(dir)
+
+	if diags := a.diags.List(); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+
+	loc, ok := a.definition(a.position(path, lspPosition{1, 17})).(lspLocation)
+	if !ok || loc.URI != pathToURI(other) || loc.Range.Start != (lspPosition{0, 5}) {
+		t.Errorf("wrong definition of add: %+v", loc)
+	}
+
+	hover, ok := a.hover(a.position(path, lspPosition{4, 9})).(lspHover)
+	if !ok || !strings.Contains(hover.Contents.Value, "var total: int64") {
+		t.Errorf("wrong hover for total: %+v", hover)
+	}
+
+	names := func(line, character int) map[string]bool {
+		found := map[string]bool{}
+		for _, item := range a.completion(a.position(path, lspPosition{line, character})) {
+			found[item.Label] = true
+		}
+		return found
+	}
+	if found := names(4, 8); !found["inner"] || !found["total"] || !found["add"] || !found["print"] {
+		t.Errorf("missing completions inside the loop: %v", found)
+	}
+	if found := names(6, 4); found["inner"] || !found["total"] {
+		t.Errorf("wrong completions after the loop: %v", found)
+	}
+
+	symbols := a.documentSymbols(path)
+	if want := (lspRange{lspPosition{0, 0}, lspPosition{7, 1}}); len(symbols) != 1 || symbols[0].Range != want {
+		t.Errorf("expected main to span %+v, got %+v", want, symbols)
+	}
+}
@@ -1,55 +1,233 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/alecthomas/repr"
 	"github.com/urfave/cli/v2"
-	"github.com/ztrue/tracerr"
 	"gopkg.in/yaml.v2"
 )
 
-func parseDirectory(dir string) []TopLevel {
-	var t []TopLevel
-
-	fis, err := ioutil.ReadDir("./")
+// sourceFiles lists the Tawa source files of the package in dir.
+func sourceFiles(dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
 	if err != nil {
-		tracerr.PrintSourceColor(err)
-		os.Exit(1)
+		return nil, err
 	}
 
+	var files []string
 	for _, fi := range fis {
 		if strings.HasSuffix(fi.Name(), ".Tawa Source File") {
-			handle, err := os.Open(fi.Name())
-			if err != nil {
-				tracerr.PrintSourceColor(err)
-				os.Exit(1)
-			}
-
-			l := NewLexer(handle, fi.Name())
-			p := NewParser(l)
-			err = p.Parse()
-
-			if err != nil {
-				tracerr.PrintSourceColor(err)
-				os.Exit(1)
-			}
-
-			t = append(t, p.ast.Toplevels...)
+			files = append(files, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return files, nil
+}
+
+// parseFile parses a single source file, reporting any problems to diags.
+func parseFile(path string, src []byte, diags *Diagnostics) AST {
+	diags.AddSource(path, src)
+
+	l := NewLexer(bytes.NewReader(src), path)
+	l.diags = diags
+	p := NewParser(l)
+	p.Parse()
+
+	return p.ast
+}
+
+func parseDirectory(dir string, diags *Diagnostics) []TopLevel {
+	var t []TopLevel
+
+	files, err := sourceFiles(dir)
+	if err != nil {
+		diags.AddError(err)
+		return nil
+	}
+
+	for _, path := range files {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			diags.AddError(err)
+			continue
 		}
+
+		t = append(t, parseFile(path, src, diags).Toplevels...)
 	}
 
 	return t
 }
 
-type tawaModule struct {
-	Package string `yaml:"Package"`
+// checkPackage parses and type checks the package in dir. the package is only
+// checked if it parsed without errors, so that code that couldn't be parsed
+// doesn't cause confusing errors of its own.
+func checkPackage(dir string, sets settings, diags *Diagnostics) []TopLevel {
+	t := parseDirectory(dir, diags)
+	if diags.HasErrors() {
+		return nil
+	}
+
+	return check(t, sets, diags)
+}
+
+// formatPath formats a file, either printing the result, writing it back or
+// just checking whether the file is formatted already. false is returned for
+// files that couldn't be parsed or, when checking, aren't formatted.
+func formatPath(path string, write, check bool) (bool, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	diags := &Diagnostics{}
+	ast := parseFile(path, src, diags)
+	if diags.HasErrors() {
+		diags.Report(os.Stderr)
+		return false, nil
+	}
+
+	formatted := formatFile(ast)
+	switch {
+	case check:
+		if !bytes.Equal(src, formatted) {
+			fmt.Println(path)
+			return false, nil
+		}
+	case write:
+		if !bytes.Equal(src, formatted) {
+			return true, ioutil.WriteFile(path, formatted, 0644)
+		}
+	default:
+		os.Stdout.Write(formatted)
+	}
+
+	return true, nil
+}
+
+var diagnosticsFormatFlag = &cli.StringFlag{
+	Name:  "diagnostics-format",
+	Usage: "how to print errors: human or json",
+	Value: "human",
+}
+
+// reportDiagnostics prints everything that was reported while compiling, and
+// exits if any of it was an error. json diagnostics go to stdout, so that they
+// don't get mixed up with anything else the compiler prints.
+func reportDiagnostics(diags *Diagnostics, format string) {
+	switch format {
+	case "json":
+		if err := diags.ReportJSON(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "error: writing diagnostics: %s\n", err)
+			os.Exit(1)
+		}
+	default:
+		diags.Report(os.Stderr)
+	}
+	if diags.HasErrors() {
+		os.Exit(1)
+	}
+}
+
+var importPathFlag = &cli.StringSliceFlag{
+	Name:    "import-path",
+	Usage:   "a directory to look for imported packages in",
+	EnvVars: []string{"TAWA_PATH"},
+}
+
+var memoryFlag = &cli.StringFlag{
+	Name:  "memory",
+	Usage: "how heap memory is managed: manual, where free releases it, or gc, where a garbage collector does",
+	Value: memoryManual,
+}
+
+func checkMemory(c *cli.Context) error {
+	switch memory := c.String("memory"); memory {
+	case memoryManual, memoryGC:
+		return nil
+	default:
+		return fmt.Errorf("unknown memory management %q, expected manual or gc", memory)
+	}
+}
+
+const (
+	libraryShared = "shared"
+	libraryStatic = "static"
+)
+
+// libraryKind is the value of --library, which builds a shared library when
+// it's given without one.
+type libraryKind string
+
+func (k *libraryKind) Set(value string) error {
+	switch value {
+	case "true", libraryShared:
+		*k = libraryShared
+	case libraryStatic:
+		*k = libraryStatic
+	case "false":
+		*k = ""
+	default:
+		return fmt.Errorf("unknown kind of library %q, expected shared or static", value)
+	}
+	return nil
+}
+
+func (k *libraryKind) String() string {
+	return string(*k)
+}
+
+// IsBoolFlag lets --library be given without a value, like a boolean flag.
+func (k *libraryKind) IsBoolFlag() bool {
+	return true
+}
+
+const (
+	emitLink   = "link"
+	emitObject = "obj"
+)
+
+var emitFlag = &cli.StringFlag{
+	Name:  "emit",
+	Usage: "what to build: link, a linked program or library, or obj, an object file to link yourself",
+	Value: emitLink,
+}
+
+func checkEmit(c *cli.Context) error {
+	switch emit := c.String("emit"); emit {
+	case emitLink, emitObject:
+		return nil
+	default:
+		return fmt.Errorf("unknown output %q, expected link or obj", emit)
+	}
+}
+
+// run runs a tool the compiler builds with, and exits if it fails.
+func run(doing string, name string, args ...string) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s failed: %s\n", doing, err)
+		os.Exit(1)
+	}
+}
+
+func checkDiagnosticsFormat(c *cli.Context) error {
+	switch format := c.String("diagnostics-format"); format {
+	case "human", "json":
+		return nil
+	default:
+		return fmt.Errorf("unknown diagnostics format %q, expected human or json", format)
+	}
 }
 
 func main() {
@@ -57,7 +235,7 @@ func main() {
 		Name:  "tawago",
 		Usage: "tawa compiler",
 		ExitErrHandler: func(context *cli.Context, err error) {
-			log.Fatal("error with tawac: %w", err)
+			log.Fatalf("error with tawac: %s", err)
 		},
 		Commands: []*cli.Command{
 			{
@@ -107,6 +285,107 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:  "check",
+				Usage: "check a package for errors without building it",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "force-import",
+						Value: cli.NewStringSlice(),
+					},
+					importPathFlag,
+					diagnosticsFormatFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if err := checkDiagnosticsFormat(c); err != nil {
+						return err
+					}
+					diags := &Diagnostics{}
+					checkPackage("./", settings{
+						forceimportlibs: c.StringSlice("force-import"),
+						dir:             "./",
+						importPaths:     c.StringSlice("import-path"),
+					}, diags)
+					reportDiagnostics(diags, c.String("diagnostics-format"))
+
+					return nil
+				},
+			},
+			{
+				Name:      "fmt",
+				Usage:     "format source files",
+				ArgsUsage: "[files or packages]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "w",
+						Usage: "write the formatted source back to the files",
+					},
+					&cli.BoolFlag{
+						Name:  "check",
+						Usage: "list files that aren't formatted, and fail if there are any",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					paths := c.Args().Slice()
+					if len(paths) == 0 {
+						paths = []string{"./"}
+					}
+
+					var files []string
+					for _, path := range paths {
+						fi, err := os.Stat(path)
+						if err != nil {
+							return err
+						}
+						if !fi.IsDir() {
+							files = append(files, path)
+							continue
+						}
+						inDir, err := sourceFiles(path)
+						if err != nil {
+							return err
+						}
+						files = append(files, inDir...)
+					}
+
+					failed := false
+					for _, file := range files {
+						ok, err := formatPath(file, c.Bool("w"), c.Bool("check"))
+						if err != nil {
+							return err
+						}
+						if !ok {
+							failed = true
+						}
+					}
+					if failed {
+						os.Exit(1)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "lsp",
+				Usage: "run a language server over stdin and stdout",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "force-import",
+						Value: cli.NewStringSlice(),
+					},
+					importPathFlag,
+				},
+				Action: func(c *cli.Context) error {
+					s := newLSPServer(os.Stdin, os.Stdout, settings{
+						forceimportlibs: c.StringSlice("force-import"),
+						importPaths:     c.StringSlice("import-path"),
+					})
+					if code := s.serve(); code != 0 {
+						os.Exit(code)
+					}
+					return nil
+				},
+			},
 			{
 				Name:  "build",
 				Usage: "build a file",
@@ -118,26 +397,35 @@ func main() {
 						Name:  "dump",
 						Value: false,
 					},
-					&cli.BoolFlag{
+					&cli.GenericFlag{
 						Name:  "library",
-						Value: false,
+						Usage: "build a library instead of a program: shared, the default, or static. packages are imported from whichever one they've been built as, and can't be while they've been built as both",
+						Value: new(libraryKind),
 					},
+					emitFlag,
 					&cli.StringSliceFlag{
 						Name:  "force-import",
 						Value: cli.NewStringSlice(),
 					},
+					importPathFlag,
+					memoryFlag,
+					diagnosticsFormatFlag,
 				},
 				Action: func(c *cli.Context) error {
-					out := c.String("output")
-
-					data, err := ioutil.ReadFile("Tawa Module Information")
-					if err != nil {
-						fmt.Printf("error reading Tawa Module Information: %s", err)
-						os.Exit(1)
+					if err := checkDiagnosticsFormat(c); err != nil {
+						return err
+					}
+					if err := checkMemory(c); err != nil {
+						return err
 					}
+					if err := checkEmit(c); err != nil {
+						return err
+					}
+					library := *c.Generic("library").(*libraryKind)
+					emit := c.String("emit")
+					out := c.String("output")
 
-					var doc tawaModule
-					err = yaml.Unmarshal(data, &doc)
+					doc, err := readModule("./")
 					if err != nil {
 						fmt.Printf("error reading Tawa Module Information: %s", err)
 						os.Exit(1)
@@ -145,38 +433,39 @@ func main() {
 					if out == "" {
 						out = doc.Package
 					}
-					if c.Bool("library") {
-						out += ".Dynamically Linked Tawa Module"
+					switch {
+					case emit == emitObject:
+						out += ".o"
+					case library == libraryShared:
+						out += libraryExtension
+					case library == libraryStatic:
+						out += staticLibraryExtension
 					}
 
-					t := parseDirectory("./")
+					var module string
+					sets := settings{
+						isLibrary:       library != "",
+						packageName:     doc.Package,
+						forceimportlibs: c.StringSlice("force-import"),
+						dir:             "./",
+						importPaths:     c.StringSlice("import-path"),
+						memory:          c.String("memory"),
+					}
 
-					module := codegen(
-						t,
-						settings{
-							isLibrary:       c.Bool("library"),
-							packageName:     doc.Package,
-							forceimportlibs: c.StringSlice("force-import"),
-						},
-					).String()
+					diags := &Diagnostics{}
+					t := checkPackage("./", sets, diags)
+					if !diags.HasErrors() {
+						if modu := codegen(t, sets, diags); modu != nil {
+							module = modu.String()
+						}
+					}
+					reportDiagnostics(diags, c.String("diagnostics-format"))
 
 					if c.Bool("dump") {
 						println(module)
 						os.Exit(0)
 					}
 
-					cmd := exec.Command("clang", "-nostdlib", "-lmimalloc", "-o", out)
-
-					for _, lib := range c.StringSlice("force-import") {
-						cmd.Args = append(cmd.Args, lib)
-					}
-
-					if c.Bool("library") {
-						cmd.Args = append(cmd.Args, "-shared", "-no-pie")
-					} else {
-						cmd.Args = append(cmd.Args, "-Wl,-e,_tawa_main")
-					}
-
 					fi, err := ioutil.TempFile("/tmp", "*.ll")
 					if err != nil {
 						return err
@@ -187,17 +476,58 @@ func main() {
 						return err
 					}
 
-					cmd.Args = append(cmd.Args, fi.Name())
+					// static libraries are archives of the object the
+					// package is compiled to, which aren't linked with
+					// anything until a program is
+					if emit == emitObject || library == libraryStatic {
+						obj := out
+						if emit != emitObject {
+							dir, err := ioutil.TempDir("", "tawa")
+							if err != nil {
+								return err
+							}
+							defer os.RemoveAll(dir)
+							obj = filepath.Join(dir, doc.Package+".o")
+						}
+						run("compiling "+obj, "clang", "-c", "-fPIC", "-o", obj, fi.Name())
+						if emit == emitObject {
+							return nil
+						}
+
+						os.Remove(out)
+						run("archiving "+out, "ar", "rcs", out, obj)
+						return nil
+					}
+
+					cmd := []string{"clang", "-nostdlib", "-lmimalloc", "-o", out}
+
+					if library == libraryShared {
+						cmd = append(cmd, "-shared", "-no-pie")
+					} else {
+						cmd = append(cmd, "-Wl,-e,_tawa_main")
+					}
+					if c.String("memory") == memoryGC && library == "" {
+						// libraries allocate through the collector of the
+						// program they're loaded into
+						cmd = append(cmd, "-rdynamic")
+					}
 
-					cmd.Stdout = os.Stdout
-					cmd.Stderr = os.Stderr
+					cmd = append(cmd, fi.Name())
 
-					err = cmd.Run()
+					// libraries come after the package, so that what it
+					// needs from static ones is taken from them. this also
+					// puts its type info first in the section, which the
+					// type info of static ones is appended to
+					libs := append([]string{}, c.StringSlice("force-import")...)
+					libs = append(libs, importedLibraries(t)...)
+					linked, err := linkedLibraries(libs, sets)
 					if err != nil {
-						tracerr.PrintSourceColor(err)
+						fmt.Fprintf(os.Stderr, "error: %s\n", err)
 						os.Exit(1)
 					}
+					cmd = append(cmd, linked...)
 
+					run("linking "+out, cmd[0], cmd[1:]...)
 					return nil
 				},
 			},
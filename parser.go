@@ -1,121 +1,349 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"go/constant"
 	"strconv"
-
-	"github.com/ztrue/tracerr"
+	"strings"
+	"unicode/utf8"
 )
 
 type Parser struct {
 	l   *Lexer
 	ast AST
+
+	// noStructLiteral is set while parsing the header of a loop or match,
+	// where a { after an identifier starts the body instead of a struct
+	// literal.
+	noStructLiteral bool
 }
 
 func NewParser(l *Lexer) Parser {
 	a := AST{}
-	return Parser{l, a}
+	return Parser{l: l, ast: a}
+}
+
+// Parse parses the top levels of a file. errors are reported to the lexer's
+// diagnostics and parsing resumes at the next top level, so that as many
+// errors as possible are found in one go. the first error is returned.
+func (p *Parser) Parse() error {
+	reported := len(p.l.diags.List())
+
+	for !p.l.PeekIs(EOF) {
+		p.parseToplevel()
+	}
+	p.ast.Comments = p.l.comments
+
+	if diags := p.l.diags.List(); len(diags) > reported {
+		return diags[reported]
+	}
+	return nil
+}
+
+// report turns something the parser panicked with into a diagnostic.
+func (p *Parser) report(r interface{}) {
+	err, ok := r.(error)
+	if !ok {
+		panic(r)
+	}
+	p.l.diags.AddError(err)
+}
+
+// syncToplevel skips tokens until the start of the next top level.
+func (p *Parser) syncToplevel() {
+	for !p.l.PeekIs(EOF, FUNC, TYPE, IMPORT) {
+		p.l.Lex()
+	}
+}
+
+// syncStatement skips tokens until the end of the statement that started
+// when depth braces were open, leaving the closing brace of the enclosing
+// block for it to consume. braces the statement opened before the error are
+// skipped along with it, so that the closing brace of a literal isn't taken
+// for the end of the block.
+func (p *Parser) syncStatement(depth int) {
+	for {
+		tok, _ := p.l.Peek()
+		switch {
+		case tok.Kind == EOF:
+			return
+		case tok.Kind == RBRACKET && p.l.depth <= depth:
+			return
+		case tok.Kind == EOS && p.l.depth <= depth:
+			p.l.Lex()
+			return
+		}
+		p.l.Lex()
+	}
 }
 
-func (p *Parser) Parse() (err error) {
+func (p *Parser) parseToplevel() {
 	defer func() {
 		if r := recover(); r != nil {
-			rerr, ok := r.(error)
-			if ok {
-				err = tracerr.Wrap(rerr)
-			} else {
-				panic(r)
-			}
+			p.report(r)
+			p.syncToplevel()
 		}
 	}()
+
+	tok, _ := p.l.Lex()
+
+	switch tok.Kind {
+	case IMPORT:
+		p.parseImport(tok)
+	case TYPE:
+		doc := p.l.tokenDoc
+		nameTok, name := p.l.LexExpecting(IDENT)
+		params := p.parseTypeParams()
+		var kind Type
+		if p.l.PeekIs(EQUALS) {
+			p.l.LexExpecting(EQUALS)
+			kind = p.parseSum()
+		} else if p.l.PeekIs(INTERFACE) {
+			kind = p.parseInterface()
+		} else {
+			kind = p.parseType()
+		}
+		p.ast.Toplevels = append(p.ast.Toplevels, TypeDeclaration{
+			Ident:      Identifier{name, nameTok.Location},
+			TypeParams: params,
+			Kind:       kind,
+			Doc:        doc,
+		})
+	case FUNC:
+		doc := p.l.tokenDoc
+		var receiver *Receiver
+		if p.l.PeekIs(LPAREN) {
+			receiver = p.parseReceiver()
+		}
+		nameTok, name := p.l.LexExpecting(IDENT)
+		params := p.parseTypeParams()
+		arguments := p.parseParameters()
+		ret := p.parseReturns()
+
+		var expr Expression
+		if !p.l.PeekIs(FATARROW, LBRACKET) {
+			tok, _ := p.l.Peek()
+			panic(ExpectedOneOfKindGotKind{
+				Expected: []TokenKind{FATARROW, LBRACKET},
+				Got:      tok.Kind,
+				Location: tok.Location,
+			})
+		}
+		if p.l.PeekIs(FATARROW) {
+			p.l.LexExpecting(FATARROW)
+			expr = p.parseExpression()
+		} else {
+			p.l.LexExpecting(LBRACKET)
+			expr = p.parseBlock()
+		}
+		p.ast.Toplevels = append(p.ast.Toplevels, Func{
+			Ident:      Identifier{name, nameTok.Location},
+			Receiver:   receiver,
+			TypeParams: params,
+			Arguments:  arguments,
+			Returns:    ret,
+			Expr:       expr,
+			Doc:        doc,
+			Pos:        Span{tok.Location.From, p.l.lastEnd},
+		})
+		p.l.LexExpecting(EOS)
+	case EOS:
+	default:
+		panic(ExpectedOneOfKindGotKind{
+			Expected: []TokenKind{FUNC, TYPE, IMPORT},
+			Got:      tok.Kind,
+			Location: tok.Location,
+		})
+	}
+}
+
+// parseTypeParams parses the type parameters of a generic function or type
+// like [K, V], if it has any.
+func (p *Parser) parseTypeParams() []Identifier {
+	if !p.l.PeekIs(LSQUARE) {
+		return nil
+	}
+
+	var params []Identifier
+	p.l.LexExpecting(LSQUARE)
 	for {
-		tok, _ := p.l.Lex()
+		tok, name := p.l.LexExpecting(IDENT)
+		params = append(params, Identifier{name, tok.Location})
 
-		if tok.Kind == EOF {
-			return
+		if p.l.PeekIs(RSQUARE) {
+			break
 		}
+		p.l.LexExpecting(COMMA)
+	}
+	p.l.LexExpecting(RSQUARE)
+
+	return params
+}
+
+// parseTypeArguments parses the type arguments of a generic function or type
+// like [int64, string].
+func (p *Parser) parseTypeArguments() []Type {
+	var args []Type
+	p.l.LexExpecting(LSQUARE)
+	for {
+		args = append(args, p.parseType())
+
+		if p.l.PeekIs(RSQUARE) {
+			break
+		}
+		p.l.LexExpecting(COMMA)
+	}
+	p.l.LexExpecting(RSQUARE)
+
+	return args
+}
+
+// parseArrayType parses an array type like [4]byte or a slice type like
+// []byte, after the opening [.
+func (p *Parser) parseArrayType() Type {
+	if p.l.PeekIs(RSQUARE) {
+		p.l.LexExpecting(RSQUARE)
+		return Slice{p.parseType()}
+	}
 
+	tok, lit := p.l.LexExpecting(INT)
+	length, fits := parseInteger(tok, lit)
+	if !fits {
+		panic(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrConstantOverflow,
+			Location: tok.Location,
+			Message:  fmt.Sprintf("array length %s doesn't fit in 64 bits", lit),
+		})
+	}
+	p.l.LexExpecting(RSQUARE)
+	return Array{int64(length), p.parseType()}
+}
+
+// typeArgumentsFollow reports whether the [ that comes next starts type
+// arguments rather than an index, which is the case when the matching ] is
+// followed by the arguments of a call or the fields of a struct literal.
+// calling an element like a[i](x) needs parentheses around a[i].
+func (p *Parser) typeArgumentsFollow() bool {
+	depth := 0
+	for n := 0; ; n++ {
+		tok, _ := p.l.PeekAt(n)
 		switch tok.Kind {
-		case IMPORT:
-			p.parseImport()
-		case TYPE:
-			_, name := p.l.LexExpecting(IDENT)
-			p.ast.Toplevels = append(p.ast.Toplevels, TypeDeclaration{
-				Ident: NewID(name),
-				Kind:  p.parseType(),
-			})
-		case FUNC:
-			_, name := p.l.LexExpecting(IDENT)
-			var arguments []struct {
+		case LSQUARE:
+			depth++
+		case RSQUARE:
+			depth--
+			if depth == 0 {
+				next, _ := p.l.PeekAt(n + 1)
+				return next.Kind == LPAREN || next.Kind == LBRACKET && !p.noStructLiteral
+			}
+		case EOF:
+			return false
+		}
+	}
+}
+
+// parseParameters parses the parameters of a function like (a: int64, b: bool).
+func (p *Parser) parseParameters() []struct {
+	Ident Identifier
+	Kind  Type
+} {
+	var arguments []struct {
+		Ident Identifier
+		Kind  Type
+	}
+
+	p.l.LexExpecting(LPAREN)
+	if !p.l.PeekIs(RPAREN) {
+		for {
+			argTok, name := p.l.LexExpecting(IDENT)
+			p.l.LexExpecting(COLON)
+			kind := p.parseType()
+
+			arguments = append(arguments, struct {
 				Ident Identifier
 				Kind  Type
+			}{
+				Ident: Identifier{name, argTok.Location},
+				Kind:  kind,
+			})
+
+			if p.l.PeekIs(RPAREN) {
+				break
 			}
 
-			p.l.LexExpecting(LPAREN)
-			if !p.l.PeekIs(RPAREN) {
-				for {
-					_, name := p.l.LexExpecting(IDENT)
-					p.l.LexExpecting(COLON)
-					kind := p.parseType()
-
-					arguments = append(arguments, struct {
-						Ident Identifier
-						Kind  Type
-					}{
-						Ident: NewID(name),
-						Kind:  kind,
-					})
-
-					if p.l.PeekIs(COMMA, RPAREN) {
-						if p.l.PeekIs(RPAREN) {
-							break
-						}
-						continue
-					}
+			p.l.LexExpecting(COMMA)
+		}
+	}
+	p.l.LexExpecting(RPAREN)
 
-					p.l.LexExpecting(COMMA, RPAREN)
-				}
-			}
-			p.l.LexExpecting(RPAREN)
+	return arguments
+}
 
-			var ret *Type
-			if p.l.PeekIs(IDENT, FUNC, STRUCT) {
-				t := p.parseType()
-				ret = &t
-			}
-			var expr Expression
-			if !p.l.PeekIs(FATARROW, LBRACKET) {
-				tok, _ := p.l.Peek()
-				panic(ExpectedOneOfKindGotKind{
-					Expected: []TokenKind{FATARROW, LBRACKET},
-					Got:      tok.Kind,
-					Location: tok.Location,
-				})
-			}
-			if p.l.PeekIs(FATARROW) {
-				p.l.LexExpecting(FATARROW)
-				expr = p.parseExpression()
-			} else {
-				p.l.LexExpecting(LBRACKET)
-				expr = p.parseBlock()
-			}
-			p.ast.Toplevels = append(p.ast.Toplevels, Func{
-				Ident:     NewID(name),
-				Arguments: arguments,
-				Returns:   ret,
-				Expr:      expr,
-			})
+// parseReturns parses the return type of a function if it has one.
+func (p *Parser) parseReturns() *Type {
+	if p.l.PeekIs(IDENT, FUNC, STRUCT, LSQUARE, STAR) {
+		t := p.parseType()
+		return &t
+	}
+	return nil
+}
+
+// parseInterface parses the methods of an interface type, one per line.
+func (p *Parser) parseInterface() Interface {
+	var iface Interface
+	p.l.LexExpecting(INTERFACE)
+	p.l.LexExpecting(LBRACKET)
+	for !p.l.PeekIs(RBRACKET) {
+		if p.l.PeekIs(EOS) {
+			p.l.LexExpecting(EOS)
+			continue
+		}
+
+		tok, name := p.l.LexExpecting(IDENT)
+		iface = append(iface, InterfaceMethod{
+			Ident:     Identifier{name, tok.Location},
+			Arguments: p.parseParameters(),
+			Returns:   p.parseReturns(),
+		})
+		if !p.l.PeekIs(RBRACKET) {
 			p.l.LexExpecting(EOS)
 		}
 	}
+	p.l.LexExpecting(RBRACKET)
+
+	return iface
+}
+
+// parseReceiver parses the receiver of a method like (m Melako).
+func (p *Parser) parseReceiver() *Receiver {
+	p.l.LexExpecting(LPAREN)
+	nameTok, name := p.l.LexExpecting(IDENT)
+	kindTok, kind := p.l.LexExpecting(IDENT)
+	p.l.LexExpecting(RPAREN)
+
+	return &Receiver{
+		Ident: Identifier{name, nameTok.Location},
+		Kind:  Identifier{kind, kindTok.Location},
+	}
 }
 
 type AST struct {
 	Toplevels []TopLevel
+	Comments  []Comment
 }
 
-func (p *Parser) parseImport() {
+// parseImport parses an import, which can give the package another name to
+// refer to it by, like import o `ooflib`.
+func (p *Parser) parseImport(tok Token) {
+	var alias *Identifier
+	if p.l.PeekIs(IDENT) {
+		tok, lit := p.l.LexExpecting(IDENT)
+		alias = &Identifier{lit, tok.Location}
+	}
 	_, path := p.l.LexExpecting(STRING)
-	p.ast.Toplevels = append(p.ast.Toplevels, Import(path))
+	p.ast.Toplevels = append(p.ast.Toplevels, Import{Path: unquote(path, nil), Alias: alias, Pos: Span{tok.Location.From, p.l.lastEnd}})
 	p.l.LexExpecting(EOS)
 }
 
@@ -123,27 +351,14 @@ func (p *Parser) parseImport() {
 func (p *Parser) parseBlock() Expression {
 	var statements []Expression
 
-	if !p.l.PeekIs(RBRACKET) {
-		for {
-			if p.l.PeekIs(EOS) {
-				p.l.LexExpecting(EOS)
-				continue
-			}
-
-			statements = append(statements, p.parseExpression())
-
-			if p.l.PeekIs(EOS, RBRACKET) {
-				if p.l.PeekIs(RBRACKET) {
-					break
-				}
-				p.l.LexExpecting(EOS)
-				if p.l.PeekIs(RBRACKET) {
-					break
-				}
-				continue
-			}
+	for !p.l.PeekIs(RBRACKET, EOF) {
+		if p.l.PeekIs(EOS) {
+			p.l.LexExpecting(EOS)
+			continue
+		}
 
-			p.l.LexExpecting(EOS, RBRACKET)
+		if statement := p.parseStatement(); statement != nil {
+			statements = append(statements, statement)
 		}
 	}
 	p.l.LexExpecting(RBRACKET)
@@ -151,6 +366,36 @@ func (p *Parser) parseBlock() Expression {
 	return Block(statements)
 }
 
+// parseStatement parses a statement in a block, along with the EOS ending it.
+// if the statement is malformed, the error is reported and nil is returned
+// with the parser moved on to the next statement.
+func (p *Parser) parseStatement() (statement Expression) {
+	depth := p.l.depth
+	defer func() {
+		if r := recover(); r != nil {
+			p.report(r)
+			p.syncStatement(depth)
+			statement = nil
+		}
+	}()
+
+	statement = p.parseExpression()
+	if !p.l.PeekIs(RBRACKET) {
+		p.l.LexExpecting(EOS)
+	}
+
+	return statement
+}
+
+// parseLoopHeader parses an expression that is followed by the body of a loop.
+func (p *Parser) parseLoopHeader() Expression {
+	noStructLiteral := p.noStructLiteral
+	p.noStructLiteral = true
+	defer func() { p.noStructLiteral = noStructLiteral }()
+
+	return p.parseExpression()
+}
+
 func (p *Parser) parseStructLiteral() (r map[string]Expression) {
 	r = map[string]Expression{}
 
@@ -171,7 +416,7 @@ func (p *Parser) parseStructLiteral() (r map[string]Expression) {
 			expr := p.parseExpression()
 
 			if _, ok := r[name]; ok {
-				panic(DuplicateField{
+				p.l.diags.AddError(DuplicateField{
 					Name:     name,
 					Location: tok.Location,
 				})
@@ -194,74 +439,244 @@ func (p *Parser) parseStructLiteral() (r map[string]Expression) {
 	return
 }
 
+// parseArrayElements parses the elements of an array literal, starting at the
+// opening brace.
+func (p *Parser) parseArrayElements() []Expression {
+	p.l.LexExpecting(LBRACKET)
+
+	noStructLiteral := p.noStructLiteral
+	p.noStructLiteral = false
+	defer func() { p.noStructLiteral = noStructLiteral }()
+
+	var elements []Expression
+	for !p.l.PeekIs(RBRACKET) {
+		if p.l.PeekIs(EOS) {
+			p.l.LexExpecting(EOS)
+			continue
+		}
+		elements = append(elements, p.parseExpression())
+		if !p.l.PeekIs(RBRACKET) {
+			p.l.LexExpecting(COMMA, EOS)
+		}
+	}
+	p.l.LexExpecting(RBRACKET)
+
+	return elements
+}
+
+// parseInteger parses a decimal, hexadecimal (0x), octal (0o) or binary (0b)
+// integer literal. fits is false for literals that don't fit in 64 bits, which
+// only constant expressions can have, since they're folded from how the
+// literals are written.
+func parseInteger(tok Token, lit string) (i Integer, fits bool) {
+	val := integerValue(lit)
+	if val.Kind() != constant.Int {
+		panic(Diagnostic{
+			Severity: SeverityError,
+			Code:     ErrInvalidLiteral,
+			Location: tok.Location,
+			Message:  fmt.Sprintf("invalid integer literal %s", lit),
+		})
+	}
+	parsed, fits := constant.Int64Val(val)
+	return Integer(parsed), fits
+}
+
+// parseChar decodes a character literal. a character written as a single
+// byte escape like '\xff' is that byte, rather than the character encoded
+// by it.
+func parseChar(lit string) Char {
+	value := unquote(lit, nil)
+	if len(value) == 1 {
+		return Char(value[0])
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	return Char(r)
+}
+
 func (p *Parser) parseExpressionLeaf() Expression {
-	tok, lit := p.l.LexExpecting(IDENT, IF, STRING, LBRACKET, INT, LET, VAR)
+	tok, lit := p.l.LexExpecting(IDENT, IF, STRING, LBRACKET, LPAREN, LSQUARE, INT, FLOAT, CHAR, LET, VAR, WHILE, FOR, MATCH, BREAK, CONTINUE, FUNC, NEW)
 
 	switch tok.Kind {
+	case LSQUARE:
+		lit := ArrayLiteral{Kind: p.parseArrayType()}
+		lit.Elements = p.parseArrayElements()
+
+		return Lit{Literal: lit, Pos: Span{tok.Location.From, p.l.lastEnd}}
+	case NEW:
+		from := p.peekPos()
+		n := New{Kind: p.parseType()}
+		if p.l.PeekIs(LBRACKET) && !p.noStructLiteral {
+			var lit Literal
+			switch kind := n.Kind.(type) {
+			case Ident:
+				lit = StructLiteral{Ident: Identifier(kind), Fields: p.parseStructLiteral()}
+			case Generic:
+				lit = StructLiteral{Ident: kind.Ident, TypeArguments: kind.Arguments, Fields: p.parseStructLiteral()}
+			default:
+				lit = ArrayLiteral{Kind: kind, Elements: p.parseArrayElements()}
+			}
+			n.Value = Lit{Literal: lit, Pos: Span{from, p.l.lastEnd}}
+		}
+
+		n.Pos = Span{tok.Location.From, p.l.lastEnd}
+		return n
+	case FUNC:
+		lambda := Lambda{Arguments: p.parseParameters(), Returns: p.parseReturns()}
+
+		noStructLiteral := p.noStructLiteral
+		p.noStructLiteral = false
+		if p.l.PeekIs(LBRACKET) {
+			p.l.LexExpecting(LBRACKET)
+			lambda.Expr = p.parseBlock()
+		} else {
+			p.l.LexExpecting(FATARROW)
+			lambda.Expr = p.parseExpression()
+		}
+		p.noStructLiteral = noStructLiteral
+
+		lambda.Pos = Span{tok.Location.From, p.l.lastEnd}
+		return lambda
+	case LPAREN:
+		noStructLiteral := p.noStructLiteral
+		p.noStructLiteral = false
+		expr := p.parseExpression()
+		p.noStructLiteral = noStructLiteral
+
+		p.l.LexExpecting(RPAREN)
+		return expr
+	case WHILE:
+		cond := p.parseLoopHeader()
+		p.l.LexExpecting(LBRACKET)
+		body := p.parseBlock()
+
+		return While{
+			Condition: cond,
+			Body:      body,
+			Pos:       Span{tok.Location.From, p.l.lastEnd},
+		}
+	case FOR:
+		identTok, ident := p.l.LexExpecting(IDENT)
+		p.l.LexExpecting(IN)
+		from := p.parseLoopHeader()
+		p.l.LexExpecting(DOTDOT)
+		to := p.parseLoopHeader()
+		p.l.LexExpecting(LBRACKET)
+		body := p.parseBlock()
+
+		return For{
+			Ident: Identifier{ident, identTok.Location},
+			From:  from,
+			To:    to,
+			Body:  body,
+			Pos:   Span{tok.Location.From, p.l.lastEnd},
+		}
+	case MATCH:
+		of := p.parseLoopHeader()
+		p.l.LexExpecting(LBRACKET)
+
+		noStructLiteral := p.noStructLiteral
+		p.noStructLiteral = false
+		defer func() { p.noStructLiteral = noStructLiteral }()
+
+		var arms []MatchArm
+		for !p.l.PeekIs(RBRACKET, EOF) {
+			if p.l.PeekIs(EOS) {
+				p.l.LexExpecting(EOS)
+				continue
+			}
+			arms = append(arms, p.parseMatchArm())
+			if !p.l.PeekIs(RBRACKET) {
+				p.l.LexExpecting(EOS, COMMA)
+			}
+		}
+		p.l.LexExpecting(RBRACKET)
+
+		return Match{Of: of, Arms: arms, Pos: Span{tok.Location.From, p.l.lastEnd}}
+	case BREAK:
+		return Break{Pos: tok.Location}
+	case CONTINUE:
+		return Continue{Pos: tok.Location}
 	case LET:
-		_, ident := p.l.LexExpecting(IDENT)
+		identTok, ident := p.l.LexExpecting(IDENT)
 		p.l.LexExpecting(EQUALS)
 		return Declaration{
-			To:    Identifier(NewID(ident)),
+			To:    Identifier{ident, identTok.Location},
 			Value: p.parseExpression(),
 		}
 	case VAR:
-		_, ident := p.l.LexExpecting(IDENT)
+		identTok, ident := p.l.LexExpecting(IDENT)
 		p.l.LexExpecting(EQUALS)
 		return MutDeclaration{
-			To:    Identifier(NewID(ident)),
+			To:    Identifier{ident, identTok.Location},
 			Value: p.parseExpression(),
 		}
 	case STRING:
-		return Lit{StringLiteral(lit)}
+		return Lit{Literal: StringLiteral(unquote(lit, nil)), Pos: tok.Location, Text: lit}
+	case CHAR:
+		return Lit{Literal: parseChar(lit), Pos: tok.Location, Text: lit}
 	case INT:
-		parsed, err := strconv.ParseInt(lit, 10, 64)
+		i, _ := parseInteger(tok, lit)
+		return Lit{Literal: i, Pos: tok.Location, Text: lit}
+	case FLOAT:
+		parsed, err := strconv.ParseFloat(lit, 64)
 		if err != nil {
-			panic(err)
+			diag := Diagnostic{
+				Severity: SeverityError,
+				Code:     ErrInvalidLiteral,
+				Location: tok.Location,
+				Message:  fmt.Sprintf("invalid floating point literal %s", lit),
+			}
+			if errors.Is(err, strconv.ErrRange) {
+				diag.Message = fmt.Sprintf("floating point literal %s is out of range", lit)
+			} else if strings.HasPrefix(strings.ToLower(lit), "0x") {
+				diag.Hints = []string{"hexadecimal floating point literals need a 'p' exponent, like 0x1.8p0"}
+			}
+			panic(diag)
 		}
-		return Lit{Integer(parsed)}
+		return Lit{Literal: Float(parsed), Pos: tok.Location, Text: lit}
 	case IDENT:
-		if !p.l.PeekIs(LPAREN, EQUALS, LBRACKET) {
-			return Var(NewID(lit))
+		if !p.l.PeekIs(LPAREN, EQUALS, LBRACKET, LSQUARE) || (p.noStructLiteral && p.l.PeekIs(LBRACKET)) {
+			return Var{lit, tok.Location}
+		}
+		if p.l.PeekIs(LSQUARE) && !p.typeArgumentsFollow() {
+			return Var{lit, tok.Location}
 		}
 
-		if p.l.PeekIs(LPAREN) {
-			p.l.LexExpecting(LPAREN)
-			var args []Expression
-
-			if !p.l.PeekIs(RPAREN) {
-				for {
-					args = append(args, p.parseExpression())
-
-					if p.l.PeekIs(COMMA, RPAREN) {
-						if p.l.PeekIs(RPAREN) {
-							break
-						}
-						continue
-					}
-
-					p.l.LexExpecting(COMMA, RPAREN)
-				}
+		var typeArgs []Type
+		if p.l.PeekIs(LSQUARE) {
+			typeArgs = p.parseTypeArguments()
+			if !p.l.PeekIs(LPAREN) && (p.noStructLiteral || !p.l.PeekIs(LBRACKET)) {
+				p.l.LexExpecting(LPAREN, LBRACKET)
 			}
-			p.l.LexExpecting(RPAREN)
+		}
+
+		if p.l.PeekIs(LPAREN) {
+			args := p.parseArguments()
 
 			return Call{
-				Function:  NewID(lit),
-				Arguments: args,
+				Function:      Identifier{lit, tok.Location},
+				TypeArguments: typeArgs,
+				Arguments:     args,
+				Pos:           Span{tok.Location.From, p.l.lastEnd},
 			}
 		} else if p.l.PeekIs(EQUALS) {
 			p.l.LexExpecting(EQUALS)
 
 			return Assignment{
-				To:    NewID(lit),
+				To:    Identifier{lit, tok.Location},
 				Value: p.parseExpression(),
-				Pos:   Span{tok.Location.From, p.l.pos},
+				Pos:   Span{tok.Location.From, p.l.lastEnd},
 			}
 		} else if p.l.PeekIs(LBRACKET) {
-			return Lit{StructLiteral{
-				Ident:  NewID(lit),
-				Fields: p.parseStructLiteral(),
-			}}
+			return Lit{
+				Literal: StructLiteral{
+					Ident:         Identifier{lit, tok.Location},
+					TypeArguments: typeArgs,
+					Fields:        p.parseStructLiteral(),
+				},
+				Pos: Span{tok.Location.From, p.l.lastEnd},
+			}
 		}
 	case IF:
 		cond := p.parseExpression()
@@ -274,6 +689,7 @@ func (p *Parser) parseExpressionLeaf() Expression {
 			Condition: cond,
 			Then:      then,
 			Else:      elseExpr,
+			Pos:       Span{tok.Location.From, p.l.lastEnd},
 		}
 	case LBRACKET:
 		return p.parseBlock()
@@ -282,25 +698,200 @@ func (p *Parser) parseExpressionLeaf() Expression {
 	panic("unhandled")
 }
 
+// binaryPrecedence maps binary operators to how tightly they bind; operators
+// that aren't in here are not binary operators.
+var binaryPrecedence = map[TokenKind]int{
+	OROR: 1,
+
+	ANDAND: 2,
+
+	DOUBLEEQUALS:  3,
+	NOTEQUALS:     3,
+	LESS:          3,
+	LESSEQUALS:    3,
+	GREATER:       3,
+	GREATEREQUALS: 3,
+
+	PLUS:  4,
+	MINUS: 4,
+	PIPE:  4,
+	CARET: 4,
+
+	STAR:       5,
+	SLASH:      5,
+	PERCENT:    5,
+	SHIFTLEFT:  5,
+	SHIFTRIGHT: 5,
+	AMPERSAND:  5,
+}
+
+func (p *Parser) peekPos() Position {
+	tok, _ := p.l.Peek()
+	return tok.Location.From
+}
+
 func (p *Parser) parseExpression() Expression {
-	from := p.l.pos
+	return p.parseBinary(1)
+}
+
+// parseBinary parses a chain of binary operators binding at least as tightly
+// as minPrec. all binary operators are left-associative.
+func (p *Parser) parseBinary(minPrec int) Expression {
+	from := p.peekPos()
+	expr := p.parseUnary()
+
+	for {
+		tok, _ := p.l.Peek()
+		prec, ok := binaryPrecedence[tok.Kind]
+		if !ok || prec < minPrec {
+			return expr
+		}
+		p.l.Lex()
+
+		rhs := p.parseBinary(prec + 1)
+		expr = Binary{
+			Op:    tok.Kind,
+			Left:  expr,
+			Right: rhs,
+			Pos:   Span{from, p.l.lastEnd},
+		}
+	}
+}
+
+// parseUnary parses prefix operators. a dereference followed by = stores a
+// value where the pointer points.
+func (p *Parser) parseUnary() Expression {
+	if ok, tok, _ := p.l.PeekIsWithRet(MINUS, BANG, STAR, AMPERSAND); ok {
+		p.l.Lex()
+
+		of := p.parseUnary()
+		if tok.Kind == STAR && p.l.PeekIs(EQUALS) {
+			p.l.LexExpecting(EQUALS)
+
+			return DerefAssignment{
+				Of:    of,
+				Value: p.parseExpression(),
+				Pos:   Span{tok.Location.From, p.l.lastEnd},
+			}
+		}
+		if tok.Kind == STAR {
+			if assign, ok := derefAssignment(of, Span{tok.Location.From, p.l.lastEnd}); ok {
+				return assign
+			}
+		}
+		return Unary{
+			Op:  tok.Kind,
+			Of:  of,
+			Pos: Span{tok.Location.From, p.l.lastEnd},
+		}
+	}
+
+	return p.parsePostfix()
+}
+
+// derefAssignment turns the operand of a dereference that took an assignment
+// with it, like p = v in *p = v, into an assignment through the pointer.
+func derefAssignment(of Expression, pos Span) (DerefAssignment, bool) {
+	switch of := of.(type) {
+	case Assignment:
+		return DerefAssignment{Var(of.To), of.Value, pos}, true
+	case FieldAssignment:
+		return DerefAssignment{Field{of.Struct, of.Field}, of.Value, pos}, true
+	case IndexAssignment:
+		return DerefAssignment{Index{of.Of, of.Index, of.Pos}, of.Value, pos}, true
+	}
+	return DerefAssignment{}, false
+}
+
+func (p *Parser) parsePostfix() Expression {
+	from := p.peekPos()
 	expr := p.parseExpressionLeaf()
 
-	if p.l.PeekIs(PERIOD) {
+	for p.l.PeekIs(PERIOD, LPAREN, LSQUARE) {
+		if p.l.PeekIs(LSQUARE) {
+			p.l.LexExpecting(LSQUARE)
+			noStructLiteral := p.noStructLiteral
+			p.noStructLiteral = false
+
+			var low Expression
+			if !p.l.PeekIs(COLON) {
+				low = p.parseExpression()
+			}
+			if low == nil || p.l.PeekIs(COLON) {
+				p.l.LexExpecting(COLON)
+				var high Expression
+				if !p.l.PeekIs(RSQUARE) {
+					high = p.parseExpression()
+				}
+				p.l.LexExpecting(RSQUARE)
+				p.noStructLiteral = noStructLiteral
+
+				expr = Subslice{expr, low, high, Span{from, p.l.lastEnd}}
+				continue
+			}
+			p.l.LexExpecting(RSQUARE)
+			p.noStructLiteral = noStructLiteral
+
+			if p.l.PeekIs(EQUALS) {
+				p.l.LexExpecting(EQUALS)
+
+				return IndexAssignment{
+					Of:    expr,
+					Index: low,
+					Value: p.parseExpression(),
+					Pos:   Span{from, p.l.lastEnd},
+				}
+			}
+
+			expr = Index{expr, low, Span{from, p.l.lastEnd}}
+			continue
+		}
+		if p.l.PeekIs(LPAREN) {
+			expr = CallValue{
+				Callee:    expr,
+				Arguments: p.parseArguments(),
+				Pos:       Span{from, p.l.lastEnd},
+			}
+			continue
+		}
+
 		tok, lit := p.l.LexWithI(1, PERIOD, IDENT)
 
+		// struct types other packages export are constructed like
+		// pkg.Point { x: 1 }
+		if pkg, ok := expr.(Var); ok && !p.noStructLiteral && p.l.PeekIs(LBRACKET) {
+			expr = Lit{
+				Literal: StructLiteral{
+					Ident:  Identifier{pkg.Name + "." + lit, Span{from, tok.Location.To}},
+					Fields: p.parseStructLiteral(),
+				},
+				Pos: Span{from, p.l.lastEnd},
+			}
+			continue
+		}
+
+		if p.l.PeekIs(LPAREN) {
+			expr = MethodCall{
+				Of:        expr,
+				Method:    Identifier{lit, tok.Location},
+				Arguments: p.parseArguments(),
+				Pos:       Span{from, p.l.lastEnd},
+			}
+			continue
+		}
+
 		if p.l.PeekIs(EQUALS) {
 			p.l.LexExpecting(EQUALS)
 
 			return FieldAssignment{
 				Struct: expr,
-				Field:  NewID(lit),
+				Field:  Identifier{lit, tok.Location},
 				Value:  p.parseExpression(),
-				Pos:    Span{from, p.l.pos},
+				Pos:    Span{from, p.l.lastEnd},
 			}
 		}
 
-		return Field{
+		expr = Field{
 			Of:    expr,
 			Ident: Identifier{lit, Span{from, tok.Location.To}},
 		}
@@ -309,13 +900,89 @@ func (p *Parser) parseExpression() Expression {
 	return expr
 }
 
+// parseArguments parses the arguments of a call, starting at the opening
+// parenthesis.
+func (p *Parser) parseArguments() []Expression {
+	p.l.LexExpecting(LPAREN)
+	var args []Expression
+
+	if !p.l.PeekIs(RPAREN) {
+		for {
+			args = append(args, p.parseExpression())
+
+			if p.l.PeekIs(RPAREN) {
+				break
+			}
+
+			p.l.LexExpecting(COMMA)
+		}
+	}
+	p.l.LexExpecting(RPAREN)
+
+	return args
+}
+
+// parseMatchArm parses an arm of a match like Circle(r) => r * r.
+func (p *Parser) parseMatchArm() MatchArm {
+	tok, name := p.l.LexExpecting(IDENT)
+	arm := MatchArm{Variant: Identifier{name, tok.Location}}
+	if p.l.PeekIs(LPAREN) {
+		p.l.LexExpecting(LPAREN)
+		bindTok, binding := p.l.LexExpecting(IDENT)
+		arm.Binding = &Identifier{binding, bindTok.Location}
+		p.l.LexExpecting(RPAREN)
+	}
+	p.l.LexExpecting(FATARROW)
+	arm.Body = p.parseExpression()
+	return arm
+}
+
+// parseSum parses the variants of a sum type after the =, each one starting
+// with a | that can also start the next line.
+func (p *Parser) parseSum() Sum {
+	var sum Sum
+	for {
+		p.l.LexExpecting(PIPE)
+		tok, name := p.l.LexExpecting(IDENT)
+		variant := Variant{Ident: Identifier{name, tok.Location}}
+		if p.l.PeekIs(OF) {
+			p.l.LexExpecting(OF)
+			payload := p.parseType()
+			variant.Payload = &payload
+		}
+		variant.Pos = Span{tok.Location.From, p.l.lastEnd}
+		sum = append(sum, variant)
+
+		if p.l.PeekIs(EOS) {
+			p.l.LexExpecting(EOS)
+		}
+		if !p.l.PeekIs(PIPE) {
+			return sum
+		}
+	}
+}
+
 // expected to be called after reading type keyword and name token.
 func (p *Parser) parseType() Type {
-	tok, lit := p.l.LexExpecting(IDENT, FUNC, STRUCT)
+	tok, lit := p.l.LexExpecting(IDENT, FUNC, STRUCT, LSQUARE, STAR)
 
 	switch tok.Kind {
+	case LSQUARE:
+		return p.parseArrayType()
+	case STAR:
+		return Pointer{p.parseType()}
 	case IDENT:
-		return Ident(NewID(lit))
+		// types exported by other packages are qualified by the package,
+		// like ooflib.Writer
+		if p.l.PeekIs(PERIOD) {
+			p.l.LexExpecting(PERIOD)
+			name, member := p.l.LexExpecting(IDENT)
+			lit, tok.Location.To = lit+"."+member, name.Location.To
+		}
+		if p.l.PeekIs(LSQUARE) {
+			return Generic{Identifier{lit, tok.Location}, p.parseTypeArguments()}
+		}
+		return Ident{lit, tok.Location}
 	case FUNC:
 		p.l.LexExpecting(LPAREN)
 		f := FunctionPointer{}
@@ -330,7 +997,7 @@ func (p *Parser) parseType() Type {
 			}
 		}
 		p.l.LexExpecting(RPAREN)
-		if p.l.PeekIs(IDENT, FUNC, STRUCT) {
+		if p.l.PeekIs(IDENT, FUNC, STRUCT, LSQUARE, STAR) {
 			t := p.parseType()
 			f.Returns = &t
 		}
@@ -348,16 +1015,18 @@ func (p *Parser) parseType() Type {
 					continue
 				}
 
-				_, name := p.l.LexExpecting(IDENT)
+				nameTok, name := p.l.LexExpecting(IDENT)
 				p.l.LexExpecting(COLON)
 				kind := p.parseType()
 
 				s = append(s, struct {
 					Ident string
 					Kind  Type
+					Pos   Span
 				}{
 					Ident: name,
 					Kind:  kind,
+					Pos:   Span{nameTok.Location.From, p.l.lastEnd},
 				})
 
 				if p.l.PeekIs(EOS, RBRACKET) {
@@ -0,0 +1,121 @@
+package main
+
+// untypedNil gives nil the type want if that's a pointer type, so that it can
+// be used wherever a pointer is expected.
+func untypedNil(e Typed, want tawaType) Typed {
+	if e.Kind != typeNil || want == nil {
+		return e
+	}
+	if _, ok := underlying(want).(*pointerType); ok {
+		return Typed{e.Expr, want}
+	}
+	return e
+}
+
+// autoDeref dereferences pointers that fields are read from or methods are
+// called on, so that p.x works like (*p).x.
+func (c *checker) autoDeref(e Typed) Typed {
+	ptr, ok := underlying(e.Kind).(*pointerType)
+	if !ok {
+		return e
+	}
+	return Typed{Unary{STAR, e, posOf(e)}, ptr.elem}
+}
+
+// escape moves the variable an addressable expression is stored in to the
+// heap, so that pointers to it can outlive the function declaring it.
+func (c *checker) escape(e Expression) {
+	switch expr := e.(type) {
+	case Typed:
+		c.escape(expr.Expr)
+	case Var:
+		if sym := c.scope.lookup(expr.Name); sym != nil {
+			sym.captured = true
+		}
+	case Field:
+		c.escape(expr.Of)
+	case Index:
+		// elements of slices are already stored somewhere else
+		if _, ok := underlying(typeOf(expr.Of)).(*arrayType); ok {
+			c.escape(expr.Of)
+		}
+	}
+}
+
+func (c *checker) deref(expr Unary) Typed {
+	of := c.expr(expr.Of)
+	typed := Typed{Unary{expr.Op, of, expr.Pos}, typeInvalid}
+
+	ptr, ok := underlying(of.Kind).(*pointerType)
+	if !ok {
+		if of.Kind != typeInvalid {
+			c.errorf(ErrMismatchedTypes, posOf(expr.Of), "cannot dereference a value of type '%s'", of.Kind)
+		}
+		return typed
+	}
+
+	typed.Kind = ptr.elem
+	return typed
+}
+
+func (c *checker) addressOf(expr Unary) Typed {
+	of := c.expr(expr.Of)
+	typed := Typed{Unary{expr.Op, of, expr.Pos}, &pointerType{of.Kind}}
+
+	if of.Kind == typeInvalid {
+		typed.Kind = typeInvalid
+		return typed
+	}
+	if !c.addressable(of) {
+		c.errorf(ErrNotAddressable, expr.Pos, "cannot take the address of a value that isn't stored in a variable")
+		typed.Kind = typeInvalid
+		return typed
+	}
+
+	c.escape(of)
+	return typed
+}
+
+func (c *checker) derefAssignment(expr DerefAssignment) Typed {
+	of := c.expr(expr.Of)
+	ptr, ok := underlying(of.Kind).(*pointerType)
+
+	var elem tawaType
+	if ok {
+		elem = ptr.elem
+	}
+	value := c.exprExpecting(expr.Value, elem)
+	typed := Typed{DerefAssignment{of, value, expr.Pos}, value.Kind}
+
+	if !ok {
+		if of.Kind != typeInvalid {
+			c.errorf(ErrMismatchedTypes, posOf(expr.Of), "cannot dereference a value of type '%s'", of.Kind)
+		}
+		return typed
+	}
+	if !identical(elem, value.Kind) {
+		c.errorf(ErrMismatchedTypes, posOf(expr.Value), "tried to assign something of type '%s' to type '%s'", value.Kind, elem)
+	}
+
+	return typed
+}
+
+// newExpr checks allocating a value of a type on the heap, initialized by a
+// literal of that type if one is given.
+func (c *checker) newExpr(expr New) Typed {
+	kind := c.resolveType(expr.Kind)
+	n := New{Kind: resolvedType(expr.Kind, kind, c.typeParams), Pos: expr.Pos}
+
+	if expr.Value != nil {
+		value := c.exprExpecting(expr.Value, kind)
+		if !identical(kind, value.Kind) {
+			c.errorf(ErrMismatchedTypes, posOf(expr.Value), "cannot initialize a '%s' with a value of type '%s'", kind, value.Kind)
+		}
+		n.Value = value
+	}
+
+	if kind == typeInvalid {
+		return Typed{n, typeInvalid}
+	}
+	return Typed{n, &pointerType{kind}}
+}
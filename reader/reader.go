@@ -1,20 +1,161 @@
+// Package reader reads the type info compilers embed in what they build from
+// packages, without loading it.
 package reader
 
-import "github.com/coreos/pkg/dlopen"
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
 
-import "C"
+// Section is the section the type info is put in, and Symbol is the symbol
+// pointing at it. files from compilers that didn't put it in its own section
+// only have the symbol.
+const (
+	Section = ".tawa_types"
+	Symbol  = "__tawa_types"
+)
 
+// ErrNoTypeInfo is returned for files that weren't built from a package.
+var ErrNoTypeInfo = errors.New("it doesn't contain type info, it isn't built from a Tawa package")
+
+const (
+	archiveMagic  = "!<arch>\n"
+	archiveHeader = 60
+)
+
+// ReadTypeInfo reads the type info of a shared object, an object file or an
+// archive of object files, which can be for any architecture.
 func ReadTypeInfo(from string) (string, error) {
-	handle, err := dlopen.GetHandle([]string{from})
+	f, err := os.Open(from)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(f, magic); err == nil && string(magic) == archiveMagic {
+		return readArchive(f)
+	}
+
+	file, err := elf.NewFile(f)
 	if err != nil {
 		return "", err
 	}
+	return readELF(file)
+}
+
+// IsStatic reports whether a file is copied into what it's linked into, like
+// archives and object files are, rather than being loaded along with it.
+func IsStatic(from string) (bool, error) {
+	f, err := os.Open(from)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
 
-	sym, err := handle.GetSymbolPointer("__tawa_types")
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(f, magic); err == nil && string(magic) == archiveMagic {
+		return true, nil
+	}
+
+	file, err := elf.NewFile(f)
 	if err != nil {
+		return false, err
+	}
+	return file.Type == elf.ET_REL, nil
+}
+
+// readArchive reads the type info of the first object in an archive that has
+// it. archives list their objects one after another, each after a header with
+// its name and size, and aligned to two bytes.
+func readArchive(f *os.File) (string, error) {
+	offset := int64(len(archiveMagic))
+	header := make([]byte, archiveHeader)
+	for {
+		if _, err := f.ReadAt(header, offset); err == io.EOF {
+			return "", ErrNoTypeInfo
+		} else if err != nil {
+			return "", err
+		}
+		if string(header[58:60]) != "`\n" {
+			return "", fmt.Errorf("the archive is malformed at offset %d", offset)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("the archive is malformed at offset %d: %w", offset, err)
+		}
+		offset += archiveHeader
+
+		// the symbol table and the table of long names aren't objects
+		if name := strings.TrimSpace(string(header[:16])); name != "/" && name != "//" {
+			if file, err := elf.NewFile(io.NewSectionReader(f, offset, size)); err == nil {
+				if info, err := readELF(file); err != ErrNoTypeInfo {
+					return info, err
+				}
+			}
+		}
+
+		offset += size + size%2
+	}
+}
+
+// readELF reads the type info from its section, or from wherever its symbol
+// points at. shared objects linking static libraries built from packages have
+// the type info of each of them in the section, after the one of the package
+// they're built from, which is linked first.
+func readELF(file *elf.File) (string, error) {
+	if sec := file.Section(Section); sec != nil {
+		data, err := sec.Data()
+		if err != nil {
+			return "", err
+		}
+		return cString(data), nil
+	}
+
+	syms, err := file.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
 		return "", err
 	}
+	dyn, err := file.DynamicSymbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return "", err
+	}
+
+	for _, sym := range append(syms, dyn...) {
+		if sym.Name != Symbol || sym.Section == elf.SHN_UNDEF || int(sym.Section) >= len(file.Sections) {
+			continue
+		}
+		sec := file.Sections[sym.Section]
+		data, err := sec.Data()
+		if err != nil {
+			return "", err
+		}
+
+		// symbols of object files are offsets into their section, and the
+		// ones of linked files are addresses
+		at := sym.Value
+		if file.Type != elf.ET_REL {
+			at -= sec.Addr
+		}
+		if at >= uint64(len(data)) {
+			return "", fmt.Errorf("%s points outside of %s", Symbol, sec.Name)
+		}
+		return cString(data[at:]), nil
+	}
+
+	return "", ErrNoTypeInfo
+}
 
-	str := C.GoString((*C.char)(sym))
-	return str, nil
+// cString returns the NUL terminated string data starts with.
+func cString(data []byte) string {
+	if end := bytes.IndexByte(data, 0); end != -1 {
+		data = data[:end]
+	}
+	return string(data)
 }
@@ -0,0 +1,200 @@
+package reader
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const info = `{"package":"geo"}`
+
+// section is a section of an ELF file built by object.
+type section struct {
+	name string
+	typ  elf.SectionType
+	addr uint64
+	data []byte
+	link uint32
+}
+
+// object returns an ELF file of type typ with the sections, after which it
+// adds the table of their names.
+func object(typ elf.Type, sections ...section) []byte {
+	names := "\x00"
+	offsets := []uint32{0}
+	for _, sec := range append(sections, section{name: ".shstrtab"}) {
+		offsets = append(offsets, uint32(len(names)))
+		names += sec.name + "\x00"
+	}
+	sections = append(sections, section{name: ".shstrtab", typ: elf.SHT_STRTAB, data: []byte(names)})
+
+	var data bytes.Buffer
+	headers := []elf.Section64{{}}
+	for i, sec := range sections {
+		header := elf.Section64{
+			Name:      offsets[i+1],
+			Type:      uint32(sec.typ),
+			Addr:      sec.addr,
+			Off:       uint64(64 + data.Len()),
+			Size:      uint64(len(sec.data)),
+			Link:      sec.link,
+			Addralign: 1,
+		}
+		if sec.typ == elf.SHT_SYMTAB || sec.typ == elf.SHT_DYNSYM {
+			header.Entsize = elf.Sym64Size
+			header.Info = 1
+		}
+		headers = append(headers, header)
+		data.Write(sec.data)
+	}
+
+	var buf bytes.Buffer
+	header := elf.Header64{
+		Type:      uint16(typ),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(64 + data.Len()),
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     uint16(len(headers)),
+		Shstrndx:  uint16(len(headers) - 1),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(data.Bytes())
+	binary.Write(&buf, binary.LittleEndian, headers)
+	return buf.Bytes()
+}
+
+// symbols returns a symbol table with the symbol named name at value in the
+// section at idx, and the string table for it.
+func symbols(name string, idx uint16, value uint64) ([]byte, []byte) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []elf.Sym64{
+		{},
+		{Name: 1, Info: elf.ST_INFO(elf.STB_LOCAL, elf.STT_OBJECT), Shndx: idx, Value: value, Size: uint64(len(info) + 1)},
+	})
+	return buf.Bytes(), []byte("\x00" + name + "\x00")
+}
+
+// archive returns an archive of the members, given as pairs of their names
+// and contents.
+func archive(members ...string) []byte {
+	buf := bytes.NewBufferString("!<arch>\n")
+	for i := 0; i < len(members); i += 2 {
+		fmt.Fprintf(buf, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", members[i], "0", "0", "0", "644", len(members[i+1]))
+		buf.WriteString(members[i+1])
+		if len(members[i+1])%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// write writes data to a temporary file, whose path it returns.
+func write(t *testing.T, data []byte) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "tawa-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "lib")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadTypeInfo(t *testing.T) {
+	// the symbol is at 4 bytes into .data, which is at 0x1000
+	data := []byte("pad\x00" + info + "\x00")
+	symtab, strtab := symbols(Symbol, 1, 4)
+	dynsym, dynstr := symbols(Symbol, 1, 0x1004)
+	elsewhere, _ := symbols(Symbol, 1, 0x1004)
+
+	withInfo := object(elf.ET_REL, section{name: Section, typ: elf.SHT_PROGBITS, data: []byte(info + "\x00" + `{"package":"base"}` + "\x00")})
+	without := object(elf.ET_REL, section{name: ".data", typ: elf.SHT_PROGBITS, data: []byte("nothing")})
+	// the symbol table and the table of long names of archives are never
+	// objects, so the type info in these is never read
+	decoy := object(elf.ET_REL, section{name: Section, typ: elf.SHT_PROGBITS, data: []byte(`{"package":"decoy"}` + "\x00")})
+
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"section", withInfo, info},
+		{"relocatable symbol", object(elf.ET_REL,
+			section{name: ".data", typ: elf.SHT_PROGBITS, addr: 0x1000, data: data},
+			section{name: ".symtab", typ: elf.SHT_SYMTAB, data: symtab, link: 3},
+			section{name: ".strtab", typ: elf.SHT_STRTAB, data: strtab},
+		), info},
+		{"linked symbol", object(elf.ET_DYN,
+			section{name: ".data", typ: elf.SHT_PROGBITS, addr: 0x1000, data: data},
+			section{name: ".dynsym", typ: elf.SHT_DYNSYM, data: dynsym, link: 3},
+			section{name: ".dynstr", typ: elf.SHT_STRTAB, data: dynstr},
+		), info},
+		{"archive", archive(
+			"/", string(decoy)+"\x00",
+			"//", string(decoy),
+			"notes.txt/", "odd",
+			"empty.o/", string(without),
+			"geo.o/", string(withInfo),
+		), info},
+	}
+	for _, c := range cases {
+		got, err := ReadTypeInfo(write(t, c.data))
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		} else if got != c.want {
+			t.Errorf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+	}
+
+	outside := object(elf.ET_REL,
+		section{name: ".data", typ: elf.SHT_PROGBITS, data: data},
+		section{name: ".symtab", typ: elf.SHT_SYMTAB, data: elsewhere, link: 3},
+		section{name: ".strtab", typ: elf.SHT_STRTAB, data: strtab},
+	)
+	if _, err := ReadTypeInfo(write(t, outside)); err == nil || !strings.Contains(err.Error(), "points outside of .data") {
+		t.Errorf("expected a symbol pointing outside of its section to fail, got %v", err)
+	}
+
+	for name, data := range map[string][]byte{"object": without, "archive": archive("/", "", "empty.o/", string(without))} {
+		if _, err := ReadTypeInfo(write(t, data)); err != ErrNoTypeInfo {
+			t.Errorf("%s: expected ErrNoTypeInfo, got %v", name, err)
+		}
+	}
+}
+
+func TestIsStatic(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"object", object(elf.ET_REL), true},
+		{"shared object", object(elf.ET_DYN), false},
+		{"archive", archive("a.o/", string(object(elf.ET_REL))), true},
+	}
+	for _, c := range cases {
+		got, err := IsStatic(write(t, c.data))
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		} else if got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/types"
 )
@@ -49,3 +52,301 @@ func NewID(in string) Identifier {
 		Name: in,
 	}
 }
+
+// tawaType is the type of a value as far as the checker is concerned.
+// unlike the LLVM types above, these are what users see in error messages.
+type tawaType interface {
+	String() string
+}
+
+type basicKind int
+
+const (
+	basicInvalid basicKind = iota
+	basicInt
+	basicFloat
+	basicBool
+	basicString
+	basicNiets
+	basicNil
+)
+
+type basicType struct {
+	name     string
+	kind     basicKind
+	unsigned bool
+	// bits is the width of integer and floating point types
+	bits int
+}
+
+func (b *basicType) String() string {
+	return b.name
+}
+
+type structField struct {
+	Name string
+	Kind tawaType
+}
+
+type structType struct {
+	fields []structField
+}
+
+func (s *structType) String() string {
+	var fields []string
+	for _, field := range s.fields {
+		fields = append(fields, field.Name+": "+field.Kind.String())
+	}
+	return "struct { " + strings.Join(fields, "; ") + " }"
+}
+
+// field returns the index and type of the named field, or -1 if the struct
+// has no such field.
+func (s *structType) field(name string) (int, tawaType) {
+	for idx, field := range s.fields {
+		if field.Name == name {
+			return idx, field.Kind
+		}
+	}
+	return -1, nil
+}
+
+// sumVariant is one of the variants of a sum type. Payload is nil for
+// variants that don't carry a value.
+type sumVariant struct {
+	Name    string
+	Payload tawaType
+}
+
+type sumType struct {
+	variants []sumVariant
+}
+
+func (s *sumType) String() string {
+	var variants []string
+	for _, variant := range s.variants {
+		if variant.Payload == nil {
+			variants = append(variants, "| "+variant.Name)
+		} else {
+			variants = append(variants, "| "+variant.Name+" of "+variant.Payload.String())
+		}
+	}
+	return strings.Join(variants, " ")
+}
+
+// variant returns the index and payload of the named variant, or -1 if the
+// sum type has no such variant.
+func (s *sumType) variant(name string) (int, tawaType) {
+	for idx, variant := range s.variants {
+		if variant.Name == name {
+			return idx, variant.Payload
+		}
+	}
+	return -1, nil
+}
+
+// interfaceMethod is a method that types implementing an interface have.
+type interfaceMethod struct {
+	Name string
+	Type *funcType
+}
+
+type interfaceType struct {
+	methods []interfaceMethod
+}
+
+func (i *interfaceType) String() string {
+	if len(i.methods) == 0 {
+		return "interface {}"
+	}
+	var methods []string
+	for _, method := range i.methods {
+		methods = append(methods, method.Name+strings.TrimPrefix(method.Type.String(), "func"))
+	}
+	return "interface { " + strings.Join(methods, "; ") + " }"
+}
+
+// method returns the index and type of the named method, or -1 if the
+// interface has no such method.
+func (i *interfaceType) method(name string) (int, *funcType) {
+	for idx, method := range i.methods {
+		if method.Name == name {
+			return idx, method.Type
+		}
+	}
+	return -1, nil
+}
+
+type funcType struct {
+	params  []tawaType
+	returns tawaType
+}
+
+func (f *funcType) String() string {
+	var params []string
+	for _, param := range f.params {
+		params = append(params, param.String())
+	}
+	ret := ""
+	if f.returns != typeNiets {
+		ret = " " + f.returns.String()
+	}
+	return "func(" + strings.Join(params, ", ") + ")" + ret
+}
+
+// arrayType is a fixed number of values of the same type, which are stored
+// inline like the fields of a struct.
+type arrayType struct {
+	length int64
+	elem   tawaType
+}
+
+func (a *arrayType) String() string {
+	return "[" + strconv.FormatInt(a.length, 10) + "]" + a.elem.String()
+}
+
+// sliceType points to a number of values of the same type that are stored
+// somewhere else, like in an array.
+type sliceType struct {
+	elem tawaType
+}
+
+func (s *sliceType) String() string {
+	return "[]" + s.elem.String()
+}
+
+// pointerType points to a value of type elem.
+type pointerType struct {
+	elem tawaType
+}
+
+func (p *pointerType) String() string {
+	return "*" + p.elem.String()
+}
+
+// builtinType is the type of builtin functions like len, which take arguments
+// of more than one type and are checked by builtinCall.
+type builtinType struct {
+	name string
+}
+
+func (b *builtinType) String() string {
+	return "builtin " + b.name
+}
+
+// namedType is a type introduced by a type declaration of a struct, sum or
+// interface. two named types are only the same type if they come from the
+// same declaration.
+type namedType struct {
+	name       string
+	underlying tawaType
+
+	// origin and args are the generic type this is an instance of and its
+	// type arguments, if it is one
+	origin string
+	args   []tawaType
+}
+
+func (n *namedType) String() string {
+	return n.name
+}
+
+var (
+	typeInvalid = &basicType{name: "invalid type", kind: basicInvalid}
+
+	typeInt8   = &basicType{name: "int8", kind: basicInt, bits: 8}
+	typeInt16  = &basicType{name: "int16", kind: basicInt, bits: 16}
+	typeInt32  = &basicType{name: "int32", kind: basicInt, bits: 32}
+	typeInt64  = &basicType{name: "int64", kind: basicInt, bits: 64}
+	typeInt128 = &basicType{name: "int128", kind: basicInt, bits: 128}
+
+	typeFloat16  = &basicType{name: "float16", kind: basicFloat, bits: 16}
+	typeFloat32  = &basicType{name: "float32", kind: basicFloat, bits: 32}
+	typeFloat64  = &basicType{name: "float64", kind: basicFloat, bits: 64}
+	typeFloat128 = &basicType{name: "float128", kind: basicFloat, bits: 128}
+
+	typeByte = &basicType{name: "byte", kind: basicInt, unsigned: true, bits: 8}
+	// rune is another name for int32, the type of character literals
+	typeRune   = typeInt32
+	typeBool   = &basicType{name: "bool", kind: basicBool}
+	typeString = &basicType{name: "string", kind: basicString}
+	typeNiets  = &basicType{name: "niets", kind: basicNiets}
+	// nil is the type of nil until it's used as a value of a pointer type
+	typeNil = &basicType{name: "nil", kind: basicNil}
+)
+
+// basicLLVMTypes are the LLVM types numbers are lowered to.
+var basicLLVMTypes = map[*basicType]LLVMType{
+	typeInt8:     Int8,
+	typeInt16:    Int16,
+	typeInt32:    Int32,
+	typeInt64:    Int64,
+	typeInt128:   Int128,
+	typeFloat16:  Float16,
+	typeFloat32:  Float32,
+	typeFloat64:  Float64,
+	typeFloat128: Float128,
+	typeByte:     Byte,
+}
+
+// underlying strips the name off of named types.
+func underlying(t tawaType) tawaType {
+	if named, ok := t.(*namedType); ok {
+		return named.underlying
+	}
+	return t
+}
+
+func isKind(t tawaType, k basicKind) bool {
+	b, ok := t.(*basicType)
+	return ok && b.kind == k
+}
+
+// isNumeric reports whether t is an integer or floating point type.
+func isNumeric(t tawaType) bool {
+	return isKind(underlying(t), basicInt) || isKind(underlying(t), basicFloat)
+}
+
+// identical reports whether two types are the same type. the invalid type is
+// identical to everything so that one error doesn't cause a cascade of others.
+func identical(a, b tawaType) bool {
+	if a == typeInvalid || b == typeInvalid {
+		return true
+	}
+
+	switch x := a.(type) {
+	case *structType:
+		y, ok := b.(*structType)
+		if !ok || len(x.fields) != len(y.fields) {
+			return false
+		}
+		for i := range x.fields {
+			if x.fields[i].Name != y.fields[i].Name || !identical(x.fields[i].Kind, y.fields[i].Kind) {
+				return false
+			}
+		}
+		return true
+	case *funcType:
+		y, ok := b.(*funcType)
+		if !ok || len(x.params) != len(y.params) || !identical(x.returns, y.returns) {
+			return false
+		}
+		for i := range x.params {
+			if !identical(x.params[i], y.params[i]) {
+				return false
+			}
+		}
+		return true
+	case *arrayType:
+		y, ok := b.(*arrayType)
+		return ok && x.length == y.length && identical(x.elem, y.elem)
+	case *sliceType:
+		y, ok := b.(*sliceType)
+		return ok && identical(x.elem, y.elem)
+	case *pointerType:
+		y, ok := b.(*pointerType)
+		return ok && identical(x.elem, y.elem)
+	}
+
+	return a == b
+}
@@ -2,14 +2,98 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
 
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
 	"github.com/pontaoski/tawago/reader"
 )
 
+// typeInfoVersion is the version of the format of typeInfo, which changes
+// whenever libraries built with an older compiler can't be read anymore.
+const typeInfoVersion = 1
+
+// typeInfo describes what a library exports. names are the ones things have
+// inside of the library, which the symbols they're compiled to are mangled
+// from.
 type typeInfo struct {
+	Version int    `json:"version"`
+	Package string `json:"package"`
+	// Types are all the types the library declares, in no particular
+	// order. unexported ones are there too, since exported ones can refer
+	// to them.
+	Types     []typeDecl        `json:"types,omitempty"`
 	Functions map[string]string `json:"functions"`
+	// Methods are keyed by their type and name like Melako.Area, and don't
+	// list the receiver in their signature
+	Methods map[string]string `json:"methods,omitempty"`
+	// Imports are the paths of the packages the library imports, which
+	// programs linking it statically have to link too
+	Imports []string `json:"imports,omitempty"`
+	// Memory is how the library manages heap memory, which everything it's
+	// linked with has to manage it like
+	Memory string `json:"memory,omitempty"`
+}
+
+// memoryManagement returns how code built with the memory setting manages
+// heap memory, which is manually unless it's set otherwise.
+func memoryManagement(memory string) string {
+	if memory == "" {
+		return memoryManual
+	}
+	return memory
+}
+
+// typeDecl is a type declared by a library, like the struct Point with the
+// kind struct { x: int64; y: int64 }. fields are in the order they're laid
+// out in.
+type typeDecl struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// qualifiedName is what a name exported by a package is called in packages
+// importing it. it's only used inside of the compiler, and can't be written in
+// source, where the package is referred to by what it's imported as.
+func qualifiedName(pkg, name string) string {
+	return pkg + "." + name
+}
+
+// unqualified returns the name a qualified name has in its package, which is
+// what variants of sum types are matched by.
+func unqualified(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// mangle returns the symbol a name declared in a package is compiled to. both
+// are prefixed with their length, so that symbols only depend on the package
+// and the name, whatever characters they contain.
+func mangle(pkg, name string) string {
+	return fmt.Sprintf("_TW%d%s%d%s", len(pkg), pkg, len(name), name)
+}
+
+// parseKind parses a type written in type info. interfaces and sums are only
+// written like that in type declarations, so parseType doesn't read them.
+func parseKind(kind, lib string) Type {
+	p := NewParser(NewLexer(strings.NewReader(kind), lib))
+	switch {
+	case strings.HasPrefix(kind, "interface"):
+		return p.parseInterface()
+	case strings.HasPrefix(kind, "="):
+		p.l.LexExpecting(EQUALS)
+		return p.parseSum()
+	}
+	return p.parseType()
+}
+
+// exported reports whether packages importing the one declaring name can use
+// it, which they can if it starts with an upper case letter.
+func exported(name string) bool {
+	return unicode.IsUpper(firstRune(name))
 }
 
 func registerTypeInfoWithModule(t typeInfo, m *ir.Module) {
@@ -18,8 +102,18 @@ func registerTypeInfoWithModule(t typeInfo, m *ir.Module) {
 		panic(err)
 	}
 
-	g := m.NewGlobalDef("__tawa_types", constant.NewCharArray(append(data, 0)))
+	// it's put in its own section, so that it can be read from archives and
+	// objects that haven't been linked yet. it's internal so that the ones of
+	// static libraries linked into the same program don't clash.
+	g := m.NewGlobalDef(reader.Symbol, constant.NewCharArray(append(data, 0)))
+	g.Section = reader.Section
+	g.Linkage = enum.LinkageInternal
 	g.Immutable = true
+
+	// nothing refers to it, so it has to be kept from being optimized away
+	used := m.NewGlobalDef("llvm.used", constant.NewArray(types.NewArray(1, types.I8Ptr), constant.NewBitCast(g, types.I8Ptr)))
+	used.Linkage = enum.LinkageAppending
+	used.Section = "llvm.metadata"
 }
 
 func getTypeInfoFromFile(f string) (t typeInfo, err error) {
@@ -28,6 +122,11 @@ func getTypeInfoFromFile(f string) (t typeInfo, err error) {
 		return typeInfo{}, err
 	}
 
-	err = json.Unmarshal([]byte(data), &t)
-	return
+	if err = json.Unmarshal([]byte(data), &t); err != nil {
+		return typeInfo{}, err
+	}
+	if t.Version != typeInfoVersion {
+		return typeInfo{}, fmt.Errorf("it has version %d of the type info format instead of version %d, rebuild it with this compiler", t.Version, typeInfoVersion)
+	}
+	return t, nil
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/llir/llvm/ir/constant"
+	"github.com/pontaoski/tawago/reader"
+)
+
+// writeLibrary writes an object file with nothing but the type info ti to
+// path, which is all the checker and codegen read from libraries. it's a
+// relocatable object for static libraries, and a shared object otherwise.
+func writeLibrary(t *testing.T, path string, ti typeInfo) {
+	t.Helper()
+
+	data, err := json.Marshal(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, 0)
+	names := "\x00" + reader.Section + "\x00.shstrtab\x00"
+
+	var buf bytes.Buffer
+	kind := elf.ET_DYN
+	if strings.HasSuffix(path, staticLibraryExtension) {
+		kind = elf.ET_REL
+	}
+	header := elf.Header64{
+		Type:      uint16(kind),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(64 + len(data) + len(names)),
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     3,
+		Shstrndx:  2,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(data)
+	buf.WriteString(names)
+	binary.Write(&buf, binary.LittleEndian, []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_PROGBITS), Flags: uint64(elf.SHF_ALLOC), Off: 64, Size: uint64(len(data)), Addralign: 1},
+		{Name: uint32(len(reader.Section) + 2), Type: uint32(elf.SHT_STRTAB), Off: uint64(64 + len(data)), Size: uint64(len(names)), Addralign: 1},
+	})
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// libraryTypeInfo compiles src as the library of the package pkg, and returns
+// the type info it exports.
+func libraryTypeInfo(t *testing.T, pkg, src string) typeInfo {
+	t.Helper()
+
+	p := NewParser(NewLexer(strings.NewReader(src), pkg))
+	if err := p.Parse(); err != nil {
+		t.Fatalf("failed to parse %q: %s", src, err)
+	}
+	sets := settings{isLibrary: true, packageName: pkg}
+	diags := &Diagnostics{}
+	modu := codegen(check(p.ast.Toplevels, sets, diags), sets, diags)
+	if diags.HasErrors() {
+		t.Fatalf("failed to compile %q: %v", src, diags.List())
+	}
+
+	for _, g := range modu.Globals {
+		if g.Name() == reader.Symbol {
+			var ti typeInfo
+			data := g.Init.(*constant.CharArray).X
+			if err := json.Unmarshal(data[:len(data)-1], &ti); err != nil {
+				t.Fatal(err)
+			}
+			return ti
+		}
+	}
+	t.Fatalf("%s has no type info", pkg)
+	return typeInfo{}
+}
+
+func TestTypeInfoRoundTrip(t *testing.T) {
+	ti := libraryTypeInfo(t, "shapes", `type Pt struct {
+    x: int64
+    y: int64
+}
+type Units int64
+type Move func(Pt) Pt
+type Shape =
+    | Circle of int64
+    | Box of Pt
+    | Empty
+func (s Shape) Area() int64 => match s {
+    Circle(r) => 3 * r * r
+    Box(p) => p.x * p.y
+    Empty => 0
+}
+func Apply(m: Move, p: Pt) Pt => m(p)
+func Two() Units => 2
+`)
+
+	kinds := map[string]string{}
+	for _, decl := range ti.Types {
+		kinds[decl.Name] = decl.Kind
+	}
+	want := map[string]string{
+		"Pt":    "struct { x: int64; y: int64 }",
+		"Units": "int64",
+		"Move":  "func(Pt) Pt",
+		"Shape": "= | Circle of int64 | Box of Pt | Empty",
+	}
+	for name, kind := range want {
+		if kinds[name] != kind {
+			t.Errorf("expected %s to be exported as %q, got %q", name, kind, kinds[name])
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "tawa-typeinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLibrary(t, filepath.Join(dir, "shapes"+libraryExtension), ti)
+
+	modu := codegenSource(t, "import `shapes`\nfunc area(s: shapes.Shape) int64 => s.Area()\nfunc main() {\n    let p = shapes.Apply(func(p: shapes.Pt) shapes.Pt => shapes.Pt { x: p.x + 1, y: p.y }, shapes.Pt { x: 1, y: 2 })\n    let n = match shapes.Circle(1) {\n        Circle(r) => r\n        _ => 0\n    }\n    area(shapes.Box(p)) + area(shapes.Empty) + shapes.Two() + n\n}\n", settings{importPaths: []string{dir}})
+
+	for _, want := range []string{"%shapes.Pt = type { %int64, %int64 }", "%shapes.Shape = type { i32, [2 x i64] }", "@_TW6shapes10Shape.Area(%shapes.Shape", "@_TW6shapes5Apply("} {
+		if !strings.Contains(modu, want) {
+			t.Errorf("expected the importer's IR to contain %q:\n%s", want, modu)
+		}
+	}
+}
+
+func TestTypeInfoMemoryMismatch(t *testing.T) {
+	ti := libraryTypeInfo(t, "boxes", "func Two() int64 => 2\n")
+	if ti.Memory != memoryManual {
+		t.Fatalf("expected the library to record manual memory, got %q", ti.Memory)
+	}
+
+	dir, err := ioutil.TempDir("", "tawa-typeinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLibrary(t, filepath.Join(dir, "boxes"+libraryExtension), ti)
+
+	src := "import `boxes`\nfunc main() => boxes.Two()\n"
+	codegenSource(t, src, settings{importPaths: []string{dir}})
+
+	p := NewParser(NewLexer(strings.NewReader(src), "test"))
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	sets := settings{importPaths: []string{dir}, memory: memoryGC}
+	diags := &Diagnostics{}
+	codegen(check(p.ast.Toplevels, sets, diags), sets, diags)
+	if !diags.HasErrors() || !strings.Contains(diags.List()[0].Message, "--memory=manual") {
+		t.Errorf("expected importing a library built with --memory=manual into a collected package to fail, got %v", diags.List())
+	}
+}
+
+func TestTypeInfoIsKept(t *testing.T) {
+	modu := codegenSource(t, "func Two() int64 => 2\n", settings{isLibrary: true, packageName: "kept"})
+
+	want := "@llvm.used = appending global [1 x i8*] [i8* bitcast ("
+	if !strings.Contains(modu, want) || !strings.Contains(modu, "* @"+reader.Symbol+" to i8*)]") {
+		t.Errorf("expected %s to be kept by llvm.used:\n%s", reader.Symbol, modu)
+	}
+}